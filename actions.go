@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -9,60 +11,282 @@ import (
 	"time"
 
 	"github.com/godbus/dbus/v5"
-	"github.com/shirou/gopsutil/v4/process"
 )
 
-func (pm *PillManager) connectToDbus() error {
+// Connects to a bus, retrying a few times
+func connectBus(bus string, connect func(...dbus.ConnOption) (*dbus.Conn, error)) (*dbus.Conn, error) {
 	maxRetries := 3
 	timeBetweenRetries := 2 * time.Second
-	if pm.dbusConn == nil {
-		for i := range maxRetries {
-			var err error
-			pm.dbusConn, err = dbus.ConnectSystemBus()
-			if err != nil {
-				Logger.Errorf("Couldn't connect to dbus (try %d/%d) %v", i+1, maxRetries, err)
-				time.Sleep(timeBetweenRetries)
-			} else {
-				Logger.Info("Connected to dbus")
-				break
-			}
+	for i := range maxRetries {
+		conn, err := connect()
+		if err == nil {
+			Logger.Infof("Connected to the %s bus", bus)
+			return conn, nil
 		}
+		Logger.Errorf("Couldn't connect to the %s bus (try %d/%d) %v", bus, i+1, maxRetries, err)
+		time.Sleep(timeBetweenRetries)
 	}
+	return nil, fmt.Errorf("Couldn't connect to the %s bus after %d tries.", bus, maxRetries)
+}
+
+// Connects to the system bus, if not connected yet
+func (pm *PillManager) connectToDbus() error {
 	if pm.dbusConn == nil {
-		return fmt.Errorf("Couldn't connect to dbus after %d tries.", maxRetries)
+		conn, err := connectBus("system", dbus.ConnectSystemBus)
+		if err != nil {
+			return err
+		}
+		pm.dbusConn = conn
+	}
+	return nil
+}
+
+// Connects to the session bus, if not connected yet
+func (pm *PillManager) connectToSessionBus() error {
+	if pm.sessionConn == nil {
+		conn, err := connectBus("session", dbus.ConnectSessionBus)
+		if err != nil {
+			return err
+		}
+		pm.sessionConn = conn
+	}
+	return nil
+}
+
+// Names of the backends whose data is cached until their service restarts
+var cachedBackends = map[string]string{
+	"tuned": "com.redhat.tuned",
+	"scx":   "org.scx.Loader",
+}
+
+// Returns the connection to the bus of a backend, system unless configured otherwise
+func (pm *PillManager) backendConn(backend string) (*dbus.Conn, error) {
+	bus := pm.backendBus[backend]
+
+	var conn *dbus.Conn
+	if bus == "session" {
+		if err := pm.connectToSessionBus(); err != nil {
+			return nil, err
+		}
+		conn = pm.sessionConn
 	} else {
-		return nil
+		bus = "system"
+		if err := pm.connectToDbus(); err != nil {
+			return nil, err
+		}
+		conn = pm.dbusConn
+	}
+
+	if name, cached := cachedBackends[backend]; cached {
+		pm.watchBusOwner(conn, bus, name)
 	}
+	return conn, nil
 }
 
 // Sets the TuneD profile, using dbus
 func (pm *PillManager) setTunedProfile(profile string) error {
-	err := pm.connectToDbus()
+	conn, err := pm.backendConn("tuned")
 	if err != nil {
-		return fmt.Errorf("Failed to connect to dbus : %v", err)
+		return fmt.Errorf("Failed to connect to dbus for TuneD : %v", err)
 	}
 
-	obj := pm.dbusConn.Object("com.redhat.tuned", "/Tuned")
+	obj := conn.Object("com.redhat.tuned", "/Tuned")
 	if obj == nil {
 		return fmt.Errorf("failed to connnect to TuneD. Is it running?")
 	}
 
-	validProfiles := obj.Call("com.redhat.tuned.control.profiles", 0).Body[0].([]string)
-	if !slices.Contains(validProfiles, profile) {
+	validProfiles, err := pm.tunedProfileList(obj)
+	if err != nil {
+		// TuneD can be reloading, the switch itself tells if it is really gone
+		Logger.Warnf("Couldn't get the list of TuneD profiles, switching to %s without checking it : %v", profile, err)
+	} else if !slices.Contains(validProfiles, profile) {
 		return fmt.Errorf("Invalid TuneD profile (%s)", profile)
 	}
 
 	return obj.Call("com.redhat.tuned.control.switch_profile", 0, profile).Err
 }
 
+// TuneD profile and profile selection mode before the pills switched it
+type tunedSnapshot struct {
+	Profile string `json:"profile"`
+	Mode    string `json:"mode"` // "auto" or "manual"
+}
+
+// Saves the TuneD profile and mode before the first switch of a pill, as switching makes it manual
+func (pm *PillManager) saveTunedMode() {
+	if pm.tunedSaved != nil {
+		return
+	}
+
+	conn, err := pm.backendConn("tuned")
+	if err != nil {
+		return
+	}
+	obj := conn.Object("com.redhat.tuned", "/Tuned")
+
+	var snapshot tunedSnapshot
+	var modeErr string
+	if err := obj.Call("com.redhat.tuned.control.profile_mode", 0).Store(&snapshot.Mode, &modeErr); err != nil {
+		// Older TuneD versions have no automatic mode
+		Logger.Debugf("Couldn't get the TuneD profile mode : %v", err)
+		return
+	}
+	if err := obj.Call("com.redhat.tuned.control.active_profile", 0).Store(&snapshot.Profile); err != nil {
+		Logger.Debugf("Couldn't get the active TuneD profile : %v", err)
+		return
+	}
+
+	pm.tunedSaved = &snapshot
+	pm.saveJournal()
+	Logger.Debugf("TuneD was on profile %s in %s mode", snapshot.Profile, snapshot.Mode)
+}
+
+// Gives TuneD back the mode it had before the pills. Automatic selection is enabled again,
+// a manual profile is only restored if the default pill doesn't set its own.
+func (pm *PillManager) restoreTunedMode(event *TransitionEvent, settings Pill) {
+	snapshot := pm.tunedSaved
+	if snapshot == nil {
+		return
+	}
+	pm.tunedSaved = nil
+
+	defaultTuned := settings.Tuned != ""
+	if snapshot.Mode != "auto" && (defaultTuned || snapshot.Profile == "") {
+		return
+	}
+
+	conn, err := pm.backendConn("tuned")
+	if err != nil {
+		pm.recordAction(event, "tuned", snapshot.Mode, fmt.Errorf("Failed to connect to dbus for TuneD : %v", err))
+		return
+	}
+	obj := conn.Object("com.redhat.tuned", "/Tuned")
+
+	if snapshot.Mode == "auto" {
+		err = obj.Call("com.redhat.tuned.control.auto_profile", 0).Err
+		if err != nil {
+			Logger.Errorf("Failed to enable the automatic TuneD profile selection : %v", err)
+		} else {
+			Logger.Info("TuneD profile selection set back to automatic")
+		}
+		pm.recordAction(event, "tuned", "auto", err)
+		delete(pm.applied, "tuned")
+		return
+	}
+
+	err = pm.setTunedProfile(snapshot.Profile)
+	if err != nil {
+		Logger.Errorf("Failed to restore TuneD profile %s : %v", snapshot.Profile, err)
+	} else {
+		Logger.Infof("TuneD profile restored to %s", snapshot.Profile)
+	}
+	pm.recordAction(event, "tuned", snapshot.Profile, err)
+}
+
+// Time after which the TuneD profiles are listed again
+const tunedProfilesTTL = 5 * time.Minute
+
+// Returns the TuneD profiles, listed again when the cache expired or TuneD restarted
+func (pm *PillManager) tunedProfileList(obj dbus.BusObject) ([]string, error) {
+	pm.drainBusOwners()
+
+	if pm.tunedProfiles != nil && time.Since(pm.tunedFetched) < tunedProfilesTTL {
+		return pm.tunedProfiles, nil
+	}
+
+	var profiles []string
+	if err := obj.Call("com.redhat.tuned.control.profiles", 0).Store(&profiles); err != nil {
+		return nil, err
+	}
+	pm.tunedProfiles, pm.tunedFetched = profiles, time.Now()
+	pm.saveBackend("tuned", savedBackend{Fetched: pm.tunedFetched, Profiles: profiles})
+	return profiles, nil
+}
+
+// Subscribes to the owner changes of a cached service, to know when its cache must be fetched again.
+// The connections send every signal to the same channel, they are told apart by the name they are about.
+func (pm *PillManager) watchBusOwner(conn *dbus.Conn, bus string, name string) {
+	key := bus + " " + name
+	if slices.Contains(pm.busWatched, key) {
+		return
+	}
+
+	err := conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, name),
+	)
+	if err != nil {
+		Logger.Debugf("Couldn't watch the %s name on the %s bus, it won't be cached : %v", name, bus, err)
+		return
+	}
+
+	if pm.busOwners == nil {
+		pm.busOwners = make(chan *dbus.Signal, 8)
+	}
+	if !slices.Contains(pm.busSignals, bus) {
+		conn.Signal(pm.busOwners)
+		pm.busSignals = append(pm.busSignals, bus)
+	}
+	pm.busWatched = append(pm.busWatched, key)
+}
+
+// Whether the owner changes of a cached backend are watched on its bus
+func (pm *PillManager) backendWatched(backend string) bool {
+	bus := pm.backendBus[backend]
+	if bus == "" {
+		bus = "system"
+	}
+	return slices.Contains(pm.busWatched, bus+" "+cachedBackends[backend])
+}
+
+// Drops the cache of the services whose owner changed since the last call
+func (pm *PillManager) drainBusOwners() {
+	for {
+		select {
+		case signal := <-pm.busOwners:
+			if signal.Name != "org.freedesktop.DBus.NameOwnerChanged" || len(signal.Body) == 0 {
+				continue
+			}
+			switch signal.Body[0] {
+			case "com.redhat.tuned":
+				pm.tunedProfiles = nil
+				delete(pm.applied, "tuned")
+			case "org.scx.Loader":
+				pm.scxCaps = nil
+				delete(pm.applied, "scx")
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Parses the scx option, a scheduler name and an optional mode, or none. Mode 0 is Auto.
+// Whether scx_loader supports them is only known when the pill is eaten.
+func parseScx(value string) (sched string, mode uint, err error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 || (fields[0] == "none" && len(fields) > 1) {
+		return "", 0, fmt.Errorf("expected a scheduler name and an optional mode, like scx_lavd 1, got '%s'", value)
+	}
+	if len(fields) == 2 {
+		i, err := strconv.Atoi(fields[1])
+		if err != nil || i < 0 {
+			return "", 0, fmt.Errorf("invalid scheduler mode '%s', expected a number like 1 for Gaming", fields[1])
+		}
+		mode = uint(i)
+	}
+	return fields[0], mode, nil
+}
+
 // Change the SCX scheduler, using dbus
 func (pm *PillManager) setScx(scx string) error {
-	err := pm.connectToDbus()
+	conn, err := pm.backendConn("scx")
 	if err != nil {
-		return fmt.Errorf("Failed to connect to dbus : %v", err)
+		return fmt.Errorf("Failed to connect to dbus for scx_loader : %v", err)
 	}
 
-	obj := pm.dbusConn.Object("org.scx.Loader", "/org/scx/Loader")
+	obj := conn.Object("org.scx.Loader", "/org/scx/Loader")
 	if obj == nil {
 		return fmt.Errorf("Couldn't connect to scx_loader, is it running ?")
 	}
@@ -72,78 +296,723 @@ func (pm *PillManager) setScx(scx string) error {
 		return obj.Call("org.scx.Loader.StopScheduler", 0).Err
 	}
 
-	// Split the config string
-	args := strings.Split(scx, " ")
-	sched := args[0]
+	sched, mode, err := parseScx(scx)
+	if err != nil {
+		return err
+	}
 
 	// Checking if the scheduler is supported by scx_loader
-	request, err := obj.GetProperty("org.scx.Loader.SupportedSchedulers")
+	caps, err := pm.scxCapabilities(obj)
 	if err != nil {
-		return fmt.Errorf("Couldn't get the list of schedulers from scx_loader")
+		return fmt.Errorf("Couldn't get the list of schedulers from scx_loader : %w", err)
 	}
 
-	supportedSchedulers := request.Value().([]string)
-	if !slices.Contains(supportedSchedulers, sched) {
+	if !slices.Contains(caps.schedulers, sched) {
 		return fmt.Errorf("Invalid scheduler (%s)", sched)
 	}
 
 	// Checking the scheduler mode if one is specified, if not use 0
-	var mode uint
-	if len(args) > 1 {
-		i, err := strconv.Atoi(args[1])
-		if err != nil || i < 0 || i > 4 {
-			Logger.Errorf("Wrong scheduler mode %s using default (0)", args[1])
-			mode = 0
-		} else {
-			mode = uint(i)
-		}
-	} else {
-		mode = 0
+	if mode != 0 && !slices.Contains(caps.modes, mode) {
+		return fmt.Errorf("Scheduler mode %d is not supported by scx_loader (supported modes: %v)", mode, caps.modes)
 	}
 
 	// Executing the scheduler switch
 	return obj.Call("org.scx.Loader.SwitchScheduler", 0, sched, mode).Err
 }
 
-// Check a process and its parent, and determines if it is elligible to being reniced
-func (pm *PillManager) reniceCheck(p *process.Process, nice int) {
+// What the running scx_loader supports, it only changes when scx_loader restarts
+type scxCapabilities struct {
+	schedulers []string
+	modes      []uint
+}
+
+// Returns the schedulers and modes supported by scx_loader, read with a single call and cached until it restarts.
+// They are read on every call when the owner changes of scx_loader can't be watched.
+func (pm *PillManager) scxCapabilities(obj dbus.BusObject) (*scxCapabilities, error) {
+	pm.drainBusOwners()
+	if pm.scxCaps != nil {
+		return pm.scxCaps, nil
+	}
+
+	props, err := scxProperties(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	schedulers, ok := props["SupportedSchedulers"].Value().([]string)
+	if !ok {
+		return nil, fmt.Errorf("scx_loader didn't return its SupportedSchedulers")
+	}
+
+	caps := &scxCapabilities{schedulers: schedulers, modes: scxModes(props["SupportedModes"])}
+	if pm.backendWatched("scx") {
+		pm.scxCaps = caps
+		pm.saveBackend("scx", savedBackend{Fetched: time.Now(), Schedulers: caps.schedulers, Modes: caps.modes})
+	}
+	return caps, nil
+}
+
+// Reads every property of scx_loader at once, CurrentScheduler included
+func scxProperties(obj dbus.BusObject) (map[string]dbus.Variant, error) {
+	var props map[string]dbus.Variant
+	err := obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, "org.scx.Loader").Store(&props)
+	return props, err
+}
+
+// Modes known to every scx_loader version: auto, gaming, power save, low latency and server
+var defaultScxModes = []uint{0, 1, 2, 3, 4}
+
+// Returns the scheduler modes supported by scx_loader. Versions not listing them get the static range.
+func scxModes(property dbus.Variant) []uint {
+	var modes []uint
+	switch value := property.Value().(type) {
+	case []uint32:
+		for _, m := range value {
+			modes = append(modes, uint(m))
+		}
+	case []uint8:
+		for _, m := range value {
+			modes = append(modes, uint(m))
+		}
+	default:
+		return defaultScxModes
+	}
+	return modes
+}
+
+// Returns the power-profiles-daemon object, preferring the current bus name over the legacy one
+func (pm *PillManager) ppdObject() (dbus.BusObject, string, error) {
+	conn, err := pm.backendConn("ppd")
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to connect to dbus for power-profiles-daemon : %v", err)
+	}
+
+	names := []struct{ name, path string }{
+		{"org.freedesktop.UPower.PowerProfiles", "/org/freedesktop/UPower/PowerProfiles"},
+		{"net.hadess.PowerProfiles", "/net/hadess/PowerProfiles"},
+	}
+
+	for _, n := range names {
+		var hasOwner bool
+		err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, n.name).Store(&hasOwner)
+		if err == nil && hasOwner {
+			return conn.Object(n.name, dbus.ObjectPath(n.path)), n.name, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("Couldn't connect to power-profiles-daemon : %w", errMissingBackend)
+}
+
+// Sets the power-profiles-daemon profile, using dbus.
+// Profiles that can be held are held for as long as the pill is active, so that the daemon
+// restores the previous profile by itself if process_pillz dies without reverting.
+func (pm *PillManager) setPowerProfile(profile string, pillName string) error {
+	obj, iface, err := pm.ppdObject()
+	if err != nil {
+		return err
+	}
+
+	// Drop the previous hold before taking a new one
+	if err := pm.releasePowerProfile(); err != nil {
+		Logger.Warnf("Failed to release the previous power profile hold : %v", err)
+	}
+
+	// Only performance and power-saver can be held, anything else is set directly
+	if profile == "performance" || profile == "power-saver" {
+		var cookie uint32
+		call := obj.Call(iface+".HoldProfile", 0, profile, "Pill "+pillName, "process_pillz")
+		err := call.Store(&cookie)
+		if err == nil {
+			pm.ppdCookie = cookie
+			pm.ppdHeld = true
+			return nil
+		}
+
+		var dbusErr dbus.Error
+		if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+			return fmt.Errorf("Couldn't hold power profile %s : %v", profile, err)
+		}
+		Logger.Warn("power-profiles-daemon doesn't support HoldProfile, switching the profile instead")
+	}
+
+	return obj.SetProperty(iface+".ActiveProfile", dbus.MakeVariant(profile))
+}
+
+// Releases the power-profiles-daemon profile hold, if there is one
+func (pm *PillManager) releasePowerProfile() error {
+	if !pm.ppdHeld {
+		return nil
+	}
+
+	// Whatever happens the cookie is useless now, the daemon drops holds of dead connections
+	pm.ppdHeld = false
+
+	obj, iface, err := pm.ppdObject()
+	if err != nil {
+		return err
+	}
+
+	return obj.Call(iface+".ReleaseProfile", 0, pm.ppdCookie).Err
+}
+
+// Processes of the trigger's tree above which the per-process settings are refused, when not configured
+const defaultMaxTreeSize = 64
+
+// A process that joined the trigger's tree during a scan, waiting for the per-process settings
+type treeMember struct {
+	p          Proc
+	procInfo   *ProcessInfo
+	parentInfo *ProcessInfo // Parent, if it is part of the tree
+}
+
+// Check a process and its parent, and determines if it is part of the trigger's tree.
+// Returns the process if it just joined the tree.
+func (pm *PillManager) treeCheck(p Proc, tree treeSettings) *treeMember {
 
 	// Get cached process info if available
-	procInfo, exists := pm.knownProcs[p.Pid]
+	procInfo, exists := pm.knownProcs[p.PID()]
 	if !exists {
-		Logger.Warnf("Process %d not found in cache during renice check", p.Pid)
+		Logger.Warnf("Process %d not found in cache during tree check", p.PID())
+		return nil
+	}
+
+	if procInfo.InTree {
+		return nil
+	}
+
+	if procInfo.blacklisted {
+		return nil
+	}
+
+	// Processes named like the trigger are reniced wherever they come from
+	if tree.isNice && tree.niceName && !procInfo.Reniced && p.PID() != pm.currentProc {
+		if triggerInfo, exists := pm.knownProcs[pm.currentProc]; exists && procInfo.Name == triggerInfo.Name && !pm.ownProcess(p, procInfo) {
+			pm.renice(p.PID(), procInfo, nil, tree)
+		}
+	}
+
+	// Get parent process info
+	pParent, err := p.Parent()
+	if err != nil {
+		Logger.Warnf("Couldn't get the parent of %d : %v", p.PID(), err)
+		return nil
+	}
+
+	// Check if parent is part of the tree
+	parentInfo, parentExists := pm.knownProcs[pParent.PID()]
+	parentInTree := parentExists && parentInfo.InTree
+
+	// the iterated proc, its sibling and chidren are part of the tree
+	if !parentInTree && pParent.PID() != pm.currentParent && p.PID() != pm.currentProc {
+		return nil
+	}
+	// Like the daemon itself, when started next to the trigger
+	if pm.ownProcess(p, procInfo) {
+		Logger.Debugf("%s (PID %d) is in the tree of the trigger but was started by process_pillz, leaving it", procInfo.Name, p.PID())
+		return nil
+	}
+	procInfo.InTree = true
+
+	if !parentInTree {
+		parentInfo = nil
+	}
+	return &treeMember{p: p, procInfo: procInfo, parentInfo: parentInfo}
+}
+
+// Applies the per-process settings of the current pill to the processes that joined the tree,
+// unless the tree grew suspiciously large
+func (pm *PillManager) applyTree(members []*treeMember, tree treeSettings) {
+	if tree.gpuOnly && !pm.dryRun {
+		members = pm.gpuMembers(members)
+	}
+	// The processes left by the budget of the last scan go first
+	members = append(pm.takeTreeBacklog(), members...)
+	if len(members) == 0 {
 		return
 	}
 
-	if procInfo.Reniced {
+	if pm.maxTreeSize > 0 {
+		size := 0
+		for _, procInfo := range pm.knownProcs {
+			if procInfo.InTree {
+				size++
+			}
+		}
+
+		if size > pm.maxTreeSize {
+			if !pm.treeRefused {
+				Logger.Warnf("The tree of trigger %d (root %d) has %d processes, more than max_tree_size (%d). Not applying the per-process settings, raise max_tree_size if this is expected",
+					pm.currentProc, pm.currentParent, size, pm.maxTreeSize)
+				pm.treeRefused = true
+			}
+			return
+		}
+	}
+
+	ordered := pm.prioritizeMembers(members)
+	spent := 0
+	for i, member := range ordered {
+		pid, procInfo := member.p.PID(), member.procInfo
+
+		// The trigger itself always matches its own name
+		niceMatched := tree.niceTree || (tree.niceName && pid == pm.currentProc)
+		renice := tree.isNice && niceMatched && !procInfo.Reniced
+		slack := tree.timerSlack > 0 && !procInfo.SlackSet
+		numa := tree.isNuma && !procInfo.NumaMoved
+
+		// At least one process is changed per scan, whatever the budget
+		ops := 0
+		for _, op := range []bool{renice, slack, numa} {
+			if op {
+				ops++
+			}
+		}
+		if spent > 0 && spent+ops > tree.budget {
+			pm.treeBacklog = ordered[i:]
+			Logger.Debugf("renice_budget of pill %s reached, %d processes of the tree are left to the next scan", pm.CurrentPill, len(pm.treeBacklog))
+			return
+		}
+		spent += ops
+
+		if renice {
+			pm.renice(pid, procInfo, member.parentInfo, tree)
+		}
+
+		if slack {
+			pm.setTimerSlack(pid, procInfo, tree.timerSlack)
+		}
+
+		if numa {
+			pm.moveToNumaNode(pid, procInfo, tree.numaNode)
+		}
+	}
+}
+
+// Renices a process of the trigger's tree, on the tree worker
+func (pm *PillManager) renice(pid int32, procInfo *ProcessInfo, parentInfo *ProcessInfo, tree treeSettings) {
+	name := procInfo.Name
+	reniceGroup, groupNice, groupEffective := pm.reniceGroup, pm.groupNice, pm.groupEffective
+
+	// The pill wins over the focus boost, and restores the value from before the boost
+	boostedFrom, boosted := pm.focusBoosted[pid]
+	delete(pm.focusBoosted, pid)
+
+	pm.queueTreeJob(func() func() {
+		// Members of the reniced process group already got the pill's nice value
+		if reniceGroup != 0 {
+			if stat, err := readProcStat(pid); err == nil && stat.Pgrp == reniceGroup {
+				return func() {
+					procInfo.Reniced = true
+					procInfo.groupReniced = true
+					procInfo.OriginalNice = groupNice
+					procInfo.EffectiveNice = groupEffective
+				}
+			}
+		}
+
+		original, err := getNice(pid)
+		if err != nil {
+			Logger.Warnf("Couldn't get nice value of %s (PID %d) : %v", name, pid, err)
+			return nil
+		}
+		if boosted {
+			original = boostedFrom
+		}
+
+		nice := tree.niceFor(original)
+		err = setPriority(syscall.PRIO_PROCESS, int(pid), nice)
+		if err != nil {
+			Logger.Warnf("Couldn't change nice value of %s (PID %d) : %v", name, pid, err)
+			return nil
+		}
+
+		effective, err := getNice(pid)
+		if err != nil {
+			effective = nice
+		}
+
+		return func() {
+			// Children forked after their parent was reniced inherited its value, not the original one
+			if parentInfo != nil && parentInfo.Reniced && original == parentInfo.EffectiveNice {
+				original = parentInfo.OriginalNice
+
+				// A delta applies to the original value, not to the inherited one
+				if tree.niceDelta && tree.niceFor(original) != nice {
+					nice = tree.niceFor(original)
+					if err := setPriority(syscall.PRIO_PROCESS, int(pid), nice); err == nil {
+						effective = pm.effectiveNice(pid, nice)
+					}
+				}
+			}
+
+			// Mark process as reniced
+			procInfo.Reniced = true
+			procInfo.OriginalNice = original
+			procInfo.EffectiveNice = effective
+			pm.warnClamped(nice, effective)
+			Logger.Infof("reniced %s (PID %d) to %d", name, pid, effective)
+		}
+	})
+}
+
+// Whether the nice value of a reniced process was changed since by someone else, like the user.
+// It is logged, as process_pillz leaves it alone.
+func renicedByOthers(pid int32, procInfo *ProcessInfo) bool {
+	current, err := getNice(pid)
+	if err != nil || current == procInfo.EffectiveNice {
+		return false
+	}
+
+	Logger.Infof("%s (PID %d) was reniced to %d by someone else, leaving it", procInfo.Name, pid, current)
+	return true
+}
+
+// Renices back the processes of the tree whose nice value was changed by someone else,
+// for the pills enforcing their nice value. The check runs on the tree worker.
+func (pm *PillManager) enforceNice() {
+	type reniced struct {
+		pid       int32
+		name      string
+		effective int
+	}
+
+	var members []reniced
+	for pid, procInfo := range pm.knownProcs {
+		if procInfo.Reniced && pm.currentScan[pid] {
+			members = append(members, reniced{pid, procInfo.Name, procInfo.EffectiveNice})
+		}
+	}
+	if len(members) == 0 {
 		return
 	}
 
-	if slices.Contains(pm.blacklist, procInfo.Name) {
+	pm.queueTreeJob(func() func() {
+		for _, member := range members {
+			current, err := getNice(member.pid)
+			if err != nil || current == member.effective {
+				continue
+			}
+			if err := setPriority(syscall.PRIO_PROCESS, int(member.pid), member.effective); err == nil {
+				Logger.Debugf("%s (PID %d) was reniced to %d by someone else, enforcing %d", member.name, member.pid, current, member.effective)
+			}
+		}
+		return nil
+	})
+}
+
+// Reads back the nice value of a reniced process, which RLIMIT_NICE can clamp
+func (pm *PillManager) effectiveNice(pid int32, requested int) int {
+	effective, err := getNice(pid)
+	if err != nil {
+		return requested
+	}
+
+	pm.warnClamped(requested, effective)
+	return effective
+}
+
+// Warns once per pill when the nice value read back isn't the requested one
+func (pm *PillManager) warnClamped(requested int, effective int) {
+	if effective != requested && !pm.niceClamped {
+		Logger.Warnf("Nice value %d was clamped to %d, RLIMIT_NICE doesn't allow lower values. Raise it with LimitNICE= in the service, or in /etc/security/limits.conf", requested, effective)
+		pm.niceClamped = true
+	}
+}
+
+// Sets the timer slack of a process of the trigger's tree, on the tree worker
+func (pm *PillManager) setTimerSlack(pid int32, procInfo *ProcessInfo, timerSlack time.Duration) {
+	if pm.slackBroken {
 		return
 	}
 
-	// Get parent process info
-	pParent, err := p.Parent()
+	pm.queueTreeJob(func() func() {
+		original, err := readTimerSlack(pid)
+		if err == nil {
+			err = writeTimerSlack(pid, timerSlack.Nanoseconds())
+		}
+
+		return func() {
+			if err != nil {
+				// Old kernels and containers don't allow it at all, no need to insist
+				if pm.slackBroken {
+					return
+				}
+				if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+					Logger.Warnf("Timer slack can't be changed on this system, ignoring timer_slack : %v", err)
+					pm.slackBroken = true
+				} else {
+					Logger.Warnf("Couldn't change timer slack of %s (PID %d) : %v", procInfo.Name, pid, err)
+				}
+				return
+			}
+
+			procInfo.SlackSet = true
+			procInfo.OriginalSlack = original
+			Logger.Infof("set timer slack of %s (PID %d) to %s", procInfo.Name, pid, timerSlack)
+		}
+	})
+}
+
+// Moves the memory of a process of the trigger's tree to a NUMA node, on the tree worker.
+// The cgroup of the trigger is bound to the node first, for what the tree allocates next.
+func (pm *PillManager) moveToNumaNode(pid int32, procInfo *ProcessInfo, node int) {
+	if pm.numaNodes == nil {
+		nodes, err := onlineNumaNodes()
+		if err != nil {
+			Logger.Warnf("Couldn't read the NUMA nodes, ignoring numa_node : %v", err)
+			nodes = []int{}
+		} else if len(nodes) < 2 {
+			Logger.Info("Single NUMA node system, ignoring numa_node")
+		}
+		pm.numaNodes = nodes
+	}
+
+	if len(pm.numaNodes) < 2 {
+		return
+	}
+
+	if !slices.Contains(pm.numaNodes, node) {
+		Logger.Errorf("NUMA node %d is not online", node)
+		return
+	}
+
+	if pid == pm.currentProc && !pm.numaBound {
+		pm.bindNumaCgroup(pid, node)
+	}
+
+	var others []int
+	for _, n := range pm.numaNodes {
+		if n != node {
+			others = append(others, n)
+		}
+	}
+
+	name := procInfo.Name
+	pm.queueTreeJob(func() func() {
+		// Where the pages were, they are moved back there on revert
+		from, _ := numaPageNodes(pid)
+		from = slices.DeleteFunc(from, func(n int) bool { return n == node })
+
+		err := migratePages(pid, others, []int{node})
+		if err != nil {
+			Logger.Warnf("Couldn't move memory of %s (PID %d) to NUMA node %d : %v", name, pid, node, err)
+			return nil
+		}
+
+		return func() {
+			procInfo.NumaMoved = true
+			procInfo.NumaNode, procInfo.NumaFrom = node, from
+			Logger.Infof("moved memory of %s (PID %d) to NUMA node %d", name, pid, node)
+		}
+	})
+}
+
+// Renices the whole process group of the trigger with a single syscall, when every member of
+// the group belongs to the trigger's tree. Members of the tree outside of the group are reniced
+// one by one by treeCheck.
+func (pm *PillManager) reniceTriggerGroup(tree treeSettings) {
+	trigger, err := readProcStat(pm.currentProc)
 	if err != nil {
-		Logger.Warnf("Couldn't get the parent of %d : %v", p.Pid, err)
 		return
 	}
 
-	// Check if parent has been reniced
-	parentInfo, parentExists := pm.knownProcs[pParent.Pid]
-	parentReniced := parentExists && parentInfo.Reniced
+	pgid := trigger.Pgrp
+	if pgid <= 1 || pgid == int32(syscall.Getpgrp()) {
+		return
+	}
 
-	// renicing the iterated proc, its sibling and chidren too, if a valid nice value is provided
-	if parentReniced || pParent.Pid == pm.currentParent || p.Pid == pm.currentProc {
-		err = syscall.Setpriority(syscall.PRIO_PROCESS, int(p.Pid), nice)
+	// Parents and groups of the known processes
+	stats := make(map[int32]procStat, len(pm.knownProcs))
+	for pid := range pm.knownProcs {
+		if stat, err := readProcStat(pid); err == nil {
+			stats[pid] = stat
+		}
+	}
+
+	originals := make(map[int32]int)
+	for pid, stat := range stats {
+		if stat.Pgrp != pgid {
+			continue
+		}
+
+		if pm.knownProcs[pid].blacklisted || !pm.inTriggerTree(pid, stats) {
+			Logger.Debugf("Process group %d is not limited to the trigger's tree, renicing processes one by one", pgid)
+			return
+		}
+
+		original, err := getNice(pid)
 		if err != nil {
-			Logger.Warnf("Couldn't change nice value of %s (PID %d) : %v", procInfo.Name, p.Pid, err)
 			return
 		}
+		originals[pid] = original
+	}
+
+	if pm.maxTreeSize > 0 && len(originals) > pm.maxTreeSize {
+		Logger.Debugf("Process group %d has more than max_tree_size processes, renicing processes one by one", pgid)
+		return
+	}
+
+	// A single original value is needed to restore the group at once
+	groupNice := originals[pm.currentProc]
+	for _, original := range originals {
+		if original != groupNice {
+			Logger.Debugf("Members of process group %d have different nice values, renicing processes one by one", pgid)
+			return
+		}
+	}
 
-		// Mark process as reniced
+	nice := tree.niceFor(groupNice)
+	err = setPriority(syscall.PRIO_PGRP, int(pgid), nice)
+	if err != nil {
+		Logger.Warnf("Couldn't change nice value of process group %d : %v", pgid, err)
+		return
+	}
+
+	effective := pm.effectiveNice(pm.currentProc, nice)
+	for pid := range originals {
+		procInfo := pm.knownProcs[pid]
 		procInfo.Reniced = true
-		Logger.Infof("reniced %s (PID %d) to %d", procInfo.Name, p.Pid, nice)
+		procInfo.groupReniced = true
+		procInfo.OriginalNice = groupNice
+		procInfo.EffectiveNice = effective
+	}
+
+	pm.reniceGroup = pgid
+	pm.groupNice = groupNice
+	pm.groupEffective = effective
+	Logger.Infof("reniced process group %d (%d processes) to %d", pgid, len(originals), effective)
+}
+
+// Whether a process is the trigger, one of its siblings, or a descendant of them
+func (pm *PillManager) inTriggerTree(pid int32, stats map[int32]procStat) bool {
+	for current := pid; current > 1; {
+		if current == pm.currentProc {
+			return true
+		}
+
+		stat, exists := stats[current]
+		if !exists {
+			return false
+		}
+
+		if stat.Ppid == pm.currentParent {
+			return true
+		}
+		current = stat.Ppid
+	}
+	return false
+}
+
+// Whether a restore failed because the process already exited, leaving nothing to restore
+func processGone(err error) bool {
+	return errors.Is(err, syscall.ESRCH) || errors.Is(err, os.ErrNotExist)
+}
+
+// Restores the per-process settings changed by the current pill. The renices still queued
+// are applied first, so that they are restored too.
+func (pm *PillManager) restoreProcesses(event *TransitionEvent) {
+	pm.flushTree()
+
+	// Nice values changed by someone else since are left alone, unless the pill enforces its own
+	enforce := pm.getTreeSettings(pm.CurrentPill).enforceNice
+	external := make(map[int32]bool)
+	if !enforce {
+		for pid, procInfo := range pm.knownProcs {
+			if procInfo.Reniced && renicedByOthers(pid, procInfo) {
+				external[pid] = true
+			}
+		}
+	}
+
+	var niceRestored, niceFailed, slackRestored, slackFailed, numaRestored, numaFailed, hudRestored, hudFailed int
+	var niceErr, slackErr, numaErr, hudErr error
+
+	// The cgroup first, so that the pages moved back stay where they go
+	bound := pm.numaCgroup != ""
+	if err := pm.restoreNumaCgroup(); err != nil && !processGone(err) {
+		Logger.Debugf("Couldn't restore the NUMA nodes of the trigger's cgroup : %v", err)
+		numaFailed, numaErr = numaFailed+1, err
+	} else if err == nil && bound {
+		numaRestored++
+	}
+
+	groupRestored := false
+	if pm.reniceGroup != 0 && len(external) > 0 {
+		Logger.Debugf("Members of process group %d were reniced by someone else, restoring processes one by one", pm.reniceGroup)
+	} else if pm.reniceGroup != 0 {
+		err := setPriority(syscall.PRIO_PGRP, int(pm.reniceGroup), pm.groupNice)
+		if err != nil && !processGone(err) {
+			Logger.Warnf("Couldn't restore nice value of process group %d : %v", pm.reniceGroup, err)
+		} else if err == nil {
+			groupRestored = true
+			Logger.Infof("restored process group %d to %d", pm.reniceGroup, pm.groupNice)
+		}
 	}
+
+	for pid, procInfo := range pm.knownProcs {
+		if procInfo.Reniced && procInfo.groupReniced && groupRestored {
+			niceRestored++
+		} else if procInfo.Reniced && !external[pid] {
+			err := setPriority(syscall.PRIO_PROCESS, int(pid), procInfo.OriginalNice)
+			if err == nil {
+				niceRestored++
+			} else if !processGone(err) {
+				Logger.Debugf("Couldn't restore nice value of %s (PID %d) : %v", procInfo.Name, pid, err)
+				niceFailed, niceErr = niceFailed+1, err
+			}
+		}
+
+		if procInfo.SlackSet {
+			err := writeTimerSlack(pid, procInfo.OriginalSlack)
+			if err == nil {
+				slackRestored++
+			} else if !processGone(err) {
+				Logger.Debugf("Couldn't restore timer slack of %s (PID %d) : %v", procInfo.Name, pid, err)
+				slackFailed, slackErr = slackFailed+1, err
+			}
+		}
+
+		if procInfo.NumaMoved && len(procInfo.NumaFrom) > 0 {
+			err := migratePages(pid, []int{procInfo.NumaNode}, procInfo.NumaFrom)
+			if err == nil {
+				numaRestored++
+			} else if !processGone(err) {
+				Logger.Debugf("Couldn't move back memory of %s (PID %d) : %v", procInfo.Name, pid, err)
+				numaFailed, numaErr = numaFailed+1, err
+			}
+		}
+
+		if procInfo.HudToggled {
+			err := restoreHud(pid)
+			if err == nil {
+				hudRestored++
+			} else if !processGone(err) {
+				Logger.Debugf("Couldn't toggle back the MangoHud overlay of %s (PID %d) : %v", procInfo.Name, pid, err)
+				hudFailed, hudErr = hudFailed+1, err
+			}
+		}
+
+		procInfo.InTree = false
+		procInfo.Reniced = false
+		procInfo.groupReniced = false
+		procInfo.SlackSet = false
+		procInfo.NumaMoved = false
+		procInfo.NumaFrom = nil
+		procInfo.HudToggled = false
+	}
+
+	event.addRestore("nice", niceRestored, niceFailed, niceErr)
+	event.addRestore("timer_slack", slackRestored, slackFailed, slackErr)
+	event.addRestore("numa_node", numaRestored, numaFailed, numaErr)
+	event.addRestore("mangohud", hudRestored, hudFailed, hudErr)
+
+	pm.reniceGroup = 0
+	pm.groupNice = 0
+	pm.groupEffective = 0
+	pm.treeRefused = false
+	clear(pm.gpuWaiting)
+	pm.gpuProbed = false
+	clear(pm.hudWaiting)
+	pm.hudWarned = false
+	pm.treeBacklog = nil
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// scx_loader on the bus, counting the calls it gets
+type fakeScxLoader struct {
+	dbus.BusObject
+	calls map[string]int
+	props map[string]dbus.Variant
+}
+
+func (o *fakeScxLoader) Call(method string, flags dbus.Flags, args ...any) *dbus.Call {
+	return o.CallWithContext(context.Background(), method, flags, args...)
+}
+
+func (o *fakeScxLoader) CallWithContext(_ context.Context, method string, _ dbus.Flags, _ ...any) *dbus.Call {
+	o.calls[method]++
+	if method == "org.freedesktop.DBus.Properties.GetAll" {
+		return &dbus.Call{Body: []any{o.props}}
+	}
+	return &dbus.Call{}
+}
+
+func TestScxCapabilitiesCache(t *testing.T) {
+	pm, _ := newFakeManager(t, fakeConfig)
+	pm.busWatched = append(pm.busWatched, "system "+cachedBackends["scx"])
+	pm.busOwners = make(chan *dbus.Signal, 8)
+	loader := &fakeScxLoader{calls: make(map[string]int), props: map[string]dbus.Variant{
+		"SupportedSchedulers": dbus.MakeVariant([]string{"scx_lavd", "scx_rusty"}),
+		"SupportedModes":      dbus.MakeVariant([]uint32{0, 1, 2}),
+		"CurrentScheduler":    dbus.MakeVariant("scx_rusty"),
+	}}
+
+	// Every property comes with one call, then the cache answers
+	for range 5 {
+		caps, err := pm.scxCapabilities(loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(caps.schedulers) != 2 || len(caps.modes) != 3 {
+			t.Fatalf("capabilities %+v, want 2 schedulers and 3 modes", caps)
+		}
+	}
+	if n := loader.calls["org.freedesktop.DBus.Properties.GetAll"]; n != 1 || len(loader.calls) != 1 {
+		t.Errorf("calls %v, want a single GetAll", loader.calls)
+	}
+
+	// scx_loader restarting may support other schedulers
+	pm.busOwners <- &dbus.Signal{Name: "org.freedesktop.DBus.NameOwnerChanged", Body: []any{"org.scx.Loader", ":1.42", ":1.43"}}
+	loader.props["SupportedSchedulers"] = dbus.MakeVariant([]string{"scx_lavd", "scx_rusty", "scx_bpfland"})
+	caps, err := pm.scxCapabilities(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := loader.calls["org.freedesktop.DBus.Properties.GetAll"]; n != 2 || len(caps.schedulers) != 3 {
+		t.Errorf("%d GetAll calls for schedulers %v after the restart, want 2 for 3 schedulers", n, caps.schedulers)
+	}
+
+	// Without watching its owner, nothing tells a restart, it is read every time
+	pm.busWatched = nil
+	pm.scxCaps = nil
+	pm.scxCapabilities(loader)
+	pm.scxCapabilities(loader)
+	if n := loader.calls["org.freedesktop.DBus.Properties.GetAll"]; n != 4 {
+		t.Errorf("%d GetAll calls without the owner watched, want 4", n)
+	}
+}
+
+const groupConfig = `
+scan_interval: 1
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {nice: "=5"}
+`
+
+// The process group of the trigger is reniced at once when it is limited to the trigger's tree,
+// and restored at once unless a member was reniced by someone else. The members of the tree
+// outside of the group, and the groups that can't be changed at once, are reniced one by one.
+func TestReniceTriggerGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(source *fakeSource, sys *fakeSystem)
+		external int32 // Member reniced by someone else before the trigger exits
+		group    bool
+		renices  []string
+		restores []string
+	}{
+		{
+			name:     "group inside the tree",
+			group:    true,
+			renices:  []string{"nice group 100 5", "nice 102 5"},
+			restores: []string{"nice group 100 0", "nice 102 3"},
+		},
+		{
+			name: "group past the tree",
+			setup: func(source *fakeSource, sys *fakeSystem) {
+				sys.proc(source.spawn(60, 1, "sh", "sh"), 100, 0, 0)
+			},
+			renices:  []string{"nice 100 5", "nice 101 5", "nice 102 5"},
+			restores: []string{"nice 101 0", "nice 102 3"},
+		},
+		{
+			name: "mixed nice values in the group",
+			setup: func(source *fakeSource, sys *fakeSystem) {
+				sys.nice[101] = 2
+			},
+			renices:  []string{"nice 100 5", "nice 101 5", "nice 102 5"},
+			restores: []string{"nice 101 2", "nice 102 3"},
+		},
+		{
+			name:     "member reniced by someone else",
+			external: 101,
+			group:    true,
+			renices:  []string{"nice group 100 5", "nice 102 5"},
+			restores: []string{"nice 102 3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm, source := newFakeManager(t, groupConfig)
+			calls := new(callLog)
+			sys := newFakeSystem(t, calls)
+			pm.backend = fakeBackend{calls}
+			pm.dryRun = false
+
+			// The game and its renderer in its group, a crash handler in another one
+			sys.proc(source.spawn(100, 50, "zzgame", "zzgame"), 100, 0, 0)
+			sys.proc(source.spawn(101, 100, "render", "render"), 100, 0, 0)
+			sys.proc(source.spawn(102, 100, "crashpad", "crashpad_handler"), 102, 3, 0)
+			if tt.setup != nil {
+				tt.setup(source, sys)
+			}
+
+			expectPill(t, pm, "game", 100)
+			expectPill(t, pm, "game", 100)
+			pm.flushTree()
+			if group := pm.reniceGroup == 100; group != tt.group {
+				t.Fatalf("process group %d reniced, want it reniced at once: %t", pm.reniceGroup, tt.group)
+			}
+			if renices := sortedNice(calls.list()); !slices.Equal(renices, tt.renices) {
+				t.Fatalf("renices %q, want %q", renices, tt.renices)
+			}
+			for _, pid := range []int32{100, 101, 102} {
+				if !pm.knownProcs[pid].Reniced || sys.nice[pid] != 5 {
+					t.Fatalf("PID %d at nice %d, reniced %t", pid, sys.nice[pid], pm.knownProcs[pid].Reniced)
+				}
+			}
+
+			if tt.external != 0 {
+				sys.nice[tt.external] = 10
+			}
+			calls.reset()
+			source.exit(100)
+			expectPill(t, pm, "default", 0)
+			if restores := sortedNice(calls.list()); !slices.Equal(restores, tt.restores) {
+				t.Errorf("restores %q, want %q", restores, tt.restores)
+			}
+			if tt.external != 0 && sys.nice[tt.external] != 10 {
+				t.Errorf("PID %d reniced by someone else restored to %d", tt.external, sys.nice[tt.external])
+			}
+		})
+	}
+}
+
+// The nice calls of a fake system, the group ones first, as the per-process ones come in any order
+func sortedNice(calls []string) []string {
+	var nice []string
+	for _, call := range calls {
+		if strings.HasPrefix(call, "nice ") {
+			nice = append(nice, call)
+		}
+	}
+	slices.SortStableFunc(nice, func(a, b string) int {
+		return cmp.Or(cmp.Compare(strings.Index(b, "group"), strings.Index(a, "group")), strings.Compare(a, b))
+	})
+	return nice
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Lists what the pills currently change on the system, with the values they replaced when known
+func (pm *PillManager) audit() string {
+	var b strings.Builder
+
+	if pm.CurrentPill == pm.defaultPill {
+		fmt.Fprintf(&b, "Current pill: %s, for %s\n", pm.defaultPill, time.Since(pm.pillSince).Round(time.Second))
+	} else {
+		fmt.Fprintf(&b, "Current pill: %s, for %s (trigger %d)\n", pm.CurrentPill, time.Since(pm.pillSince).Round(time.Second), pm.currentProc)
+		pm.auditActions(&b)
+	}
+
+	var reniced []int32
+	for pid, procInfo := range pm.knownProcs {
+		if procInfo.Reniced || procInfo.SlackSet || procInfo.NumaMoved || procInfo.HudToggled {
+			reniced = append(reniced, pid)
+		}
+	}
+	slices.Sort(reniced)
+	if len(reniced) > 0 {
+		fmt.Fprintf(&b, "Processes:\n")
+	}
+	for _, pid := range reniced {
+		procInfo := pm.knownProcs[pid]
+		var changes []string
+		if procInfo.Reniced {
+			changes = append(changes, fmt.Sprintf("nice %d, was %d", procInfo.EffectiveNice, procInfo.OriginalNice))
+		}
+		if procInfo.SlackSet {
+			changes = append(changes, fmt.Sprintf("timer slack changed, was %s", time.Duration(procInfo.OriginalSlack)))
+		}
+		if procInfo.NumaMoved {
+			changes = append(changes, "memory moved to the pill's NUMA node")
+		}
+		if procInfo.HudToggled {
+			changes = append(changes, "MangoHud overlay toggled")
+		}
+		fmt.Fprintf(&b, "  %s (PID %d): %s\n", procInfo.Name, pid, strings.Join(changes, "; "))
+	}
+
+	if pm.numaCgroup != "" {
+		fmt.Fprintf(&b, "Cgroup %s: memory bound to the pill's NUMA node, cpuset.mems was %s\n", strings.TrimPrefix(pm.numaCgroup, cgroupRoot), orUnset(pm.numaMems))
+	}
+
+	if len(pm.focusBoosted) > 0 {
+		fmt.Fprintf(&b, "Focus boost:\n")
+		for _, pid := range sortedKeys(pm.focusBoosted) {
+			fmt.Fprintf(&b, "  PID %d: nice was %d\n", pid, pm.focusBoosted[pid])
+		}
+	}
+
+	if len(pm.scoped) > 0 {
+		fmt.Fprintf(&b, "Process-scoped pills:\n")
+		for _, root := range sortedKeys(pm.scoped) {
+			scoped := pm.scoped[root]
+			changed := 0
+			for _, member := range scoped.members {
+				if member.reniced || member.slackSet {
+					changed++
+				}
+			}
+			fmt.Fprintf(&b, "  %s on process %d: %d processes changed\n", scoped.pill, root, changed)
+		}
+	}
+
+	if pm.CurrentPill == pm.defaultPill && len(reniced) == 0 && len(pm.focusBoosted) == 0 && len(pm.scoped) == 0 {
+		fmt.Fprintf(&b, "Nothing to revert\n")
+	}
+	return b.String()
+}
+
+// Lists the global actions of the current pill
+func (pm *PillManager) auditActions(b *strings.Builder) {
+	actions := pm.Pillz[pm.CurrentPill].actions()
+	if len(actions) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "Global actions:\n")
+	for _, pillAction := range actions {
+		action := pillAction.name
+		value, applied := pm.applied[action]
+		if !applied {
+			fmt.Fprintf(b, "  %s: %s, not applied\n", action, pillAction.value)
+			continue
+		}
+
+		switch {
+		case action == "tuned" && pm.tunedSaved != nil:
+			fmt.Fprintf(b, "  tuned: %s, was %s (%s)\n", value, pm.tunedSaved.Profile, pm.tunedSaved.Mode)
+		case action == "ppd" && pm.ppdHeld:
+			fmt.Fprintf(b, "  ppd: %s, held until released\n", value)
+		case action == "irq_affinity":
+			fmt.Fprintf(b, "  irq_affinity: %s\n", value)
+			for _, irq := range sortedKeys(pm.irqSaved) {
+				fmt.Fprintf(b, "    IRQ %d was %s\n", irq, pm.irqSaved[irq])
+			}
+		case action == "gamescope":
+			fmt.Fprintf(b, "  gamescope: %s on display %s\n", value, pm.gamescopeDpy)
+			for _, atom := range sortedKeys(pm.gamescopeSaved) {
+				fmt.Fprintf(b, "    %s was %s\n", atom, orUnset(pm.gamescopeSaved[atom]))
+			}
+		case action == "rlimits":
+			fmt.Fprintf(b, "  rlimits: %s on PID %d\n", value, pm.rlimitPid)
+		default:
+			fmt.Fprintf(b, "  %s: %s, the default pill sets it back\n", action, value)
+		}
+	}
+}
+
+// Reverts everything the pills changed, and keeps the trigger processes from eating their pills
+// again until they exit
+func (pm *PillManager) auditRestore() string {
+	pm.clearFocusBoost()
+
+	for root := range pm.scoped {
+		pm.pending.exhausted[root] = 0
+	}
+	pm.restoreScoped()
+
+	if pm.CurrentPill != pm.defaultPill {
+		if pm.currentProc != 0 {
+			Logger.Infof("Reverting pill %s on request, trigger process %d won't eat it again", pm.CurrentPill, pm.currentProc)
+			pm.exhaustTrigger(pm.currentProc, 0, reasonAudit)
+		} else {
+			Logger.Infof("Reverting pill %s on request", pm.CurrentPill)
+			pm.eatPill(nil, pm.defaultPill, reasonAudit)
+		}
+	}
+
+	return "Reverted, the running triggers won't eat their pills again until they exit\n" + pm.audit()
+}
+
+// Describes the journal left by a daemon that isn't running anymore
+func describeJournal(journal *Journal) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Pill %s was left applied (%s)\n", journal.Pill, strings.Join(journal.Actions, ", "))
+	if journal.Tuned != nil {
+		fmt.Fprintf(&b, "  tuned was %s (%s)\n", journal.Tuned.Profile, journal.Tuned.Mode)
+	}
+	for _, irq := range sortedKeys(journal.IrqAffinity) {
+		fmt.Fprintf(&b, "  IRQ %d was %s\n", irq, journal.IrqAffinity[irq])
+	}
+	for _, atom := range sortedKeys(journal.Gamescope) {
+		fmt.Fprintf(&b, "  %s was %s on display %s\n", atom, orUnset(journal.Gamescope[atom]), journal.GamescopeDisplay)
+	}
+	return b.String()
+}
+
+// Reports, and optionally reverts, the journal left by a daemon that isn't running
+func auditJournal(restore bool) error {
+	journal := loadJournal()
+	if journal == nil {
+		fmt.Println("The daemon left no journal, nothing to revert")
+		return nil
+	}
+	fmt.Print(describeJournal(journal))
+	if !restore {
+		return nil
+	}
+
+	config, _, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("Configuration error: %v", err)
+	}
+
+	pm := NewPillManager(*config)
+	pm.ticker.Stop()
+	pm.persistStats = false
+	pm.connectToDbus()
+	pm.recoverJournal(true)
+	pm.Close()
+
+	fmt.Println("Reverted")
+	return nil
+}
+
+// Value of a setting saved before a pill, which may not have been set
+func orUnset(value string) string {
+	if value == "" {
+		return "unset"
+	}
+	return value
+}
+
+func sortedKeys[K int | int32 | string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package main
+
+// Changes what the global actions of the pills manage: the scheduler, the TuneD and power
+// profiles, the IRQs, the limits and gamescope. The daemon drives the services and the kernel,
+// the tests record the calls.
+type actionBackend interface {
+	// Sets an action to the value of a pill. Returns false when there was nothing to set it on.
+	apply(p Proc, pillName string, name string, value string) (bool, error)
+	// Undoes what the previous pill changed for an action, before the next pill sets its own.
+	// Returns whether the action is left unapplied.
+	revert(event *TransitionEvent, name string, next Pill, toDefault bool) bool
+}
+
+// The services and the kernel
+type liveBackend struct {
+	pm *PillManager
+}
+
+func (b liveBackend) apply(p Proc, pillName string, name string, value string) (bool, error) {
+	pm := b.pm
+	switch name {
+	case "scx":
+		err := pm.setScx(value)
+		if err != nil {
+			Logger.Errorf("Failed to change the scheduler : %v", err)
+		} else {
+			Logger.Infof("Scheduler set to %s", value)
+		}
+		return true, err
+
+	case "tuned":
+		if pillName != pm.defaultPill {
+			pm.saveTunedMode()
+		}
+		err := pm.setTunedProfile(value)
+		if err != nil {
+			Logger.Errorf("Failed to set TuneD profile : %v", err)
+		} else {
+			Logger.Infof("TuneD profile set to %s", value)
+		}
+		return true, err
+
+	case "ppd":
+		err := pm.setPowerProfile(value, pillName)
+		if err != nil {
+			Logger.Errorf("Failed to set power profile : %v", err)
+		} else {
+			Logger.Infof("Power profile set to %s", value)
+		}
+		return true, err
+
+	case "irq_affinity":
+		err := pm.setIrqAffinity(value)
+		if err != nil {
+			Logger.Errorf("Failed to set IRQ affinity : %v", err)
+		} else {
+			Logger.Infof("IRQ affinity set to %s", value)
+		}
+		return true, err
+
+	case "rlimits":
+		if p == nil {
+			Logger.Warn("rlimits needs a trigger process, ignoring")
+			return false, nil
+		}
+		err := pm.setRlimits(p.PID(), value)
+		if err != nil {
+			Logger.Errorf("Failed to set resource limits : %v", err)
+		} else {
+			Logger.Infof("Resource limits of PID %d set to %s", p.PID(), value)
+		}
+		return true, err
+
+	case "gamescope":
+		if p == nil {
+			Logger.Warn("gamescope needs a trigger process, ignoring")
+			return false, nil
+		}
+		applied, err := pm.setGamescope(p, value)
+		if !applied && err == nil {
+			return false, nil
+		}
+		if err != nil {
+			Logger.Errorf("Failed to set gamescope options : %v", err)
+		} else {
+			Logger.Infof("gamescope options set to %s", value)
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+func (b liveBackend) revert(event *TransitionEvent, name string, next Pill, toDefault bool) bool {
+	pm := b.pm
+	switch name {
+	case "tuned":
+		// Switching profiles put TuneD in manual mode, default gives it back
+		if toDefault {
+			pm.restoreTunedMode(event, next)
+		}
+
+	case "ppd":
+		// A pill without a power profile releases the hold of the previous one
+		if next.Ppd == "" {
+			if err := pm.releasePowerProfile(); err != nil {
+				Logger.Errorf("Failed to release power profile : %v", err)
+			}
+			return true
+		}
+
+	// IRQs and limits changed by the previous pill go back to what they were before it
+	case "irq_affinity":
+		pm.restoreIrqAffinity(event)
+		return true
+	case "rlimits":
+		pm.restoreRlimits(event)
+		return true
+	case "gamescope":
+		pm.restoreGamescope(event)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Age after which the backend data saved by a previous instance isn't reused
+const backendCacheTTL = 5 * time.Minute
+
+// Backend data fetched over D-Bus, saved for the next instance. The owner of the backend's
+// bus name tells if the service restarted since.
+type savedBackend struct {
+	Owner      string    `json:"owner"`
+	Fetched    time.Time `json:"fetched"`
+	Profiles   []string  `json:"profiles,omitempty"`
+	Schedulers []string  `json:"schedulers,omitempty"`
+	Modes      []uint    `json:"modes,omitempty"`
+}
+
+func backendCachePath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backends.json"), nil
+}
+
+// Returns the unique name currently owning the bus name of a cached backend
+func (pm *PillManager) backendOwner(backend string) string {
+	conn, err := pm.backendConn(backend)
+	if err != nil {
+		return ""
+	}
+
+	var owner string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, cachedBackends[backend]).Store(&owner); err != nil {
+		return ""
+	}
+	return owner
+}
+
+// Saves freshly fetched backend data for the next instance
+func (pm *PillManager) saveBackend(backend string, saved savedBackend) {
+	if pm.dryRun {
+		return
+	}
+
+	saved.Owner = pm.backendOwner(backend)
+	if saved.Owner == "" {
+		return
+	}
+	if pm.savedBackends == nil {
+		pm.savedBackends = make(map[string]savedBackend)
+	}
+	pm.savedBackends[backend] = saved
+
+	path, err := backendCachePath()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(pm.savedBackends)
+	if err != nil {
+		return
+	}
+	if err := writeStateFile(path, data); err != nil {
+		Logger.Debugf("Couldn't write the backend cache %s : %v", path, err)
+	}
+}
+
+// Reuses the backend data saved by a previous instance, when it is recent and the services didn't restart since
+func (pm *PillManager) loadBackendCache() {
+	path, err := backendCachePath()
+	if err != nil {
+		return
+	}
+
+	data, err := readStateFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logger.Debugf("Couldn't read the backend cache %s : %v", path, err)
+		}
+		return
+	}
+
+	var saved map[string]savedBackend
+	if err := json.Unmarshal(data, &saved); err != nil {
+		Logger.Debugf("Ignoring the malformed backend cache %s : %v", path, err)
+		return
+	}
+
+	for backend, entry := range saved {
+		// A clock set back since makes the data look fetched in the future, it's fetched again
+		age := time.Since(entry.Fetched)
+		if _, cached := cachedBackends[backend]; !cached || age < 0 || age >= backendCacheTTL {
+			continue
+		}
+		if entry.Owner == "" || pm.backendOwner(backend) != entry.Owner {
+			continue
+		}
+
+		switch backend {
+		case "tuned":
+			pm.tunedProfiles, pm.tunedFetched = entry.Profiles, time.Now().Add(-age)
+		case "scx":
+			if !pm.backendWatched("scx") {
+				continue
+			}
+			pm.scxCaps = &scxCapabilities{schedulers: entry.Schedulers, modes: entry.Modes}
+		}
+		Logger.Debugf("Reusing the %s data fetched by the previous instance %s ago", backend, age.Round(time.Second))
+	}
+	pm.savedBackends = saved
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// What a backend offers on this machine, listed by the backends command for writing pills
+type backendInfo struct {
+	Name      string   `json:"name"`
+	Reachable bool     `json:"reachable"`
+	Error     string   `json:"error,omitempty"`
+	Current   string   `json:"current,omitempty"`
+	Available []string `json:"available,omitempty"`
+	Modes     []uint   `json:"modes,omitempty"`
+	Option    bool     `json:"pill_option"` // Whether pills set it, the sysfs knobs are for writing TuneD profiles
+}
+
+// Lists the schedulers, profiles and CPU frequency knobs of this machine
+func listBackends(w io.Writer, asJSON bool) error {
+	// The buses of the config when it loads, the system bus otherwise
+	buses := map[string]string{}
+	if config, _, err := loadConfig(); err == nil {
+		buses = map[string]string{"tuned": config.TunedBus, "scx": config.ScxBus, "ppd": config.PpdBus}
+	} else {
+		Logger.Infof("Configuration error, looking for every backend on the system bus: %v", err)
+	}
+	// Connecting once, so that a missing bus isn't retried for each backend. Close isn't used,
+	// it would remove the run state of the running daemon.
+	pm := &PillManager{backendBus: buses}
+	if pm.connectToDbus() == nil {
+		defer pm.dbusConn.Close()
+	}
+	for _, bus := range buses {
+		if bus == "session" && pm.connectToSessionBus() == nil {
+			defer pm.sessionConn.Close()
+			break
+		}
+	}
+
+	infos := []backendInfo{
+		pm.backendInfo("scx", scxInfo),
+		pm.backendInfo("tuned", tunedInfo),
+		pm.backendInfo("ppd", ppdInfo),
+		sysfsInfo("governor", "scaling_available_governors", "scaling_governor"),
+		sysfsInfo("epp", "energy_performance_available_preferences", "energy_performance_preference"),
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+	for _, info := range infos {
+		writeBackendInfo(w, info)
+	}
+	return nil
+}
+
+// Reads a backend through the bus it is configured on
+func (pm *PillManager) backendInfo(name string, read func(pm *PillManager, info *backendInfo) error) backendInfo {
+	info := backendInfo{Name: name, Option: true}
+	conn := pm.dbusConn
+	if pm.backendBus[name] == "session" {
+		conn = pm.sessionConn
+	}
+	if conn == nil {
+		info.Error = "no connection to its bus"
+		return info
+	}
+
+	if err := read(pm, &info); err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Reachable = true
+	return info
+}
+
+// Reads the schedulers of scx_loader, the current one with its mode
+func scxInfo(pm *PillManager, info *backendInfo) error {
+	conn, err := pm.backendConn("scx")
+	if err != nil {
+		return err
+	}
+	props, err := scxProperties(conn.Object("org.scx.Loader", "/org/scx/Loader"))
+	if err != nil {
+		return err
+	}
+
+	info.Available, _ = props["SupportedSchedulers"].Value().([]string)
+	info.Modes = scxModes(props["SupportedModes"])
+	if current, _ := props["CurrentScheduler"].Value().(string); current != "" && current != "unknown" {
+		info.Current = current
+		if mode, ok := props["SchedulerMode"].Value().(uint32); ok {
+			info.Current += fmt.Sprintf(" %d", mode)
+		}
+	}
+	return nil
+}
+
+// Reads the profiles of TuneD, and the active one
+func tunedInfo(pm *PillManager, info *backendInfo) error {
+	conn, err := pm.backendConn("tuned")
+	if err != nil {
+		return err
+	}
+	obj := conn.Object("com.redhat.tuned", "/Tuned")
+	if err := obj.Call("com.redhat.tuned.control.profiles", 0).Store(&info.Available); err != nil {
+		return err
+	}
+	return obj.Call("com.redhat.tuned.control.active_profile", 0).Store(&info.Current)
+}
+
+// Reads the profiles of power-profiles-daemon, and the active one
+func ppdInfo(pm *PillManager, info *backendInfo) error {
+	obj, iface, err := pm.ppdObject()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := obj.GetProperty(iface + ".Profiles")
+	if err != nil {
+		return err
+	}
+	entries, _ := profiles.Value().([]map[string]dbus.Variant)
+	for _, entry := range entries {
+		if profile, ok := entry["Profile"].Value().(string); ok {
+			info.Available = append(info.Available, profile)
+		}
+	}
+
+	active, err := obj.GetProperty(iface + ".ActiveProfile")
+	if err != nil {
+		return err
+	}
+	info.Current, _ = active.Value().(string)
+	return nil
+}
+
+// Reads a cpufreq knob of the first CPU, the others use the same driver
+func sysfsInfo(name string, availableFile string, currentFile string) backendInfo {
+	info := backendInfo{Name: name}
+	dir := "/sys/devices/system/cpu/cpu0/cpufreq/"
+	available, err := os.ReadFile(dir + availableFile)
+	if err != nil {
+		info.Error = fmt.Sprintf("%s%s is missing", dir, availableFile)
+		return info
+	}
+	info.Available = strings.Fields(string(available))
+	if current, err := os.ReadFile(dir + currentFile); err == nil {
+		info.Current = strings.TrimSpace(string(current))
+	}
+	info.Reachable = true
+	return info
+}
+
+// Prints a backend, its current value first as the pills write it
+func writeBackendInfo(w io.Writer, info backendInfo) {
+	name, missing := info.Name, "unreachable"
+	if !info.Option {
+		name, missing = name+" (sysfs, for TuneD profiles)", "unavailable"
+	}
+	if !info.Reachable {
+		fmt.Fprintf(w, "%s: %s, %s\n", name, missing, info.Error)
+		return
+	}
+
+	fmt.Fprintf(w, "%s: %s\n", name, orUnset(info.Current))
+	fmt.Fprintf(w, "  available: %s\n", strings.Join(info.Available, " "))
+	if len(info.Modes) > 0 {
+		modes := make([]string, 0, len(info.Modes))
+		for _, mode := range info.Modes {
+			modes = append(modes, fmt.Sprint(mode))
+		}
+		fmt.Fprintf(w, "  modes: %s\n", strings.Join(modes, " "))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// D-Bus names of the backends driven by the pill options
+var backendNames = map[string][]string{
+	"tuned": {"com.redhat.tuned"},
+	"scx":   {"org.scx.Loader"},
+	"ppd":   {"org.freedesktop.UPower.PowerProfiles", "net.hadess.PowerProfiles"},
+}
+
+// Whether the daemon runs as a systemd service, whose output goes to the journal
+func underSystemd() bool {
+	return os.Getenv("INVOCATION_ID") != "" || os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// Highlights a log message on terminals
+func bold(message string) string {
+	if underSystemd() {
+		return message
+	}
+	return "\033[1m" + message + "\033[0m"
+}
+
+// Whether each backend used by the pills has its service running
+func (pm *PillManager) reachableBackends() map[string]bool {
+	reachable := make(map[string]bool)
+	for backend, names := range backendNames {
+		used := false
+		for _, pill := range pm.Pillz {
+			if pill.has(backend) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			continue
+		}
+
+		// The system bus was connected to at startup, no need to retry it
+		reachable[backend] = false
+		if pm.backendBus[backend] != "session" && pm.dbusConn == nil {
+			continue
+		}
+		conn, err := pm.backendConn(backend)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			var hasOwner bool
+			err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&hasOwner)
+			if err == nil && hasOwner {
+				reachable[backend] = true
+				break
+			}
+		}
+	}
+	return reachable
+}
+
+// Logs the settings the daemon starts with, once the config is loaded and validated.
+// Interactively it is a short banner, under systemd a single entry with the settings as fields.
+func (pm *PillManager) logStartup(configPath string) {
+	states := pm.preflight()
+
+	capabilities := []string{}
+	if hasCapability(unix.CAP_SYS_NICE) {
+		capabilities = append(capabilities, "CAP_SYS_NICE")
+	}
+	if hasCapability(unix.CAP_SYS_RESOURCE) {
+		capabilities = append(capabilities, "CAP_SYS_RESOURCE")
+	}
+
+	detection := "/proc"
+	if pm.cgroupPrefix != "" {
+		detection += ", cgroup " + pm.cgroupPrefix
+	}
+
+	visibility := "all users"
+	if !procVisible() {
+		visibility = "own only, /proc is mounted with hidepid"
+	}
+
+	if underSystemd() {
+		Logger.Infow("Started",
+			"config", configPath,
+			"triggers", len(pm.Triggers),
+			"pills", len(pm.Pillz),
+			"scan_interval", pm.scanInterval.String(),
+			"detection", detection,
+			"user", pm.userName,
+			"processes", visibility,
+			"backends", states,
+			"capabilities", capabilities,
+			"lowest_nice", pm.lowestNice)
+		return
+	}
+
+	backendStates := make([]string, 0, len(states))
+	for _, backend := range sortedKeys(states) {
+		backendStates = append(backendStates, backend+" "+states[backend])
+	}
+	if len(backendStates) == 0 {
+		backendStates = append(backendStates, "none used")
+	}
+	if len(capabilities) == 0 {
+		capabilities = append(capabilities, "none")
+	}
+
+	var banner strings.Builder
+	fmt.Fprintf(&banner, "  config:       %s\n", configPath)
+	fmt.Fprintf(&banner, "  triggers:     %d, for %d pills\n", len(pm.Triggers), len(pm.Pillz))
+	fmt.Fprintf(&banner, "  scans:        every %s, from %s\n", pm.scanInterval, detection)
+	fmt.Fprintf(&banner, "  user:         %s\n", pm.userName)
+	fmt.Fprintf(&banner, "  processes:    %s\n", visibility)
+	fmt.Fprintf(&banner, "  backends:     %s\n", strings.Join(backendStates, ", "))
+	fmt.Fprintf(&banner, "  capabilities: %s, nice values down to %d\n", strings.Join(capabilities, ", "), pm.lowestNice)
+	fmt.Fprint(os.Stdout, banner.String())
+}
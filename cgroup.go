@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns the cgroup prefix of the processes the scan considers, or an empty string to scan them all.
+// Running as root the filter is disabled, the processes of any slice can matter.
+func cgroupPrefix(filter string) string {
+	if filter == "" {
+		return ""
+	}
+	if os.Geteuid() == 0 {
+		Logger.Info("Running as root, cgroup_filter is disabled")
+		return ""
+	}
+
+	if filter == "user" {
+		return fmt.Sprintf("/user.slice/user-%d.slice/", os.Getuid())
+	}
+	return filter
+}
+
+// Whether a process lives in the cgroup scanned for triggers. Reading its cgroup is cheaper than
+// its user, and skips the containers and system services early.
+func (pm *PillManager) inScannedCgroup(pid int32) bool {
+	if pm.cgroupPrefix == "" {
+		return true
+	}
+
+	cgroup, err := readCgroup(pid)
+	if err != nil {
+		// Left to the user check
+		return true
+	}
+	return strings.HasPrefix(cgroup, pm.cgroupPrefix)
+}
+
+// Mount point of the unified cgroup hierarchy
+var cgroupRoot = "/sys/fs/cgroup"
+
+// Binds the memory of the trigger's cgroup to a NUMA node with its cpuset.mems, so that the
+// allocations of the tree land there too, not only the pages moved. A process can only set the
+// memory policy of its own threads, the cgroup is the one policy another process can change.
+// The cgroup is left alone when processes outside of the tree share it, like a session scope.
+func (pm *PillManager) bindNumaCgroup(pid int32, node int) {
+	pm.numaBound = true
+
+	cgroup, err := unifiedCgroup(pid)
+	if err != nil || cgroup == "/" {
+		Logger.Debugf("Trigger %d has no cgroup of its own, numa_node only moves the memory of its tree", pid)
+		return
+	}
+	pm.bindCgroupNode(cgroup, pid, node)
+}
+
+// Writes the NUMA node to the cpuset.mems of the cgroup of a trigger, remembering the nodes it had
+func (pm *PillManager) bindCgroupNode(cgroup string, pid int32, node int) {
+	dir := filepath.Join(cgroupRoot, cgroup)
+
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		Logger.Debugf("Couldn't read the processes of cgroup %s : %v", cgroup, err)
+		return
+	}
+	for _, field := range strings.Fields(string(data)) {
+		member, err := strconv.ParseInt(field, 10, 32)
+		if procInfo, exists := pm.knownProcs[int32(member)]; err != nil || !exists || !procInfo.InTree {
+			Logger.Infof("Cgroup %s of trigger %d holds processes outside of its tree, numa_node only moves the memory of the tree", cgroup, pid)
+			return
+		}
+	}
+
+	mems := filepath.Join(dir, "cpuset.mems")
+	original, err := os.ReadFile(mems)
+	if errors.Is(err, os.ErrNotExist) {
+		Logger.Infof("The cpuset controller isn't enabled for cgroup %s, numa_node only moves the memory of the tree", cgroup)
+		return
+	} else if err != nil {
+		Logger.Warnf("Couldn't read the NUMA nodes of cgroup %s : %v", cgroup, err)
+		return
+	}
+
+	if err := os.WriteFile(mems, []byte(strconv.Itoa(node)), 0644); err != nil {
+		Logger.Warnf("Couldn't bind the memory of cgroup %s to NUMA node %d : %v", cgroup, node, err)
+		return
+	}
+	pm.numaCgroup, pm.numaMems = dir, strings.TrimSpace(string(original))
+	Logger.Infof("bound the memory of cgroup %s to NUMA node %d", cgroup, node)
+}
+
+// Gives the cgroup of the trigger back the NUMA nodes it had. An empty cpuset.mems, the usual
+// value, uses the nodes of the parent. A cgroup removed with its processes needs nothing.
+func (pm *PillManager) restoreNumaCgroup() error {
+	dir, mems := pm.numaCgroup, pm.numaMems
+	pm.numaCgroup, pm.numaMems, pm.numaBound = "", "", false
+	if dir == "" {
+		return nil
+	}
+
+	// An empty write doesn't reach the kernel, the newline does
+	return os.WriteFile(filepath.Join(dir, "cpuset.mems"), []byte(mems+"\n"), 0644)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parses and validates a config like loadConfig, without the files around it
+func parseTestConfig(t testing.TB, data string) (*Config, error) {
+	t.Helper()
+	var config Config
+	if err := yaml.Unmarshal([]byte(data), &config); err != nil {
+		return nil, explainYamlError([]byte(data), err)
+	}
+	if err := prepareConfig(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func TestDefaultPill(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		pill   string // Default pill of the config, or the error it fails with
+		fails  bool
+	}{
+		{
+			name: "default pill",
+			config: `
+scan_interval: 2
+triggers: {game: game}
+pills:
+  default: {tuned: balanced}
+  game: {nice: 5}
+`,
+			pill: "default",
+		},
+		{
+			name: "default_pill names it",
+			config: `
+scan_interval: 2
+default_pill: desktop
+triggers: {game: game}
+pills:
+  desktop: {tuned: balanced}
+  game: {nice: 5}
+`,
+			pill: "desktop",
+		},
+		{
+			name: "missing default pill",
+			config: `
+scan_interval: 2
+triggers: {game: game}
+pills:
+  game: {nice: 5}
+`,
+			pill:  "pills must have a 'default' pill",
+			fails: true,
+		},
+		{
+			name: "default_pill names a missing pill",
+			config: `
+scan_interval: 2
+default_pill: desktop
+triggers: {game: game}
+pills:
+  default: {tuned: balanced}
+  game: {nice: 5}
+`,
+			pill:  "default_pill 'desktop' doesn't exist",
+			fails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseTestConfig(t, tt.config)
+			switch {
+			case tt.fails && err == nil:
+				t.Fatalf("config accepted, want an error containing %q", tt.pill)
+			case tt.fails && !strings.Contains(err.Error(), tt.pill):
+				t.Fatalf("error %q, want one containing %q", err, tt.pill)
+			case !tt.fails && err != nil:
+				t.Fatalf("config refused: %v", err)
+			case !tt.fails && config.defaultPillName() != tt.pill:
+				t.Fatalf("default pill %q, want %q", config.defaultPillName(), tt.pill)
+			}
+		})
+	}
+}
+
+// The config comes from the user, a drop-in or an editor saving half a file: whatever it holds,
+// loading it returns an error or a config whose default pill exists
+func FuzzLoadConfig(f *testing.F) {
+	for _, seed := range []string{
+		"scan_interval: 2\ntriggers: {game: game}\npills:\n  default: {tuned: balanced}\n  game: {nice: 5}\n",
+		"scan_interval: 750ms\ndefault_pill: desktop\ntriggers:\n  zzgame: {pill: game, ignore_case: true, patterns: ['re:^wine.*\\.exe']}\npills:\n  desktop: {scx: rusty}\n  game: {extends: desktop, nice: -5, timer_slack: 50us}\n",
+		"scan_interval: 2\ntriggers:\n\tgame: game\npills: {default: {tuned: balanced}}\n",
+		"scan_interval: 2\ntriggers: {game: game, game: other}\npills: {default: {tuned: balanced}}\n",
+		"scan_interval: [2]\ntriggers: game\npills: {default: tuned}\n",
+		"scan_interval: abc\n",
+		"scan_interval: -1\n",
+		"triggers: {\"zz\\x1bgame\\x00\": game, \"glob:[\": game}\npills: {default: {tuned: \"\\x07\"}, game: {nice: \"+5\"}}\n",
+		"pills: {a: {extends: b}, b: {extends: a}}\n",
+		"x-anchors: &base {nice: 5}\npills: {default: {scx: rusty}, game: *base}\ntriggers: {\"\\xff\\xfe\": game}\nscan_interval: 1\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var config Config
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			if explainYamlError(data, err) == nil {
+				t.Fatalf("no error explained for %v", err)
+			}
+			return
+		}
+		warnUnknownKeys(data, "fuzz.yaml")
+		if err := prepareConfig(&config); err != nil {
+			return
+		}
+		if _, exists := config.Pills[config.defaultPillName()]; !exists {
+			t.Fatalf("config accepted without its default pill %s", config.defaultPillName())
+		}
+		compileMatchers(config.Triggers)
+		compileExclusions(config.Triggers)
+	})
+}
+
+func TestScanInterval(t *testing.T) {
+	tests := []struct {
+		value    string
+		interval time.Duration
+		err      string
+	}{
+		{value: "2", interval: 2 * time.Second},
+		{value: "0.5", interval: 500 * time.Millisecond},
+		{value: `"750ms"`, interval: 750 * time.Millisecond},
+		{value: "2.5s", interval: 2500 * time.Millisecond},
+		{value: "1m", interval: time.Minute},
+		{value: `"abc"`, err: "scan_interval must be a duration like 2s or 750ms, or a number of seconds, got 'abc'"},
+		{value: "-1", err: "scan_interval must be at least 100ms, got '-1'"},
+		{value: "-2s", err: "scan_interval must be at least 100ms, got '-2s'"},
+		{value: "0", err: "scan_interval must be at least 100ms, got '0'"},
+		{value: "50ms", err: "scan_interval must be at least 100ms, got '50ms'"},
+		{value: ".Inf", err: "scan_interval must be a duration like 2s or 750ms, or a number of seconds, got '.Inf'"},
+		{value: "[2]", err: "cannot unmarshal !!seq into string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			config, err := parseTestConfig(t, "scan_interval: "+tt.value+"\ntriggers: {zzgame: game}\npills: {default: {scx: rusty}, game: {nice: 5}}\n")
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("config refused: %v", err)
+			case tt.err == "" && config.scanInterval != tt.interval:
+				t.Errorf("interval %s, want %s", config.scanInterval, tt.interval)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
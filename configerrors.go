@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Shape expected for the sections of the config, to explain type errors
+var sectionShapes = map[string]string{
+	"triggers":    "a mapping of command line patterns to pill names, or to mappings of trigger options",
+	"pills":       "a mapping of pill names to mappings of options, like game: {tuned: gaming}",
+	"blacklist":   "a list of process names or cmdline: substrings",
+	"suppressors": "a list of command line patterns",
+	"focus_boost": "a mapping of focus_boost options",
+}
+
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+var yamlDuplicatePattern = regexp.MustCompile(`^mapping key "(.*)" already defined at line (\d+)$`)
+
+// Rewrites the errors of the YAML parser to point at the usual mistakes:
+// tabs, keys defined twice and sections of the wrong shape
+func explainYamlError(data []byte, err error) error {
+	// Tabs can't indent YAML, the parser only reports an unexpected character
+	for i, line := range strings.Split(string(data), "\n") {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if column := strings.IndexByte(indent, '\t'); column >= 0 {
+			return fmt.Errorf("line %d, column %d: tab character in the indentation, YAML only allows spaces", i+1, column+1)
+		}
+	}
+
+	typeErr, isTypeErr := err.(*yaml.TypeError)
+	if !isTypeErr {
+		return fmt.Errorf("%v. Check the indentation of this line and the previous one, and quote values containing ': ' or ' #'", err)
+	}
+
+	var root yaml.Node
+	yaml.Unmarshal(data, &root)
+
+	messages := make([]string, 0, len(typeErr.Errors))
+	for _, message := range typeErr.Errors {
+		match := yamlLinePattern.FindStringSubmatch(message)
+		if match == nil {
+			messages = append(messages, message)
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		duplicate := yamlDuplicatePattern.FindStringSubmatch(match[2])
+		section := sectionAt(&root, line, duplicate != nil)
+
+		if duplicate != nil {
+			messages = append(messages, fmt.Sprintf("line %d: '%s' is defined twice in %s, first at line %s", line, duplicate[1], section, duplicate[2]))
+		} else if shape, known := sectionShapes[section]; known {
+			messages = append(messages, fmt.Sprintf("line %d: wrong type in %s, expected %s (%s)", line, section, shape, match[2]))
+		} else {
+			messages = append(messages, fmt.Sprintf("line %d: wrong type for %s (%s)", line, section, match[2]))
+		}
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "; "))
+}
+
+// Returns the top level key whose section contains a line. For errors about a key,
+// a top level key on that line belongs to the top level rather than its own section.
+func sectionAt(root *yaml.Node, line int, aboutKey bool) string {
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return "the top level"
+	}
+
+	section := "the top level"
+
+	mapping := root.Content[0].Content
+	for i := 0; i+1 < len(mapping); i += 2 {
+		key, value := mapping[i], mapping[i+1]
+		if key.Line > line {
+			break
+		}
+		if key.Line == line && (aboutKey || value.Line != line) {
+			return "the top level"
+		}
+		section = key.Value
+	}
+	return section
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Broken configs like those of the support requests, each with the message it must get
+func TestExplainYamlError(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name:   "tab indenting a pill",
+			config: "scan_interval: 2\npills:\n  default: {scx: rusty}\n\tgame: {nice: 5}\n",
+			want:   "line 4, column 1: tab character in the indentation, YAML only allows spaces",
+		},
+		{
+			name:   "tab after spaces",
+			config: "scan_interval: 2\ntriggers:\n  \tzzgame: game\n",
+			want:   "line 3, column 3: tab character in the indentation",
+		},
+		{
+			name:   "pill defined twice",
+			config: "scan_interval: 2\npills:\n  default: {scx: rusty}\n  game: {nice: 5}\n  game: {nice: 10}\n",
+			want:   "line 5: 'game' is defined twice in pills, first at line 4",
+		},
+		{
+			name:   "top level key defined twice",
+			config: "scan_interval: 2\ntriggers: {zzgame: game}\nscan_interval: 3\n",
+			want:   "line 3: 'scan_interval' is defined twice in the top level, first at line 1",
+		},
+		{
+			name:   "option defined twice",
+			config: "pills:\n  game:\n    nice: 5\n    nice: 10\n",
+			want:   "line 4: 'nice' is defined twice in pills, first at line 3",
+		},
+		{
+			name:   "pills as a list",
+			config: "scan_interval: 2\npills:\n  - default\n  - game\n",
+			want:   "line 3: wrong type in pills, expected a mapping of pill names to mappings of options, like game: {tuned: gaming}",
+		},
+		{
+			name:   "pill as a string",
+			config: "pills:\n  default: {scx: rusty}\n  game: gaming\n",
+			want:   "line 3: wrong type in pills, expected a mapping of pill names to mappings of options",
+		},
+		{
+			name:   "triggers as a list",
+			config: "triggers:\n  - zzgame\n",
+			want:   "line 2: wrong type in triggers, expected a mapping of command line patterns to pill names",
+		},
+		{
+			name:   "blacklist as a string",
+			config: "blacklist: kwin_wayland\n",
+			want:   "line 1: wrong type in blacklist, expected a list of process names or cmdline: substrings",
+		},
+		{
+			name:   "unquoted colon",
+			config: "triggers:\n  zzgame: game: fullscreen\n",
+			want:   "Check the indentation of this line and the previous one, and quote values containing ': ' or ' #'",
+		},
+		{
+			name:   "misaligned key",
+			config: "pills:\n  default: {scx: rusty}\n   game: {nice: 5}\n",
+			want:   "Check the indentation of this line and the previous one",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestConfig(t, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// A tool known to manage some of the same knobs as process_pillz
+type knownConflict struct {
+	Tool      string   // Name of the tool
+	BusNames  []string // D-Bus names owned by the tool on the system bus
+	Processes []string // Process names of the tool
+	Paths     []string // Files existing while the tool is active
+	Options   []string // Pill options touching what the tool manages
+	Manages   string   // What the tool manages, for the warning
+}
+
+// Extend this table to detect more tools
+var knownConflicts = []knownConflict{
+	{
+		Tool:      "GameMode",
+		BusNames:  []string{"com.feralinteractive.GameMode"},
+		Processes: []string{"gamemoded"},
+		Options:   []string{"nice", "tuned", "ppd"},
+		Manages:   "the CPU governor and the priority of games",
+	},
+	{
+		Tool:    "TLP",
+		Paths:   []string{"/run/tlp"},
+		Options: []string{"tuned", "ppd"},
+		Manages: "CPU frequency and power settings",
+	},
+	{
+		Tool:      "auto-cpufreq",
+		Processes: []string{"auto-cpufreq"},
+		Options:   []string{"tuned", "ppd"},
+		Manages:   "the CPU governor and turbo",
+	},
+	{
+		Tool:      "power-profiles-daemon",
+		Processes: []string{"power-profiles-daemon"}, // Not the bus names, tuned-ppd owns them too
+		Options:   []string{"tuned"},
+		Manages:   "power profiles",
+	},
+	{
+		Tool:     "system76-power",
+		BusNames: []string{"com.system76.PowerDaemon"},
+		Options:  []string{"tuned", "ppd"},
+		Manages:  "power profiles",
+	},
+	{
+		Tool:      "ananicy",
+		Processes: []string{"ananicy-cpp", "ananicy"},
+		Options:   []string{"nice"},
+		Manages:   "process priorities",
+	},
+}
+
+// Warns about running tools that manage the same knobs as the configured pills
+func (pm *PillManager) detectConflicts() {
+	// Pills using each option
+	optionPills := make(map[string][]string)
+	for pillName, pill := range pm.Pillz {
+		for option := range pill.options {
+			optionPills[option] = append(optionPills[option], pillName)
+		}
+	}
+
+	var running []string
+	if processes, err := pm.source.Processes(); err == nil {
+		for _, p := range processes {
+			if name, err := p.Name(); err == nil {
+				running = append(running, name)
+			}
+		}
+	}
+
+	for _, conflict := range knownConflicts {
+		var overlapping []string
+		for _, option := range conflict.Options {
+			overlapping = append(overlapping, optionPills[option]...)
+		}
+		if len(overlapping) == 0 {
+			continue
+		}
+
+		if !pm.conflictPresent(conflict, running) {
+			continue
+		}
+
+		slices.Sort(overlapping)
+		overlapping = slices.Compact(overlapping)
+		Logger.Warnf("%s is running and manages %s, which pills %s also change. Expect them to fight, consider disabling one of them",
+			conflict.Tool, conflict.Manages, strings.Join(overlapping, ", "))
+	}
+}
+
+// Whether a conflicting tool is active
+func (pm *PillManager) conflictPresent(conflict knownConflict, running []string) bool {
+	for _, name := range conflict.Processes {
+		if slices.Contains(running, name) {
+			return true
+		}
+	}
+
+	for _, path := range conflict.Paths {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	if len(conflict.BusNames) > 0 && pm.connectToDbus() == nil {
+		for _, busName := range conflict.BusNames {
+			var hasOwner bool
+			err := pm.dbusConn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, busName).Store(&hasOwner)
+			if err == nil && hasOwner {
+				return true
+			}
+		}
+	}
+
+	return false
+}
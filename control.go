@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Time a client of the control socket gets to send its command and read the reply
+const controlTimeout = 5 * time.Second
+
+// Replies of the control socket starting with it are errors, the client exits with a failure
+const controlError = "error: "
+
+// A command received on the control socket, answered by the main loop
+type controlRequest struct {
+	args  []string
+	reply chan string
+}
+
+func controlSocketPath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "control.sock"), nil
+}
+
+// Listens on the control socket, and sends the commands to the main loop.
+// The socket is only reachable by the current user, and removed when the listener is closed.
+func listenControl(requests chan<- controlRequest) (net.Listener, error) {
+	path, err := controlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensurePrivateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	// A socket left by an instance that didn't exit cleanly
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveControl(conn, requests)
+		}
+	}()
+
+	return listener, nil
+}
+
+// Reads a command line from a client and writes back the reply of the main loop
+func serveControl(conn net.Conn, requests chan<- controlRequest) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+
+	// Waiting on the main loop, like while a pill waits on D-Bus, outlasts the read deadline
+	write := func(reply string) {
+		conn.SetDeadline(time.Now().Add(controlTimeout))
+		io.WriteString(conn, reply)
+	}
+
+	request := controlRequest{args: args, reply: make(chan string, 1)}
+	select {
+	case requests <- request:
+	case <-time.After(controlTimeout):
+		write(controlError + "The daemon is busy, try again\n")
+		return
+	}
+
+	select {
+	case reply := <-request.reply:
+		write(reply)
+	case <-time.After(controlTimeout):
+		write(controlError + "The daemon didn't answer in time\n")
+	}
+}
+
+// Answers a command of the control socket
+func (pm *PillManager) handleControl(args []string) string {
+	switch args[0] {
+	case "explain":
+		if len(args) != 2 {
+			return controlError + "Usage: explain <pid>\n"
+		}
+		var pid int32
+		if _, err := fmt.Sscan(args[1], &pid); err != nil || pid <= 0 {
+			return fmt.Sprintf("%sInvalid PID '%s'\n", controlError, args[1])
+		}
+		return pm.explain(pid)
+
+	case "tree":
+		asJSON := slices.Contains(args[1:], "--json")
+		return pm.explainTree(asJSON)
+
+	case "logs":
+		return controlLogs(args[1:])
+
+	case "status":
+		return pm.status(slices.Contains(args[1:], "--json"))
+
+	case "audit":
+		if len(args) == 2 && args[1] == "--restore" {
+			return pm.auditRestore()
+		}
+		return pm.audit()
+
+	case "switch":
+		if len(args) != 2 {
+			return controlError + "Usage: switch <pill>\n"
+		}
+		return pm.manualSwitch(args[1])
+
+	// Sent by an instance taking over, the daemon stops like on SIGTERM
+	case "shutdown":
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			return fmt.Sprintf("%sCouldn't shut down: %v\n", controlError, err)
+		}
+		return "Shutting down\n"
+
+	default:
+		return fmt.Sprintf("%sUnknown command %s, valid commands are: explain, tree, logs, status, audit, switch, shutdown\n", controlError, args[0])
+	}
+}
+
+// Answers the logs command with the last lines of the log ring
+func controlLogs(args []string) string {
+	lines := 0
+	if len(args) == 2 && args[0] == "--last" {
+		if _, err := fmt.Sscan(args[1], &lines); err != nil || lines <= 0 {
+			return fmt.Sprintf("%sInvalid number of lines '%s'\n", controlError, args[1])
+		}
+	} else if len(args) != 0 {
+		return controlError + "Usage: logs [--last <lines>]\n"
+	}
+
+	if logBuffer == nil {
+		return "The daemon keeps no logs in memory\n"
+	}
+	kept := logBuffer.last(lines)
+	if len(kept) == 0 {
+		return "The daemon keeps no logs in memory, log_buffer is 0\n"
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+// Sends a command to the running daemon and copies its reply to out, or returns it as an error
+// when the daemon refused the command
+func sendControl(args []string, out io.Writer) error {
+	path, err := controlSocketPath()
+	if err != nil {
+		return err
+	}
+	return sendControlTo(path, args, out)
+}
+
+// Sends a command to the daemon listening on a given socket, like sendControl
+func sendControlTo(path string, args []string, out io.Writer) error {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return fmt.Errorf("Couldn't reach the daemon at %s, is it running? %v", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * controlTimeout))
+
+	if _, err := fmt.Fprintln(conn, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return fmt.Errorf("The daemon closed the connection without answering")
+	}
+	if refusal, refused := strings.CutPrefix(string(reply), controlError); refused {
+		return errors.New(strings.TrimSuffix(refusal, "\n"))
+	}
+	_, err = out.Write(reply)
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import "time"
+
+// What a scan does with the current pill, once the matches are known
+type scanOutcome int
+
+const (
+	outcomeStay          scanOutcome = iota // Stay on the default pill, no trigger is running
+	outcomeKeepHeld                         // Keep the on_exit or manual pill, kept without a trigger
+	outcomeEatExitPill                      // The trigger exited, its on_exit pill is eaten
+	outcomeRevert                           // No trigger of the current pill is running, back to the default pill
+	outcomeSwitch                           // Another pill won the scan
+	outcomeChangeTrigger                    // Same pill, with another trigger process
+	outcomeKeep                             // The trigger process of the current pill is still running
+	outcomeHold                             // A transition waits for its pill to win for min_stable
+)
+
+// Inputs of the decision of a scan
+type scanState struct {
+	defaultPill   string
+	currentPill   string
+	currentProc   int32  // Trigger process of the current pill, 0 for none
+	currentFound  bool   // Whether a process with the PID of the trigger is still listed
+	currentZombie bool   // Whether that process exited, waiting for its parent to reap it
+	currentStart  uint64 // Start time of that process, in clock ticks since the boot
+	triggerStart  uint64 // Start time of the trigger when it became one, 0 if unknown
+	takenOver     bool   // Whether a group with a higher priority than the trigger's has a match
+	picked        string // Pill of the trigger picked among the matches, when the current one doesn't keep the pill
+	pickedPid     int32  // Process of that trigger
+	exitPill      string // on_exit pill of the current trigger
+	exitHeld      bool   // Whether the current pill is an on_exit or manual pill
+
+	transitions hysteresisRules
+	winner      string        // Pill that won the previous scans, for the transitions
+	wonFor      time.Duration // Time it has won for
+}
+
+// Whether the trigger process still runs. A zombie, or another process that got its PID, is gone.
+func (s scanState) triggerRunning() bool {
+	if s.currentProc == 0 || !s.currentFound || s.currentZombie {
+		return false
+	}
+	return s.triggerStart == 0 || s.currentStart == s.triggerStart
+}
+
+// Whether the current trigger keeps the pill whatever the other matches are
+func (s scanState) keepsTrigger() bool {
+	return s.triggerRunning() && !s.takenOver
+}
+
+// Decides what the scan does with the current pill, and the pill it eats if any. It only
+// looks at its inputs, the guards and the side effects are left to scanProcesses.
+// A trigger of another pill doesn't replace the current one as soon as the current
+// trigger exits: the revert to the default pill comes first.
+func decideScan(s scanState) (scanOutcome, string) {
+	keep, triggerPid, newPill := s.keepsTrigger(), s.currentProc, ""
+	if !keep && s.picked != "" {
+		if s.picked == s.currentPill {
+			keep, triggerPid = true, s.pickedPid
+		} else {
+			newPill = s.picked
+		}
+	}
+
+	var outcome scanOutcome
+	var pillName string
+	switch {
+	case !keep && newPill == "" && s.exitHeld:
+		outcome, pillName = outcomeKeepHeld, s.currentPill
+	case !keep && newPill == "" && s.exitPill != "":
+		outcome, pillName = outcomeEatExitPill, s.exitPill
+	case !keep && s.currentPill != s.defaultPill:
+		outcome, pillName = outcomeRevert, s.defaultPill
+	case newPill != "" && newPill != s.currentPill:
+		outcome, pillName = outcomeSwitch, newPill
+	case keep && (triggerPid != s.currentProc || !s.triggerRunning()):
+		// A new process with the PID of the trigger is another trigger too
+		outcome, pillName = outcomeChangeTrigger, s.currentPill
+	case keep:
+		outcome, pillName = outcomeKeep, s.currentPill
+	default:
+		outcome, pillName = outcomeStay, s.currentPill
+	}
+
+	// Transitions wait for the winner to be stable
+	if outcome == outcomeEatExitPill || outcome == outcomeRevert || outcome == outcomeSwitch {
+		if minStable, exists := s.transitions[pillPair{s.currentPill, pillName}]; exists {
+			if s.winner != pillName || s.wonFor < minStable {
+				return outcomeHold, pillName
+			}
+		}
+	}
+	return outcome, pillName
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecideScan(t *testing.T) {
+	// A game pill whose trigger 100 started at tick 500
+	running := scanState{
+		defaultPill:  "default",
+		currentPill:  "game",
+		currentProc:  100,
+		currentFound: true,
+		currentStart: 500,
+		triggerStart: 500,
+	}
+	gone := running
+	gone.currentFound, gone.currentStart = false, 0
+
+	// The default pill, with a match of the game pill
+	idle := scanState{defaultPill: "default", currentPill: "default", picked: "game", pickedPid: 100}
+
+	hysteresis := hysteresisRules{{"default", "game"}: 30 * time.Second, {"game", "default"}: 10 * time.Second}
+
+	tests := []struct {
+		name    string
+		state   func(s scanState) scanState
+		base    scanState
+		outcome scanOutcome
+		pill    string
+	}{
+		{
+			name:    "no trigger running",
+			base:    scanState{defaultPill: "default", currentPill: "default"},
+			outcome: outcomeStay,
+			pill:    "default",
+		},
+		{
+			name:    "trigger appears",
+			base:    idle,
+			outcome: outcomeSwitch,
+			pill:    "game",
+		},
+		{
+			name:    "trigger keeps running",
+			base:    running,
+			outcome: outcomeKeep,
+			pill:    "game",
+		},
+		{
+			name:    "trigger keeps running while another pill matches",
+			base:    running,
+			state:   func(s scanState) scanState { s.picked, s.pickedPid = "stream", 200; return s },
+			outcome: outcomeKeep,
+			pill:    "game",
+		},
+		{
+			name:    "trigger exits",
+			base:    gone,
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name:    "trigger exits while another pill matches",
+			base:    gone,
+			state:   func(s scanState) scanState { s.picked, s.pickedPid = "stream", 200; return s },
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name:    "trigger exits, another process of the pill takes over",
+			base:    gone,
+			state:   func(s scanState) scanState { s.picked, s.pickedPid = "game", 101; return s },
+			outcome: outcomeChangeTrigger,
+			pill:    "game",
+		},
+		{
+			name:    "trigger exits with an on_exit pill",
+			base:    gone,
+			state:   func(s scanState) scanState { s.exitPill = "cooldown"; return s },
+			outcome: outcomeEatExitPill,
+			pill:    "cooldown",
+		},
+		{
+			name:    "on_exit pill held without a trigger",
+			base:    scanState{defaultPill: "default", currentPill: "cooldown", exitHeld: true},
+			outcome: outcomeKeepHeld,
+			pill:    "cooldown",
+		},
+		{
+			name:    "held pill replaced by a trigger",
+			base:    scanState{defaultPill: "default", currentPill: "cooldown", exitHeld: true, picked: "game", pickedPid: 100},
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name:    "PID reused by another process",
+			base:    running,
+			state:   func(s scanState) scanState { s.currentStart = 900; return s },
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name: "PID reused by another process of the pill",
+			base: running,
+			state: func(s scanState) scanState {
+				s.currentStart, s.picked, s.pickedPid = 900, "game", 100
+				return s
+			},
+			outcome: outcomeChangeTrigger,
+			pill:    "game",
+		},
+		{
+			name:    "start time unknown keeps the trigger",
+			base:    running,
+			state:   func(s scanState) scanState { s.triggerStart, s.currentStart = 0, 900; return s },
+			outcome: outcomeKeep,
+			pill:    "game",
+		},
+		{
+			name:    "zombie doesn't keep the trigger alive",
+			base:    running,
+			state:   func(s scanState) scanState { s.currentZombie = true; return s },
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name: "group with a higher priority takes over",
+			base: running,
+			state: func(s scanState) scanState {
+				s.takenOver, s.picked, s.pickedPid = true, "stream", 200
+				return s
+			},
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name:    "hysteresis holds a new winner",
+			base:    idle,
+			state:   func(s scanState) scanState { s.transitions = hysteresis; return s },
+			outcome: outcomeHold,
+			pill:    "game",
+		},
+		{
+			name: "hysteresis holds until min_stable",
+			base: idle,
+			state: func(s scanState) scanState {
+				s.transitions, s.winner, s.wonFor = hysteresis, "game", 29*time.Second
+				return s
+			},
+			outcome: outcomeHold,
+			pill:    "game",
+		},
+		{
+			name: "hysteresis holds when the winner changes",
+			base: idle,
+			state: func(s scanState) scanState {
+				s.transitions, s.winner, s.wonFor = hysteresis, "stream", time.Minute
+				return s
+			},
+			outcome: outcomeHold,
+			pill:    "game",
+		},
+		{
+			name: "hysteresis lets a stable winner switch",
+			base: idle,
+			state: func(s scanState) scanState {
+				s.transitions, s.winner, s.wonFor = hysteresis, "game", 30*time.Second
+				return s
+			},
+			outcome: outcomeSwitch,
+			pill:    "game",
+		},
+		{
+			name: "hysteresis holds the revert",
+			base: gone,
+			state: func(s scanState) scanState {
+				s.transitions, s.winner, s.wonFor = hysteresis, "game", time.Minute
+				return s
+			},
+			outcome: outcomeHold,
+			pill:    "default",
+		},
+		{
+			name: "hysteresis only holds its transitions",
+			base: idle,
+			state: func(s scanState) scanState {
+				s.transitions, s.picked = hysteresis, "stream"
+				return s
+			},
+			outcome: outcomeSwitch,
+			pill:    "stream",
+		},
+		{
+			name:    "default pill missing at startup",
+			base:    scanState{defaultPill: "default"},
+			outcome: outcomeRevert,
+			pill:    "default",
+		},
+		{
+			name:    "renamed default pill",
+			base:    gone,
+			state:   func(s scanState) scanState { s.defaultPill = "desktop"; return s },
+			outcome: outcomeRevert,
+			pill:    "desktop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := tt.base
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+			outcome, pill := decideScan(state)
+			if outcome != tt.outcome || pill != tt.pill {
+				t.Errorf("decideScan() = %d, %q, want %d, %q", outcome, pill, tt.outcome, tt.pill)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Top level keys read from the drop-ins, the other settings belong to the main config
+var dropInKeys = []string{"triggers", "pills", "blacklist"}
+
+// Directory of the drop-ins of a config, conf.d next to it. The config directly in the user
+// config directory has them in process_pillz/conf.d, like the other user config.
+func dropInDir(configPath string) string {
+	dir := filepath.Dir(configPath)
+	if configDir, err := os.UserConfigDir(); err == nil && dir == filepath.Clean(configDir) {
+		return filepath.Join(dir, "process_pillz", "conf.d")
+	}
+	return filepath.Join(dir, "conf.d")
+}
+
+// Drop-ins of a config, in lexical order
+func dropInFiles(configPath string) []string {
+	files, _ := filepath.Glob(filepath.Join(dropInDir(configPath), "*.yaml"))
+	sort.Strings(files)
+	return files
+}
+
+// Merges the drop-ins into the main config, and returns the files merged. Their triggers and
+// pills are added, a later file overriding the keys of an earlier one, and their blacklists
+// are appended.
+func mergeDropIns(config *Config, configPath string) ([]string, error) {
+	files := dropInFiles(configPath)
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	// Files defining each trigger and pill, for the warnings
+	triggerSources := make(map[string]string)
+	for name := range config.Triggers {
+		triggerSources[name] = configPath
+	}
+	pillSources := make(map[string]string)
+	for name := range config.Pills {
+		pillSources[name] = configPath
+	}
+
+	for _, path := range files {
+		dropIn, data, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		warnIgnoredKeys(data, path)
+
+		if config.Triggers == nil && len(dropIn.Triggers) > 0 {
+			config.Triggers = make(map[string]Trigger)
+		}
+		for _, name := range sortedKeys(dropIn.Triggers) {
+			if previous, defined := triggerSources[name]; defined {
+				Logger.Warnf("Trigger '%s' is defined in %s and %s, using the one of %s", name, previous, path, path)
+			}
+			config.Triggers[name] = dropIn.Triggers[name]
+			triggerSources[name] = path
+		}
+
+		if config.Pills == nil && len(dropIn.Pills) > 0 {
+			config.Pills = make(map[string]Pill)
+		}
+		for _, name := range sortedKeys(dropIn.Pills) {
+			if previous, defined := pillSources[name]; defined {
+				Logger.Warnf("Pill '%s' is defined in %s and %s, using the one of %s", name, previous, path, path)
+			}
+			config.Pills[name] = dropIn.Pills[name]
+			pillSources[name] = path
+		}
+
+		config.Blacklist = append(config.Blacklist, dropIn.Blacklist...)
+	}
+	return files, nil
+}
+
+// Warns about the settings of a drop-in that are only read from the main config
+func warnIgnoredKeys(data []byte, path string) {
+	var top map[string]any
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return
+	}
+	for _, key := range sortedKeys(top) {
+		if !strings.HasPrefix(key, "x-") && !slices.Contains(dropInKeys, key) && slices.Contains(configKeys(), key) {
+			Logger.Warnf("%s is only read from the main config, ignoring it in %s", key, path)
+		}
+	}
+}
+
+// Merges the drop-ins into the main config decoded as plain values, for printing it
+func mergeResolvedDropIns(resolved map[string]any, configPath string) error {
+	for _, path := range dropInFiles(configPath) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading config file %s: %v", path, err)
+		}
+		var dropIn map[string]any
+		if err := yaml.Unmarshal(data, &dropIn); err != nil {
+			return fmt.Errorf("error parsing config file %s: %v", path, err)
+		}
+
+		for _, key := range []string{"triggers", "pills"} {
+			entries, _ := dropIn[key].(map[string]any)
+			if len(entries) == 0 {
+				continue
+			}
+			merged, _ := resolved[key].(map[string]any)
+			if merged == nil {
+				merged = make(map[string]any)
+				resolved[key] = merged
+			}
+			for name, value := range entries {
+				merged[name] = value
+			}
+		}
+		if blacklist, _ := dropIn["blacklist"].([]any); len(blacklist) > 0 {
+			merged, _ := resolved["blacklist"].([]any)
+			resolved["blacklist"] = append(merged, blacklist...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explains what the daemon thinks of a process, and why each trigger did or didn't activate its pill
+func (pm *PillManager) explain(pid int32) string {
+	var b strings.Builder
+
+	procInfo, cached := pm.knownProcs[pid]
+	if !cached {
+		p, err := pm.source.Process(pid)
+		if err != nil {
+			fmt.Fprintf(&b, "PID %d is not running\n", pid)
+			return b.String()
+		}
+
+		if !pm.inScannedCgroup(pid) {
+			cgroup, _ := readCgroup(pid)
+			fmt.Fprintf(&b, "PID %d is in cgroup %s, only the processes under %s are considered\n", pid, cgroup, pm.cgroupPrefix)
+		} else if pUser, err := p.Username(); err == nil && pUser != pm.userName {
+			fmt.Fprintf(&b, "PID %d belongs to user %s, only the processes of %s are considered\n", pid, pUser, pm.userName)
+		} else {
+			fmt.Fprintf(&b, "PID %d is not in the cache yet, it will be checked on the next scan\n", pid)
+		}
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "PID %d is in the cache\n", pid)
+	fmt.Fprintf(&b, "  name: %s\n", procInfo.Name)
+	fmt.Fprintf(&b, "  cmdline: %s\n", procInfo.Cmdline)
+	fmt.Fprintf(&b, "  user: %s\n", procInfo.Username)
+	if procInfo.Suppressor != "" {
+		fmt.Fprintf(&b, "  suppressor: matches '%s', pills are suppressed while it runs\n", procInfo.Suppressor)
+	}
+	if procInfo.blacklisted {
+		fmt.Fprintf(&b, "  blacklisted: never triggers pills nor gets reniced\n")
+	}
+
+	fmt.Fprintf(&b, "Triggers, in the order they are matched:\n")
+	for _, name := range pm.triggerOrder {
+		trigger := pm.Triggers[name]
+		fmt.Fprintf(&b, "  %s (pill %s): %s\n", name, trigger.Pill, pm.explainTrigger(pid, procInfo, name, trigger))
+	}
+
+	fmt.Fprintf(&b, "Current pill: %s (trigger %d, tree root %d)\n", pm.CurrentPill, pm.currentProc, pm.currentParent)
+	switch {
+	case pid == pm.currentProc:
+		fmt.Fprintf(&b, "Tree: the trigger process of the current pill\n")
+	case pid == pm.currentParent:
+		fmt.Fprintf(&b, "Tree: the root of the current trigger's tree\n")
+	case procInfo.InTree:
+		fmt.Fprintf(&b, "Tree: part of the current trigger's tree\n")
+	default:
+		fmt.Fprintf(&b, "Tree: not part of the current trigger's tree\n")
+	}
+	if procInfo.Reniced {
+		fmt.Fprintf(&b, "  reniced to %d, from %d\n", procInfo.EffectiveNice, procInfo.OriginalNice)
+	}
+	if scoped, member := pm.scopedOf(pid); scoped != nil {
+		fmt.Fprintf(&b, "Process-scoped pill: part of the tree of pill %s\n", scoped.pill)
+		if member.reniced {
+			fmt.Fprintf(&b, "  reniced to %d, from %d\n", member.effectiveNice, member.originalNice)
+		}
+	}
+
+	return b.String()
+}
+
+// Runs the checks of the scan for one trigger against a cached process, and tells the first that failed
+func (pm *PillManager) explainTrigger(pid int32, procInfo *ProcessInfo, name string, trigger Trigger) string {
+	if !pm.matchesTrigger(name, trigger, procInfo) {
+		if value, ok := trigger.target(procInfo); ok && pm.includes(name, value, procInfo.environ) {
+			return fmt.Sprintf("pattern match, but the exclusion '%s' matches too", trigger.Exclude[pm.exclusion(name, value, procInfo.environ)])
+		}
+		target := "command line"
+		switch trigger.Match {
+		case "exe":
+			if procInfo.Exe == "" {
+				return "pattern mismatch, the executable path of the process couldn't be read"
+			}
+			target = "executable path"
+		case "name":
+			target = "process name"
+		case "cgroup":
+			if procInfo.cgroup == "" {
+				return "pattern mismatch, the cgroup of the process couldn't be read"
+			}
+			target = "cgroup path"
+		}
+		patterns := trigger.patterns(name)
+		if len(patterns) > 1 {
+			return fmt.Sprintf("pattern mismatch, none of the %d patterns matches the %s", len(patterns), target)
+		}
+		if variable, isEnv := strings.CutPrefix(patterns[0], envPrefix); isEnv {
+			if procInfo.environ == nil {
+				return "pattern mismatch, the environment of the process couldn't be read"
+			}
+			return "pattern mismatch, the environment doesn't hold " + variable
+		}
+		if strings.HasPrefix(patterns[0], regexPrefix) {
+			return "pattern mismatch, the regular expression doesn't match the " + target
+		}
+		if strings.HasPrefix(patterns[0], globPrefix) {
+			return "pattern mismatch, the glob doesn't match the whole " + target
+		}
+		if trigger.Match == "name" {
+			return "pattern mismatch, the process name is " + procInfo.Name
+		}
+		return "pattern mismatch, the " + target + " doesn't contain it"
+	}
+	if _, exists := pm.Pillz[trigger.Pill]; !exists {
+		return "matches, but there is no pill named " + trigger.Pill
+	}
+	if _, exhausted := pm.pending.exhausted[pid]; exhausted {
+		return "matches, but the process exhausted its pill through max_duration or revert_if_idle"
+	}
+	if pm.isProcessScoped(trigger.Pill) {
+		if _, active := pm.scoped[pid]; active {
+			return "matches, the process-scoped pill is active on this process' tree"
+		}
+		if scoped, _ := pm.scopedOf(pid); scoped != nil {
+			return fmt.Sprintf("matches, but the process is already in the tree of the process-scoped pill %s", scoped.pill)
+		}
+		return "matches, the process-scoped pill should be eaten on the next scan"
+	}
+	if procInfo.background && procInfo.tty == 0 {
+		return "matches, but the process has no controlling terminal, which foreground_only requires"
+	}
+	if procInfo.background {
+		return "matches, but the process is in the background of its terminal, which foreground_group refuses"
+	}
+	if procInfo.young {
+		return fmt.Sprintf("matches, but the process hasn't run for min_runtime (%s) yet", trigger.MinRuntime)
+	}
+	if trigger.MinCPUPercent > 0 && procInfo.cpuIdle {
+		return fmt.Sprintf("matches, but the process used less than min_cpu_percent (%.1f%%) on the last scan", trigger.MinCPUPercent)
+	}
+	if trigger.ChildrenOnly {
+		return "matches a children_only launcher, only the processes it launches trigger the pill"
+	}
+	if pm.suppressedBy != "" {
+		return fmt.Sprintf("matches, but pills are suppressed while '%s' runs", pm.suppressedBy)
+	}
+	if pid == pm.currentProc {
+		return "matches, this process is the current trigger"
+	}
+	if trigger.Pill == pm.CurrentPill {
+		return fmt.Sprintf("matches, but process %d was picked as the trigger of this pill", pm.currentProc)
+	}
+	if pm.CurrentPill != pm.defaultPill {
+		return fmt.Sprintf("matches, waiting for the current pill %s to end", pm.CurrentPill)
+	}
+	return "matches, the pill should be eaten on the next scan"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Consecutive failures after which an action is disabled
+const actionFailureLimit = 3
+
+// Returned by actions whose D-Bus service is not running
+var errMissingBackend = errors.New("not running")
+
+// Consecutive failures of an action
+type actionFailure struct {
+	class string
+	count int
+}
+
+// Classifies the errors that won't go away by retrying, like a missing service or sysfs file.
+// Returns an empty string for any other error.
+func failureClass(err error) string {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		switch dbusErr.Name {
+		case "org.freedesktop.DBus.Error.ServiceUnknown", "org.freedesktop.DBus.Error.NameHasNoOwner":
+			return "missing backend"
+		case "org.freedesktop.DBus.Error.AccessDenied":
+			return backendDenied
+		}
+	}
+
+	if errors.Is(err, errMissingBackend) {
+		return "missing backend"
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing file"
+	}
+	return ""
+}
+
+// Records the outcome of an action in the transition, and disables actions failing the same way repeatedly
+func (pm *PillManager) recordAction(event *TransitionEvent, name string, value string, err error) {
+	event.addAction(name, value, err)
+	if err == nil {
+		pm.applied[name] = value
+		pm.applySeq++
+		pm.appliedAt[name] = pm.applySeq
+	} else {
+		delete(pm.applied, name)
+	}
+
+	class := failureClass(err)
+	if class == "" {
+		delete(pm.actionFailures, name)
+		return
+	}
+
+	failure := pm.actionFailures[name]
+	if failure.class != class {
+		failure = actionFailure{class: class}
+	}
+	failure.count++
+	pm.actionFailures[name] = failure
+
+	if failure.count >= actionFailureLimit {
+		Logger.Warnf("Action %s failed %d times in a row (%s), disabling it until the config is reloaded or SIGUSR2 is received", name, failure.count, class)
+		pm.disabledActions[name] = class
+		delete(pm.actionFailures, name)
+	}
+}
+
+// Whether an action was disabled after failing repeatedly
+func (pm *PillManager) actionDisabled(name string) bool {
+	_, disabled := pm.disabledActions[name]
+	return disabled
+}
+
+// Enables the disabled actions again. The values applied so far are forgotten too,
+// so that the next pill applies all its actions even if they seem in place.
+func (pm *PillManager) enableActions() {
+	if len(pm.disabledActions) > 0 {
+		Logger.Infof("Enabling actions %v again", pm.sortedDisabledActions())
+	}
+	clear(pm.disabledActions)
+	clear(pm.actionFailures)
+	clear(pm.applied)
+	pm.scanSettled = false
+}
+
+func (pm *PillManager) sortedDisabledActions() []string {
+	names := make([]string, 0, len(pm.disabledActions))
+	for name := range pm.disabledActions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Processes of a test, started and stopped by hand between the scans
+type fakeSource struct {
+	procs map[int32]*fakeProc
+	user  string
+	ticks uint64 // Start time of the next process
+}
+
+type fakeProc struct {
+	source  *fakeSource
+	pid     int32
+	ppid    int32
+	name    string
+	cmdline string
+	start   uint64
+	created int64
+	zombie  bool
+}
+
+func newFakeSource(user string) *fakeSource {
+	return &fakeSource{procs: make(map[int32]*fakeProc), user: user, ticks: 1000}
+}
+
+// Starts a process, taking over the PID of any process that had it
+func (s *fakeSource) spawn(pid, ppid int32, name, cmdline string) *fakeProc {
+	s.ticks += 100
+	p := &fakeProc{source: s, pid: pid, ppid: ppid, name: name, cmdline: cmdline, start: s.ticks, created: time.Now().UnixMilli()}
+	s.procs[pid] = p
+	return p
+}
+
+func (s *fakeSource) exit(pid int32) {
+	delete(s.procs, pid)
+}
+
+func (s *fakeSource) Processes() ([]Proc, error) {
+	procs := make([]Proc, 0, len(s.procs))
+	for _, pid := range s.sortedPids() {
+		procs = append(procs, s.procs[pid])
+	}
+	return procs, nil
+}
+
+func (s *fakeSource) Pids() ([]int32, error) {
+	return s.sortedPids(), nil
+}
+
+func (s *fakeSource) sortedPids() []int32 {
+	pids := make([]int32, 0, len(s.procs))
+	for pid := range s.procs {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+	return pids
+}
+
+func (s *fakeSource) Process(pid int32) (Proc, error) {
+	p, exists := s.procs[pid]
+	if !exists {
+		return nil, fmt.Errorf("process %d not found", pid)
+	}
+	return p, nil
+}
+
+func (p *fakeProc) PID() int32                      { return p.pid }
+func (p *fakeProc) Parent() (Proc, error)           { return p.source.Process(p.ppid) }
+func (p *fakeProc) Name() (string, error)           { return printable(p.name, nil) }
+func (p *fakeProc) Cmdline() (string, error)        { return printable(p.cmdline, nil) }
+func (p *fakeProc) Exe() (string, error)            { return "/usr/bin/" + p.name, nil }
+func (p *fakeProc) Environ() ([]string, error)      { return nil, nil }
+func (p *fakeProc) Cgroup() (string, error)         { return "", nil }
+func (p *fakeProc) Username() (string, error)       { return p.source.user, nil }
+func (p *fakeProc) CPUTime() (float64, error)       { return 0, nil }
+func (p *fakeProc) CreateTime() (int64, error)      { return p.created, nil }
+func (p *fakeProc) Terminal() (int32, bool, error)  { return 0, false, nil }
+func (p *fakeProc) Lifetime() (uint64, bool, error) { return p.start, p.zombie, nil }
+
+const fakeConfig = `
+scan_interval: 1
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {nice: 5}
+`
+
+// A manager scanning a fake source, which applies nothing, started on its default pill like the daemon
+func newFakeManager(t testing.TB, config string) (*PillManager, *fakeSource) {
+	t.Helper()
+	cfg, err := parseTestConfig(t, config)
+	if err != nil {
+		t.Fatalf("config refused: %v", err)
+	}
+
+	source := newFakeSource("")
+	pm := newPillManager(*cfg, source)
+	pm.ticker.Stop()
+	source.user = pm.userName
+	pm.dryRun = true
+	pm.persistStats = false
+	pm.cgroupPrefix = ""
+
+	source.spawn(1, 0, "systemd", "/sbin/init")
+	source.spawn(50, 1, "bash", "bash")
+	pm.eatPill(nil, pm.defaultPill, reasonStartup)
+	return pm, source
+}
+
+// Checks the pill and the trigger process after a scan
+func expectPill(t testing.TB, pm *PillManager, pill string, pid int32) {
+	t.Helper()
+	pm.scanProcesses()
+	if pm.CurrentPill != pill || pm.currentProc != pid {
+		t.Fatalf("pill %s with trigger %d, want %s with trigger %d", pm.CurrentPill, pm.currentProc, pill, pid)
+	}
+}
+
+// Logs of the test from the given level on, in place of the global logger
+func observeLogs(t *testing.T, level zapcore.Level) *observer.ObservedLogs {
+	core, logs := observer.New(level)
+	previous := Logger
+	Logger = zap.New(core).Sugar()
+	t.Cleanup(func() { Logger = previous })
+	return logs
+}
+
+func TestScanTriggerAppears(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	expectPill(t, pm, "default", 0)
+
+	source.spawn(100, 50, "zzgame", "zzgame --fullscreen")
+	expectPill(t, pm, "game", 100)
+	if pm.lastEvent.Reason != reasonTrigger || pm.lastEvent.TriggerPid != 100 {
+		t.Errorf("transition %v, want one triggered by PID 100", pm.lastEvent)
+	}
+
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+}
+
+func TestScanTriggerForks(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+
+	// The child matches too, the trigger stays the parent
+	source.spawn(101, 100, "zzgame", "zzgame --worker")
+	expectPill(t, pm, "game", 100)
+
+	// Once the parent dies, the child keeps the pill
+	events := pm.lastEvent
+	source.exit(100)
+	source.procs[101].ppid = 1
+	expectPill(t, pm, "game", 101)
+	if pm.lastEvent != events {
+		t.Errorf("transition %v, the pill shouldn't be eaten again for a new trigger", pm.lastEvent)
+	}
+
+	source.exit(101)
+	expectPill(t, pm, "default", 0)
+}
+
+func TestScanPidReused(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+
+	// The settled scans skip while the PIDs stay the same, the start time tells the new process apart
+	expectPill(t, pm, "game", 100)
+	source.exit(100)
+	source.spawn(100, 50, "vim", "vim notes.txt")
+	expectPill(t, pm, "default", 0)
+
+	// Another start of the game getting the same PID later is a new trigger
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	if pm.currentStart != source.procs[100].start {
+		t.Errorf("trigger start time %d, want %d", pm.currentStart, source.procs[100].start)
+	}
+}
+
+func TestScanZombieTrigger(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+
+	source.procs[100].zombie = true
+	expectPill(t, pm, "default", 0)
+}
+
+func TestDetectConflicts(t *testing.T) {
+	// Without pills changing what the tools manage, nothing is checked
+	pm, source := newFakeManager(t, `
+scan_interval: 1
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {scx: lavd}
+`)
+	logs := observeLogs(t, zapcore.WarnLevel)
+	source.spawn(200, 1, "gamemoded", "/usr/bin/gamemoded")
+	pm.detectConflicts()
+	if logs.Len() != 0 {
+		t.Fatalf("warnings without a conflicting pill: %v", logs.All())
+	}
+
+	// GameMode is found from its process, before its bus name
+	pm, source = newFakeManager(t, fakeConfig)
+	logs = observeLogs(t, zapcore.WarnLevel)
+	source.spawn(200, 1, "gamemoded", "/usr/bin/gamemoded")
+	source.spawn(201, 1, "ananicy-cpp", "/usr/bin/ananicy-cpp start")
+	pm.detectConflicts()
+	want := []string{
+		"GameMode is running and manages the CPU governor and the priority of games, which pills game also change. Expect them to fight, consider disabling one of them",
+		"ananicy is running and manages process priorities, which pills game also change. Expect them to fight, consider disabling one of them",
+	}
+	var warnings []string
+	for _, entry := range logs.All() {
+		warnings = append(warnings, entry.Message)
+	}
+	if !slices.Equal(warnings, want) {
+		t.Fatalf("warnings %q, want %q", warnings, want)
+	}
+}
+
+// The processes that join the tree of the trigger, checked in PID order like the scans do
+func treeOf(pm *PillManager, source *fakeSource) []int32 {
+	var members []int32
+	for _, pid := range source.sortedPids() {
+		if pm.treeCheck(source.procs[pid], treeSettings{}) != nil {
+			members = append(members, pid)
+		}
+	}
+	return members
+}
+
+func TestTreeRootInvalidParent(t *testing.T) {
+	for _, parent := range []string{"systemd", "steam"} {
+		t.Run(parent, func(t *testing.T) {
+			pm, source := newFakeManager(t, fakeConfig)
+			logs := observeLogs(t, zapcore.WarnLevel)
+			shared := int32(1)
+			if parent != "systemd" {
+				shared = source.spawn(10, 1, parent, "/usr/bin/"+parent).pid
+			}
+			source.spawn(100, shared, "zzgame", "zzgame")
+			source.spawn(101, 100, "zzgame-worker", "zzgame --worker")
+			source.spawn(102, 101, "crashpad", "crashpad_handler")
+			source.spawn(120, shared, "firefox", "firefox")
+			expectPill(t, pm, "game", 100)
+
+			// The parent is shared, the trigger is the root: its children join, not its siblings
+			if pm.currentParent != 100 {
+				t.Fatalf("tree root %d (%s), want the trigger", pm.currentParent, pm.rootReason)
+			}
+			if warnings := logs.FilterMessageSnippet("the tree is reduced to the trigger and its descendants"); warnings.Len() != 1 {
+				t.Errorf("the reduced tree isn't logged: %v", logs.All())
+			}
+			if members := treeOf(pm, source); !slices.Equal(members, []int32{100, 101, 102}) {
+				t.Errorf("tree %v, want the trigger and its descendants", members)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Calls made to the fakes, from the scans and the tree worker
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(call string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+func (l *callLog) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.calls)
+}
+
+func (l *callLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = nil
+}
+
+// The kernel side of the fake processes: their groups, nice values and timer slacks. It records
+// the priority changes, along with the calls of the fake backend sharing the log.
+type fakeSystem struct {
+	t     testing.TB
+	nice  map[int32]int
+	pgrp  map[int32]int32
+	calls *callLog
+	delay time.Duration // Taken by each priority change, like a loaded machine
+}
+
+// Gives the priority syscalls and the process files of the test to a fake system
+func newFakeSystem(t testing.TB, calls *callLog) *fakeSystem {
+	t.Helper()
+	sys := &fakeSystem{t: t, nice: make(map[int32]int), pgrp: make(map[int32]int32), calls: calls}
+
+	previousRoot, previousSet, previousGet := procRoot, setPriority, getPriority
+	procRoot = t.TempDir()
+	setPriority, getPriority = sys.setPriority, sys.getPriority
+	t.Cleanup(func() { procRoot, setPriority, getPriority = previousRoot, previousSet, previousGet })
+	return sys
+}
+
+// Adds the stat and timer slack files of a process of the fake source
+func (sys *fakeSystem) proc(p *fakeProc, pgrp int32, nice int, slack int64) {
+	sys.t.Helper()
+	sys.nice[p.pid], sys.pgrp[p.pid] = nice, pgrp
+
+	dir := filepath.Join(procRoot, fmt.Sprint(p.pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		sys.t.Fatal(err)
+	}
+	stat := fmt.Sprintf("%d (%s) S %d %d %d 0 -1 %s %d\n", p.pid, p.name, p.ppid, pgrp, pgrp, strings.Repeat("0 ", 13), p.start)
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		sys.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "timerslack_ns"), []byte(fmt.Sprint(slack)), 0644); err != nil {
+		sys.t.Fatal(err)
+	}
+}
+
+// Timer slack of a fake process, as last written
+func (sys *fakeSystem) slack(pid int32) string {
+	data, _ := os.ReadFile(filepath.Join(procRoot, fmt.Sprint(pid), "timerslack_ns"))
+	return string(data)
+}
+
+func (sys *fakeSystem) setPriority(which int, who int, prio int) error {
+	time.Sleep(sys.delay)
+	switch which {
+	case syscall.PRIO_PROCESS:
+		sys.calls.add(fmt.Sprintf("nice %d %d", who, prio))
+		if _, exists := sys.nice[int32(who)]; !exists {
+			return syscall.ESRCH
+		}
+		sys.nice[int32(who)] = prio
+	case syscall.PRIO_PGRP:
+		sys.calls.add(fmt.Sprintf("nice group %d %d", who, prio))
+		found := false
+		for pid, pgrp := range sys.pgrp {
+			if pgrp == int32(who) {
+				sys.nice[pid], found = prio, true
+			}
+		}
+		if !found {
+			return syscall.ESRCH
+		}
+	default:
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+// Like the raw syscall, 20 - nice
+func (sys *fakeSystem) getPriority(which int, who int) (int, error) {
+	nice, exists := sys.nice[int32(who)]
+	if which != syscall.PRIO_PROCESS || !exists {
+		return 0, syscall.ESRCH
+	}
+	return 20 - nice, nil
+}
+
+// Records the global actions instead of changing them
+type fakeBackend struct {
+	calls *callLog
+}
+
+func (b fakeBackend) apply(p Proc, pillName string, name string, value string) (bool, error) {
+	b.calls.add("apply " + name + " " + value)
+	return true, nil
+}
+
+func (b fakeBackend) revert(event *TransitionEvent, name string, next Pill, toDefault bool) bool {
+	b.calls.add("revert " + name)
+	return true
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The focus_boost block of the config
+type FocusBoost struct {
+	Nice     int    `yaml:"nice"`     // Nice delta applied to the focused window's tree, usually negative
+	Source   string `yaml:"source"`   // Where the focused window comes from: x11 or command
+	Command  string `yaml:"command"`  // Helper printing the PID of each newly focused window on a line
+	Debounce string `yaml:"debounce"` // Delay before a focus change is applied
+}
+
+// Delay before a focus change is applied, when not configured
+const defaultFocusDebounce = 500 * time.Millisecond
+
+// Validates the focus_boost block
+func (fb *FocusBoost) validate() error {
+	if fb.Nice == 0 || fb.Nice < -40 || fb.Nice > 40 {
+		return fmt.Errorf("focus_boost nice must be a non zero nice delta, got %d", fb.Nice)
+	}
+
+	switch fb.Source {
+	case "", "x11":
+	case "command":
+		if strings.TrimSpace(fb.Command) == "" {
+			return fmt.Errorf("focus_boost command cannot be empty with the command source")
+		}
+	default:
+		return fmt.Errorf("unknown focus_boost source '%s', valid sources are: x11, command", fb.Source)
+	}
+
+	if fb.Debounce != "" {
+		if d, err := time.ParseDuration(fb.Debounce); err != nil || d < 0 {
+			return fmt.Errorf("focus_boost debounce must be a duration, got '%s'", fb.Debounce)
+		}
+	}
+	return nil
+}
+
+// Starts the focus helper, and sends the PID of the focused window on the channel once it settled.
+// Returns false if focus tracking isn't available on this session.
+func watchFocus(fb FocusBoost, focusChan chan int32) bool {
+	var cmd *exec.Cmd
+	var parse func(line string) (int32, bool)
+
+	switch fb.Source {
+	case "", "x11":
+		if os.Getenv("DISPLAY") == "" {
+			Logger.Info("No X11 display, focus_boost is disabled. Wayland compositors need the command source")
+			return false
+		}
+		cmd = exec.Command("xprop", "-root", "-spy", "_NET_ACTIVE_WINDOW")
+		parse = x11FocusedPid
+
+	case "command":
+		args := strings.Fields(fb.Command)
+		cmd = exec.Command(args[0], args[1:]...)
+		parse = func(line string) (int32, bool) {
+			pid, err := strconv.ParseInt(strings.TrimSpace(line), 10, 32)
+			return int32(pid), err == nil && pid > 0
+		}
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		Logger.Errorf("Couldn't start the focus helper : %v", err)
+		return false
+	}
+	if err := cmd.Start(); err != nil {
+		Logger.Infof("Couldn't start the focus helper %s, focus_boost is disabled : %v", cmd.Path, err)
+		return false
+	}
+
+	debounce := defaultFocusDebounce
+	if fb.Debounce != "" {
+		debounce, _ = time.ParseDuration(fb.Debounce)
+	}
+
+	go func() {
+		var debounceTimer *time.Timer
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			pid, ok := parse(scanner.Text())
+			if !ok {
+				continue
+			}
+
+			if debounceTimer != nil {
+				debounceTimer.Stop()
+			}
+			debounceTimer = time.AfterFunc(debounce, func() {
+				// Only the last focused window matters
+				select {
+				case <-focusChan:
+				default:
+				}
+				focusChan <- pid
+			})
+		}
+
+		err := cmd.Wait()
+		Logger.Warnf("The focus helper exited, focus_boost is disabled until restart : %v", err)
+	}()
+
+	Logger.Infof("Tracking the focused window with %s", cmd.Path)
+	return true
+}
+
+var x11WindowPattern = regexp.MustCompile(`window id # (0x[0-9a-fA-F]+)`)
+var x11PidPattern = regexp.MustCompile(`= (\d+)`)
+
+// Finds the PID of the window announced by a line of xprop -spy
+func x11FocusedPid(line string) (int32, bool) {
+	match := x11WindowPattern.FindStringSubmatch(line)
+	if match == nil || match[1] == "0x0" {
+		return 0, false
+	}
+
+	out, err := exec.Command("xprop", "-id", match[1], "_NET_WM_PID").Output()
+	if err != nil {
+		return 0, false
+	}
+
+	pidMatch := x11PidPattern.FindSubmatch(out)
+	if pidMatch == nil {
+		return 0, false
+	}
+	pid, err := strconv.ParseInt(string(pidMatch[1]), 10, 32)
+	return int32(pid), err == nil
+}
+
+// Moves the focus boost to the tree of a newly focused process
+func (pm *PillManager) boostFocus(pid int32) {
+	pm.clearFocusBoost()
+	if pm.focusNice == 0 {
+		return
+	}
+
+	// Parents of the known processes, to find the descendants of the focused one
+	children := make(map[int32][]int32)
+	for known := range pm.knownProcs {
+		if stat, err := readProcStat(known); err == nil {
+			children[stat.Ppid] = append(children[stat.Ppid], known)
+		}
+	}
+
+	tree := []int32{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+
+	if pm.maxTreeSize > 0 && len(tree) > pm.maxTreeSize {
+		Logger.Debugf("Focused process %d has %d descendants, more than max_tree_size, not boosting it", pid, len(tree))
+		return
+	}
+
+	for _, member := range tree {
+		procInfo, exists := pm.knownProcs[member]
+		if !exists || procInfo.blacklisted {
+			continue
+		}
+
+		// Processes reniced by a pill keep the pill's value
+		if procInfo.Reniced {
+			continue
+		}
+
+		original, err := getNice(member)
+		if err != nil {
+			continue
+		}
+
+		boosted := min(max(original+pm.focusNice, -20), 19)
+		if err := setPriority(syscall.PRIO_PROCESS, int(member), boosted); err != nil {
+			Logger.Debugf("Couldn't boost %s (PID %d) : %v", procInfo.Name, member, err)
+			continue
+		}
+		pm.focusBoosted[member] = original
+	}
+
+	if len(pm.focusBoosted) > 0 {
+		Logger.Debugf("Boosted %d processes of the focused process %d", len(pm.focusBoosted), pid)
+	}
+}
+
+// Restores the processes boosted for having the focus
+func (pm *PillManager) clearFocusBoost() {
+	for pid, original := range pm.focusBoosted {
+		if err := setPriority(syscall.PRIO_PROCESS, int(pid), original); err != nil {
+			Logger.Debugf("Couldn't restore nice value of PID %d : %v", pid, err)
+		}
+		delete(pm.focusBoosted, pid)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Settings of the gamescope option, with the root window atoms gamescope reads them from
+var gamescopeAtoms = map[string]string{
+	"fps_limit":     "GAMESCOPE_FPS_LIMIT",
+	"fsr_sharpness": "GAMESCOPE_FSR_SHARPNESS",
+}
+
+// A setting of the gamescope option
+type gamescopeSetting struct {
+	Name  string
+	Atom  string
+	Value uint64
+}
+
+// Parses a gamescope value like "fps_limit=60 fsr_sharpness=2"
+func parseGamescope(value string) ([]gamescopeSetting, error) {
+	var settings []gamescopeSetting
+	for _, field := range strings.Fields(value) {
+		name, valueStr, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid gamescope entry '%s', expected name=value", field)
+		}
+
+		atom, known := gamescopeAtoms[name]
+		if !known {
+			names := make([]string, 0, len(gamescopeAtoms))
+			for n := range gamescopeAtoms {
+				names = append(names, n)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("unknown gamescope setting '%s', valid settings are: %s", name, strings.Join(names, ", "))
+		}
+
+		v, err := strconv.ParseUint(valueStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for gamescope setting %s", valueStr, name)
+		}
+		if name == "fsr_sharpness" && v > 20 {
+			return nil, fmt.Errorf("fsr_sharpness must be between 0 and 20, got %d", v)
+		}
+
+		settings = append(settings, gamescopeSetting{Name: name, Atom: atom, Value: v})
+	}
+
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("gamescope cannot be empty")
+	}
+	return settings, nil
+}
+
+// Returns the X display of the gamescope instance running the trigger process, if any
+func gamescopeDisplay(p Proc) (string, bool) {
+	underGamescope := false
+	current := p
+	for range maxAncestors {
+		parent, err := current.Parent()
+		if err != nil {
+			break
+		}
+		if name, err := parent.Name(); err == nil && strings.HasPrefix(name, "gamescope") {
+			underGamescope = true
+			break
+		}
+		current = parent
+	}
+	if !underGamescope {
+		return "", false
+	}
+
+	// Games get the display of gamescope's Xwayland
+	environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", p.PID()))
+	if err != nil {
+		return "", false
+	}
+	for _, variable := range bytes.Split(environ, []byte{0}) {
+		if display, found := strings.CutPrefix(string(variable), "DISPLAY="); found && display != "" {
+			return display, true
+		}
+	}
+	return "", false
+}
+
+var xpropCardinalPattern = regexp.MustCompile(`= (\d+)`)
+
+// Reads a cardinal atom of the root window, returning false if it is not set
+func readRootAtom(display string, atom string) (string, bool) {
+	out, err := exec.Command("xprop", "-display", display, "-root", atom).Output()
+	if err != nil {
+		return "", false
+	}
+	match := xpropCardinalPattern.FindSubmatch(out)
+	if match == nil {
+		return "", false
+	}
+	return string(match[1]), true
+}
+
+func writeRootAtom(display string, atom string, value string) error {
+	out, err := exec.Command("xprop", "-display", display, "-root", "-f", atom, "32c", "-set", atom, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func removeRootAtom(display string, atom string) error {
+	return exec.Command("xprop", "-display", display, "-root", "-remove", atom).Run()
+}
+
+// Sets the gamescope runtime options through the atoms of its root window, saving the previous values.
+// Triggers not running under gamescope are left alone.
+func (pm *PillManager) setGamescope(p Proc, value string) (bool, error) {
+	settings, err := parseGamescope(value)
+	if err != nil {
+		return false, err
+	}
+
+	display, found := gamescopeDisplay(p)
+	if !found {
+		Logger.Debugf("Trigger process %d doesn't run under gamescope, ignoring gamescope", p.PID())
+		return false, nil
+	}
+
+	var failed []string
+	for _, setting := range settings {
+		if _, saved := pm.gamescopeSaved[setting.Atom]; !saved {
+			previous, _ := readRootAtom(display, setting.Atom)
+			pm.gamescopeSaved[setting.Atom] = previous
+			pm.gamescopeDpy = display
+			pm.saveJournal()
+		}
+
+		if err := writeRootAtom(display, setting.Atom, strconv.FormatUint(setting.Value, 10)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", setting.Name, err))
+			continue
+		}
+		Logger.Debugf("gamescope %s set to %d on display %s", setting.Name, setting.Value, display)
+	}
+
+	pm.gamescopeDpy = display
+
+	if len(failed) > 0 {
+		return true, fmt.Errorf("Couldn't set gamescope settings %s", strings.Join(failed, ", "))
+	}
+	return true, nil
+}
+
+// Restores the gamescope options changed by the current pill. Options that were not set are removed.
+func (pm *PillManager) restoreGamescope(event *TransitionEvent) {
+	var restored, failed int
+	var lastErr error
+	for atom, previous := range pm.gamescopeSaved {
+		var err error
+		if previous == "" {
+			err = removeRootAtom(pm.gamescopeDpy, atom)
+		} else {
+			err = writeRootAtom(pm.gamescopeDpy, atom, previous)
+		}
+		if err != nil {
+			Logger.Debugf("Couldn't restore %s on display %s : %v", atom, pm.gamescopeDpy, err)
+			failed, lastErr = failed+1, err
+		} else {
+			restored++
+		}
+		delete(pm.gamescopeSaved, atom)
+	}
+	pm.gamescopeDpy = ""
+	event.addRestore("gamescope", restored, failed, lastErr)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of the target option of a pill. With gpu_process, the per-process options only apply
+// to the processes of the tree using the GPU, like the renderer of a game and not its launcher.
+var pillTargets = []string{"tree", "gpu_process"}
+
+// Interval between the checks of the processes of the tree that didn't use the GPU yet
+const gpuRecheckInterval = 10 * time.Second
+
+// Whether a process has a DRM client with GPU activity, from the fdinfo of its /dev/dri file
+// descriptors. The second value tells whether the driver reports usage stats at all.
+func gpuActivity(pid int32) (active bool, stats bool) {
+	dir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, false
+	}
+
+	for _, entry := range entries {
+		link, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil || !strings.HasPrefix(link, "/dev/dri/") {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		// Busy time of each engine, like "drm-engine-gfx: 1234 ns"
+		for _, line := range strings.Split(string(data), "\n") {
+			key, value, found := strings.Cut(line, ":")
+			if !found || !strings.HasPrefix(key, "drm-engine-") || strings.HasPrefix(key, "drm-engine-capacity-") {
+				continue
+			}
+			stats = true
+			ns, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " ns"), 10, 64)
+			if ns > 0 {
+				return true, true
+			}
+		}
+	}
+	return false, stats
+}
+
+// Whether the GPU drivers report their usage in fdinfo, found on any process of the user,
+// like the compositor. Checked once per pill.
+func (pm *PillManager) gpuStatsAvailable() bool {
+	if pm.gpuProbed {
+		return pm.gpuStats
+	}
+	pm.gpuProbed = true
+
+	for pid := range pm.knownProcs {
+		if _, stats := gpuActivity(pid); stats {
+			pm.gpuStats = true
+			return true
+		}
+	}
+	Logger.Debugf("No process reports its GPU usage in fdinfo, target gpu_process of pill %s applies to the whole tree", pm.CurrentPill)
+	return false
+}
+
+// Keeps the members of the tree using the GPU. The others wait, and are checked again every
+// gpuRecheckInterval, since the renderer can start using the GPU late.
+func (pm *PillManager) gpuMembers(members []*treeMember) []*treeMember {
+	if !pm.gpuStatsAvailable() {
+		return members
+	}
+
+	if time.Since(pm.gpuChecked) >= gpuRecheckInterval {
+		pm.gpuChecked = time.Now()
+		for _, pid := range sortedKeys(pm.gpuWaiting) {
+			if pm.currentScan[pid] {
+				members = append(members, pm.gpuWaiting[pid])
+			}
+		}
+		clear(pm.gpuWaiting)
+	}
+
+	var active []*treeMember
+	for _, member := range members {
+		pid := member.p.PID()
+		if used, _ := gpuActivity(pid); used {
+			Logger.Infof("%s (PID %d) uses the GPU, applying the per-process options of pill %s", member.procInfo.Name, pid, pm.CurrentPill)
+			active = append(active, member)
+		} else {
+			pm.gpuWaiting[pid] = member
+		}
+	}
+	return active
+}
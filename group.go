@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+
+// A group of triggers deciding its pill independently of the other groups, like interactive
+// and batch workloads. The group with the highest priority owns the global actions.
+type TriggerGroup struct {
+	Priority int `yaml:"priority"`
+}
+
+// Validates the groups of the triggers
+func validateGroups(config *Config) error {
+	for triggerName, trigger := range config.Triggers {
+		if trigger.Group == "" {
+			continue
+		}
+		if _, exists := config.Groups[trigger.Group]; !exists {
+			return fmt.Errorf("trigger '%s' is in the unknown group '%s'", triggerName, trigger.Group)
+		}
+	}
+	return nil
+}
+
+// Priority of a group, triggers outside of any group have 0
+func (pm *PillManager) groupPriority(group string) int {
+	return pm.groups[group].Priority
+}
+
+// Splits the candidates of a scan by group. The candidates of the groups with the highest
+// priority compete for the global pill. The best candidate of each other group gets its pill
+// on its own tree, with only the per-process options, like a process-scoped pill.
+func (pm *PillManager) splitGroups(candidates []triggerCandidate) ([]triggerCandidate, map[int32]string) {
+	top := 0
+	byGroup := make(map[string][]triggerCandidate)
+	for i, c := range candidates {
+		if i == 0 || pm.groupPriority(c.group) > top {
+			top = pm.groupPriority(c.group)
+		}
+		byGroup[c.group] = append(byGroup[c.group], c)
+	}
+
+	var winners []triggerCandidate
+	others := make(map[int32]string)
+	for _, group := range sortedKeys(byGroup) {
+		if pm.groupPriority(group) == top {
+			winners = append(winners, byGroup[group]...)
+		} else if best := bestCandidate(byGroup[group]); best != nil {
+			others[best.p.PID()] = best.pill
+		}
+	}
+	return winners, others
+}
+
+// Name of a group for the logs
+func groupName(group string) string {
+	if group == "" {
+		return "(none)"
+	}
+	return group
+}
+
+// Reverts the pill a trigger got as the best of its group, once it wins the global pill
+func (pm *PillManager) leaveGroupScope(pid int32) {
+	scoped, isScoped := pm.scoped[pid]
+	if !isScoped || pm.isProcessScoped(scoped.pill) {
+		return
+	}
+	pm.revertScoped(scoped)
+	delete(pm.scoped, pid)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parses a memory size like "2G" or "512M", in bytes. Units are powers of 1024.
+func parseSize(value string) (uint64, error) {
+	units := map[string]uint64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+	number, multiplier := value, uint64(1)
+	for unit, size := range units {
+		if trimmed, found := strings.CutSuffix(strings.ToUpper(value), unit); found {
+			number, multiplier = trimmed, size
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(number), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s', expected a number of bytes with an optional K, M, G or T unit", value)
+	}
+	return n * multiplier, nil
+}
+
+// Returns why a pill can't be eaten yet because of the system guards, or an empty string.
+// Simulations don't run on the snapshotted system, so they ignore the guards.
+func (pm *PillManager) guardTripped(pillName string) string {
+	if pillName == pm.defaultPill || pm.dryRun || pm.Pillz[pillName].IgnoreGuards {
+		return ""
+	}
+
+	if pm.minAvailable > 0 {
+		available, err := readMemAvailable()
+		if err != nil {
+			Logger.Debugf("Couldn't read the available memory : %v", err)
+		} else if available < pm.minAvailable {
+			return fmt.Sprintf("only %d MiB of memory available, under min_available_memory", available>>20)
+		}
+	}
+
+	if pm.maxLoadavg > 0 {
+		load, err := readLoadavg()
+		if err != nil {
+			Logger.Debugf("Couldn't read the load average : %v", err)
+		} else if load > pm.maxLoadavg {
+			return fmt.Sprintf("load average of %.2f, above max_loadavg", load)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Features the requires option of a pill can name, with the sysfs path present when they are
+var hardwareFeatures = map[string]string{
+	"amd_pstate":   "/sys/devices/system/cpu/amd_pstate",
+	"intel_pstate": "/sys/devices/system/cpu/intel_pstate",
+	"sched_ext":    "/sys/kernel/sched_ext",
+	"cpufreq":      "/sys/devices/system/cpu/cpufreq",
+}
+
+// Validates the hardware conditions of a pill
+func validateConditions(pillName string, pill map[string]string, isDefault bool) error {
+	if value, ok := pill["min_cpus"]; ok {
+		if cpus, err := strconv.Atoi(value); err != nil || cpus <= 0 {
+			return fmt.Errorf("min_cpus in pill '%s' must be a positive number, got '%s'", pillName, value)
+		}
+	}
+	for _, feature := range strings.Fields(pill["requires"]) {
+		if _, known := hardwareFeatures[feature]; !known && !strings.HasPrefix(feature, "/sys/") {
+			return fmt.Errorf("unknown requirement '%s' in pill '%s', use a path under /sys/ or one of: %s", feature, pillName, strings.Join(sortedKeys(hardwareFeatures), ", "))
+		}
+	}
+	if isDefault && (pill["min_cpus"] != "" || pill["requires"] != "") {
+		return fmt.Errorf("the default pill '%s' cannot have min_cpus or requires", pillName)
+	}
+	return nil
+}
+
+// Tells why the machine doesn't meet the hardware conditions of a pill, empty when it does
+func unmetCondition(pill map[string]string) string {
+	if value, ok := pill["min_cpus"]; ok {
+		minCPUs, _ := strconv.Atoi(value)
+		if online, err := onlineCPUs(); err == nil && len(online) < minCPUs {
+			return fmt.Sprintf("%d CPUs online, %d needed", len(online), minCPUs)
+		}
+	}
+	for _, feature := range strings.Fields(pill["requires"]) {
+		path, known := hardwareFeatures[feature]
+		if !known {
+			path = feature
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Sprintf("%s is missing", feature)
+		}
+	}
+	return ""
+}
+
+// Drops the pills whose hardware conditions the machine doesn't meet, along with their triggers
+// and transitions, so that one config can be shared by machines of different classes.
+// The conditions are removed from the pills kept, they are not actions.
+func dropUnmetPills(config *Config) error {
+	dropped := make(map[string]bool)
+	for pillName, pill := range config.Pills {
+		if err := validateConditions(pillName, pill.options, pillName == config.defaultPillName()); err != nil {
+			return err
+		}
+		unmet := unmetCondition(pill.options)
+		delete(pill.options, "min_cpus")
+		delete(pill.options, "requires")
+		if unmet == "" {
+			continue
+		}
+
+		Logger.Infof("Dropping pill %s on this machine, %s", pillName, unmet)
+		delete(config.Pills, pillName)
+		dropped[pillName] = true
+		for triggerName, trigger := range config.Triggers {
+			if trigger.Pill == pillName {
+				Logger.Infof("Dropping trigger '%s' of pill %s", triggerName, pillName)
+				delete(config.Triggers, triggerName)
+			} else if trigger.OnExit == pillName {
+				trigger.OnExit = ""
+				config.Triggers[triggerName] = trigger
+			}
+		}
+	}
+
+	kept := config.Transitions[:0]
+	for _, rule := range config.Transitions {
+		if !dropped[rule.From] && !dropped[rule.To] {
+			kept = append(kept, rule)
+		}
+	}
+	config.Transitions = kept
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Options of the /proc mount hiding the processes of the other users, empty when there are none
+func hidepidOption() string {
+	data, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[1] != "/proc" || fields[2] != "proc" {
+			continue
+		}
+		for _, option := range strings.Split(fields[3], ",") {
+			if value, found := strings.CutPrefix(option, "hidepid="); found && value != "0" && value != "off" {
+				return option
+			}
+		}
+	}
+	return ""
+}
+
+// Whether the scans can see the processes of the other users. init always runs as root,
+// so it is hidden from unprivileged users when /proc is mounted with hidepid.
+func procVisible() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	_, err := os.ReadFile("/proc/1/cmdline")
+	return err == nil
+}
+
+// Describes why the processes of the other users are hidden, with the ways around it
+func hiddenProcsMessage() string {
+	option := hidepidOption()
+	if option == "" {
+		option = "hidepid"
+	}
+	return "/proc is mounted with " + option + ", the scans can't see the processes of the other users, like the package managers of scan_pausers run by root. " +
+		"Add a gid= exemption to the /proc mount for a group the user is in, run the service with " +
+		"SupplementaryGroups= set to that group, or run process_pillz as root"
+}
+
+// Checks whether the processes of the other users are visible, logging when it changes,
+// like after /proc is remounted. Simulations don't read /proc.
+func (pm *PillManager) checkProcVisibility() {
+	if _, live := pm.source.(liveSource); !live {
+		return
+	}
+
+	hidden := !procVisible()
+	if hidden == pm.procHidden {
+		return
+	}
+	pm.procHidden = hidden
+	if hidden {
+		Logger.Error(bold(hiddenProcsMessage()))
+	} else {
+		Logger.Info("The processes of the other users are visible again")
+	}
+}
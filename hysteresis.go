@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A hysteresis rule of the transitions block: the switch between the two pills only happens
+// once the new pill has won every scan for min_stable
+type TransitionRule struct {
+	From      string `yaml:"from"`
+	To        string `yaml:"to"`
+	MinStable string `yaml:"min_stable"`
+}
+
+// Pills of a transition, from the current one to the winner of the scans
+type pillPair struct {
+	from string
+	to   string
+}
+
+// Validates the transitions block
+func validateTransitions(config *Config) error {
+	seen := make(map[pillPair]bool)
+	for _, rule := range config.Transitions {
+		for _, pillName := range []string{rule.From, rule.To} {
+			if _, exists := config.Pills[pillName]; !exists {
+				return fmt.Errorf("transition from '%s' to '%s' uses the unknown pill '%s'", rule.From, rule.To, pillName)
+			}
+		}
+		if rule.From == rule.To {
+			return fmt.Errorf("transition from '%s' to itself is not needed", rule.From)
+		}
+		if d, err := time.ParseDuration(rule.MinStable); err != nil || d <= 0 {
+			return fmt.Errorf("min_stable of the transition from '%s' to '%s' must be a positive duration, got '%s'", rule.From, rule.To, rule.MinStable)
+		}
+
+		pair := pillPair{rule.From, rule.To}
+		if seen[pair] {
+			return fmt.Errorf("transition from '%s' to '%s' is defined twice", rule.From, rule.To)
+		}
+		seen[pair] = true
+	}
+	return nil
+}
+
+// Time the winner of the scans must be stable for, by transition
+type hysteresisRules map[pillPair]time.Duration
+
+func parseTransitions(rules []TransitionRule) hysteresisRules {
+	hysteresis := make(hysteresisRules, len(rules))
+	for _, rule := range rules {
+		minStable, _ := time.ParseDuration(rule.MinStable)
+		hysteresis[pillPair{rule.From, rule.To}] = minStable
+	}
+	return hysteresis
+}
+
+// Records the pill winning the scan, for a transition waiting for its winner to be stable.
+// lastWinner is the pill that won the previous scan, the stability only counts over
+// consecutive scans.
+func (pm *PillManager) trackWinner(to string, lastWinner string) {
+	minStable, exists := pm.hysteresis[pillPair{pm.CurrentPill, to}]
+	if !exists {
+		return
+	}
+
+	ps := pm.pending
+	ps.winner = to
+	if lastWinner != to {
+		ps.winnerSince = time.Now()
+		Logger.Infof("Pill %s wins over %s, switching once it has won for %s", to, pm.CurrentPill, minStable)
+	}
+}
+
+// Time the pill winning the scans has won for, 0 if it changed since the last scan
+func (pm *PillManager) wonFor(lastWinner string) time.Duration {
+	if lastWinner == "" {
+		return 0
+	}
+	return time.Since(pm.pending.winnerSince)
+}
+
+// Why a transition is held, for the traces
+func (pm *PillManager) holdReason(to string) string {
+	minStable := pm.hysteresis[pillPair{pm.CurrentPill, to}]
+	return fmt.Sprintf("%s has won for %s of %s", to, time.Since(pm.pending.winnerSince).Round(time.Second), minStable)
+}
+
+// Describes the switch waiting for its winner to be stable, if any
+func (pm *PillManager) pendingSwitch() string {
+	ps := pm.pending
+	if ps.winner == "" {
+		return ""
+	}
+	minStable := pm.hysteresis[pillPair{pm.CurrentPill, ps.winner}]
+	return fmt.Sprintf("%s, won for %s of %s", ps.winner, time.Since(ps.winnerSince).Round(time.Second), minStable)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An IRQ selector of the irq_affinity option, with the CPUs to move the IRQs to
+type irqRule struct {
+	Selector string
+	CPUs     string
+}
+
+// Parses an irq_affinity value like "nvme*=8-15 45=0-3"
+func parseIrqAffinity(value string) ([]irqRule, error) {
+	var rules []irqRule
+	for _, field := range strings.Fields(value) {
+		selector, cpus, ok := strings.Cut(field, "=")
+		if !ok || selector == "" || cpus == "" {
+			return nil, fmt.Errorf("invalid irq_affinity entry '%s', expected selector=cpus", field)
+		}
+
+		if _, err := filepath.Match(selector, ""); err != nil {
+			return nil, fmt.Errorf("invalid IRQ selector '%s': %v", selector, err)
+		}
+
+		if _, err := parseList(cpus); err != nil {
+			return nil, fmt.Errorf("invalid CPU list for IRQ selector '%s': %v", selector, err)
+		}
+
+		rules = append(rules, irqRule{Selector: selector, CPUs: cpus})
+	}
+
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("irq_affinity cannot be empty")
+	}
+	return rules, nil
+}
+
+// Reads the IRQ numbers and the device names using them, from /proc/interrupts
+func readInterrupts() (map[int][]string, error) {
+	file, err := os.Open("/proc/interrupts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	// The header has one column per CPU
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty /proc/interrupts")
+	}
+	cpuCount := len(strings.Fields(scanner.Text()))
+
+	irqs := make(map[int][]string)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Only numbered IRQs can be moved, not NMI, LOC...
+		irq, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":"))
+		if err != nil {
+			continue
+		}
+
+		// Skipping the counters, then the chip and hardware IRQ come before the device names
+		var names []string
+		for _, field := range fields[min(len(fields), 1+cpuCount):] {
+			if name := strings.Trim(field, ","); name != "" {
+				names = append(names, name)
+			}
+		}
+		irqs[irq] = names
+	}
+
+	return irqs, scanner.Err()
+}
+
+// Resolves the IRQs matched by a selector, either an IRQ number or a glob on device names
+func (r irqRule) resolve(irqs map[int][]string) []int {
+	if irq, err := strconv.Atoi(r.Selector); err == nil {
+		if _, exists := irqs[irq]; exists {
+			return []int{irq}
+		}
+		return nil
+	}
+
+	var matched []int
+	for irq, names := range irqs {
+		for _, name := range names {
+			if ok, _ := filepath.Match(r.Selector, name); ok {
+				matched = append(matched, irq)
+				break
+			}
+		}
+	}
+	slices.Sort(matched)
+	return matched
+}
+
+func irqAffinityPath(irq int) string {
+	return fmt.Sprintf("/proc/irq/%d/smp_affinity_list", irq)
+}
+
+// Keeps the online CPUs of a CPU list. An unreadable online set keeps the list as is.
+func onlineOnly(cpus string) (string, error) {
+	online, err := onlineCPUs()
+	if err != nil {
+		Logger.Debugf("Couldn't read the online CPUs : %v", err)
+		return cpus, nil
+	}
+
+	list, err := parseList(cpus)
+	if err != nil {
+		return "", err
+	}
+
+	var kept []int
+	for _, cpu := range list {
+		if slices.Contains(online, cpu) {
+			kept = append(kept, cpu)
+		}
+	}
+	slices.Sort(kept)
+	return formatList(slices.Compact(kept)), nil
+}
+
+// Moves IRQs to the CPUs of their selector, saving their previous affinity.
+// IRQ numbers change across boots, so the selectors are resolved every time.
+func (pm *PillManager) setIrqAffinity(value string) error {
+	rules, err := parseIrqAffinity(value)
+	if err != nil {
+		return err
+	}
+
+	irqs, err := readInterrupts()
+	if err != nil {
+		return fmt.Errorf("Couldn't read the IRQs : %w", err)
+	}
+
+	var failed []string
+	for _, rule := range rules {
+		cpus, err := onlineOnly(rule.CPUs)
+		if err != nil || cpus == "" {
+			Logger.Warnf("None of the CPUs %s of IRQ selector '%s' is online", rule.CPUs, rule.Selector)
+			continue
+		}
+		if cpus != rule.CPUs {
+			Logger.Debugf("Only CPUs %s of %s are online for IRQ selector '%s'", cpus, rule.CPUs, rule.Selector)
+		}
+
+		matched := rule.resolve(irqs)
+		if len(matched) == 0 {
+			Logger.Warnf("IRQ selector '%s' didn't match any IRQ", rule.Selector)
+			continue
+		}
+
+		for _, irq := range matched {
+			if _, saved := pm.irqSaved[irq]; !saved {
+				previous, err := os.ReadFile(irqAffinityPath(irq))
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("%d (%v)", irq, err))
+					continue
+				}
+				pm.irqSaved[irq] = strings.TrimSpace(string(previous))
+				pm.saveJournal()
+			}
+
+			if err := os.WriteFile(irqAffinityPath(irq), []byte(cpus), 0); err != nil {
+				failed = append(failed, fmt.Sprintf("%d (%v)", irq, err))
+				continue
+			}
+			Logger.Debugf("IRQ %d moved to CPUs %s", irq, cpus)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Couldn't move IRQs %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Restores the affinity of the IRQs moved by the current pill, on the CPUs still online
+func (pm *PillManager) restoreIrqAffinity(event *TransitionEvent) {
+	var restored, failed int
+	var lastErr error
+	for irq, saved := range pm.irqSaved {
+		cpus, err := onlineOnly(saved)
+		if err != nil || cpus == "" {
+			Logger.Debugf("None of the CPUs %s of IRQ %d is online anymore, leaving it", saved, irq)
+			delete(pm.irqSaved, irq)
+			continue
+		}
+
+		if err := os.WriteFile(irqAffinityPath(irq), []byte(cpus), 0); err != nil {
+			Logger.Warnf("Couldn't restore the affinity of IRQ %d : %v", irq, err)
+			failed, lastErr = failed+1, err
+		} else {
+			restored++
+		}
+		delete(pm.irqSaved, irq)
+	}
+	event.addRestore("irq_affinity", restored, failed, lastErr)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Gives the tests another CPU topology, as sysfs would show it
+func fakeOnlineCPUs(t *testing.T, online string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "online")
+	if err := os.WriteFile(path, []byte(online+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := onlineCPUsPath
+	onlineCPUsPath = path
+	t.Cleanup(func() { onlineCPUsPath = previous })
+}
+
+func TestOnlineOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		online string
+		cpus   string
+		want   string
+	}{
+		{"all online", "0-15", "8-15", "8-15"},
+		{"SMT disabled", "0-7", "0-15", "0-7"},
+		{"core offline", "0-3,5-7", "2-6", "2-3,5-6"},
+		{"unordered list", "0-7", "6,1,3-4,1", "1,3-4,6"},
+		{"all offline", "0-3", "8-15", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeOnlineCPUs(t, tt.online)
+			cpus, err := onlineOnly(tt.cpus)
+			if err != nil || cpus != tt.want {
+				t.Errorf("onlineOnly(%q) with %s online = %q, %v, want %q", tt.cpus, tt.online, cpus, err, tt.want)
+			}
+		})
+	}
+
+	// The online set is read again every time, a CPU gone since the save isn't restored to
+	fakeOnlineCPUs(t, "0-15")
+	saved := "0-15"
+	if cpus, _ := onlineOnly(saved); cpus != "0-15" {
+		t.Fatalf("onlineOnly(%q) = %q before the hotplug", saved, cpus)
+	}
+	if err := os.WriteFile(onlineCPUsPath, []byte("0-11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cpus, _ := onlineOnly(saved); cpus != "0-11" {
+		t.Errorf("onlineOnly(%q) = %q after CPUs 12-15 went offline, want 0-11", saved, cpus)
+	}
+
+	if _, err := onlineOnly("0-x"); err == nil {
+		t.Error("invalid CPU list accepted")
+	}
+
+	// Without sysfs, the list is kept as is
+	onlineCPUsPath = filepath.Join(t.TempDir(), "missing")
+	if cpus, err := onlineOnly("0-3"); err != nil || cpus != "0-3" {
+		t.Errorf("onlineOnly without the online CPUs = %q, %v, want 0-3", cpus, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Actions changing the system outside of the trigger's tree, journaled before they are applied
+var journaledActions = []string{"scx", "tuned", "ppd", "irq_affinity", "gamescope"}
+
+// Version of the journal format, journals of other versions are discarded
+const journalVersion = 1
+
+// Global actions applied by the pills since the last default pill, with the values they replaced.
+// It is written before every change, so that a restart after a crash can undo them.
+type Journal struct {
+	Version          int               `json:"version"`
+	Pill             string            `json:"pill"`
+	Actions          []string          `json:"actions"`
+	IrqAffinity      map[int]string    `json:"irq_affinity,omitempty"`
+	GamescopeDisplay string            `json:"gamescope_display,omitempty"`
+	Gamescope        map[string]string `json:"gamescope,omitempty"`
+	Tuned            *tunedSnapshot    `json:"tuned,omitempty"`
+}
+
+func journalFilePath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "journal.json"), nil
+}
+
+// Records a global action a pill is about to apply
+func (pm *PillManager) journalAction(pillName string, action string) {
+	if pm.dryRun || pillName == pm.defaultPill {
+		return
+	}
+
+	if pm.journal == nil {
+		pm.journal = &Journal{Version: journalVersion}
+	}
+	pm.journal.Pill = pillName
+	if !slices.Contains(pm.journal.Actions, action) {
+		pm.journal.Actions = append(pm.journal.Actions, action)
+	}
+	pm.saveJournal()
+}
+
+// Writes the journal with the values saved by the actions so far
+func (pm *PillManager) saveJournal() {
+	if pm.journal == nil || pm.dryRun {
+		return
+	}
+
+	path, err := journalFilePath()
+	if err != nil {
+		Logger.Warnf("Couldn't find the journal file : %v", err)
+		return
+	}
+
+	pm.journal.IrqAffinity = pm.irqSaved
+	pm.journal.GamescopeDisplay = pm.gamescopeDpy
+	pm.journal.Gamescope = pm.gamescopeSaved
+	pm.journal.Tuned = pm.tunedSaved
+
+	data, err := json.Marshal(pm.journal)
+	if err != nil {
+		Logger.Warnf("Couldn't encode the journal : %v", err)
+		return
+	}
+
+	if err := writeStateFile(path, data); err != nil {
+		Logger.Warnf("Couldn't write the journal %s : %v", path, err)
+	}
+}
+
+// Removes the journal once everything is back to default
+func (pm *PillManager) clearJournal() {
+	pm.journal = nil
+
+	path, err := journalFilePath()
+	if err != nil {
+		return
+	}
+	if err := removeStateFile(path); err != nil {
+		Logger.Warnf("Couldn't remove the journal %s : %v", path, err)
+	}
+}
+
+// Reads the journal left by a previous instance that didn't exit cleanly
+func loadJournal() *Journal {
+	path, err := journalFilePath()
+	if err != nil {
+		return nil
+	}
+
+	data, err := readStateFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logger.Warnf("Couldn't read the journal %s : %v", path, err)
+		}
+		return nil
+	}
+
+	var journal Journal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		Logger.Warnf("Discarding the malformed journal %s : %v", path, err)
+		removeStateFile(path)
+		return nil
+	}
+	if journal.Version != journalVersion {
+		Logger.Warnf("Discarding the journal %s of unsupported version %d", path, journal.Version)
+		removeStateFile(path)
+		return nil
+	}
+	return &journal
+}
+
+// Undoes the actions left applied by a previous instance that crashed, before the first scan.
+// The saved values are restored and the default pill is eaten, which clears the journal.
+func (pm *PillManager) recoverJournal(restore bool) {
+	journal := loadJournal()
+	if journal == nil {
+		return
+	}
+
+	if !restore {
+		Logger.Infof("A previous instance left pill %s applied (%v), not restoring it as crash_recovery is disabled", journal.Pill, journal.Actions)
+		pm.clearJournal()
+		return
+	}
+
+	Logger.Warnf("A previous instance didn't exit cleanly with pill %s applied (%v), restoring", journal.Pill, journal.Actions)
+	for irq, affinity := range journal.IrqAffinity {
+		pm.irqSaved[irq] = affinity
+	}
+	for atom, previous := range journal.Gamescope {
+		pm.gamescopeSaved[atom] = previous
+	}
+	pm.gamescopeDpy = journal.GamescopeDisplay
+	pm.tunedSaved = journal.Tuned
+	pm.journal = journal
+
+	pm.eatPill(nil, pm.defaultPill, reasonRecovery)
+}
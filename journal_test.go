@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The daemon crashing between two actions of a pill leaves a journal naming both, with the
+// values the first one replaced, and the next start restores from it
+func TestJournalCrash(t *testing.T) {
+	pm, _ := newFakeManager(t, fakeConfig)
+	path, err := journalFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+
+	// The journal is written before each action, dry runs write none
+	pm.dryRun = false
+	pm.journalAction("game", "irq_affinity")
+	pm.irqSaved[45] = "0-15"
+	pm.saveJournal()
+	pm.journalAction("game", "scx")
+	pm.dryRun = true
+	// Crash while changing the scheduler
+
+	journal := loadJournal()
+	if journal == nil {
+		t.Fatal("no journal left by the crash")
+	}
+	if journal.Pill != "game" || !slices.Equal(journal.Actions, []string{"irq_affinity", "scx"}) || journal.IrqAffinity[45] != "0-15" {
+		t.Fatalf("journal %+v, want irq_affinity and scx of pill game", journal)
+	}
+	if described := describeJournal(journal); !strings.Contains(described, "Pill game was left applied (irq_affinity, scx)") || !strings.Contains(described, "IRQ 45 was 0-15") {
+		t.Errorf("journal described as %q", described)
+	}
+
+	// The next instance gets the saved values back before the default pill reverts them, which
+	// the dry run only reports
+	next, _ := newFakeManager(t, fakeConfig)
+	next.recoverJournal(true)
+	if next.irqSaved[45] != "0-15" || next.lastEvent.Reason != reasonRecovery || next.CurrentPill != "default" {
+		t.Errorf("recovered IRQs %v with %v, want IRQ 45 back to 0-15", next.irqSaved, next.lastEvent)
+	}
+
+	// Without crash_recovery, the journal is only dropped
+	next.dryRun = false
+	next.recoverJournal(false)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("journal kept without crash_recovery: %v", err)
+	}
+}
+
+func TestJournalDiscarded(t *testing.T) {
+	path, err := journalFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{`{"version": 1, "pill": "game", "actions": ["scx"`, `{"version": 99, "pill": "game"}`} {
+		if err := writeStateFile(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if journal := loadJournal(); journal != nil {
+			t.Errorf("journal %s loaded as %+v", data, journal)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("journal %s kept: %v", data, err)
+		}
+	}
+}
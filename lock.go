@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lock of the system instance, in /run/lock so that the user instances can see it
+const systemLockPath = "/run/lock/process_pillz.lock"
+
+// Exit status of a user instance refusing to start alongside the system instance. The user
+// service doesn't restart on it.
+const exitSystemInstance = 3
+
+// Lock of the running instance, held until it exits
+var instanceLock *os.File
+
+// Error of a user instance finding the system instance running
+var errSystemInstance = errors.New("the system instance of process_pillz is running, it manages the system instead of the user instances")
+
+// Whether this is the system instance, running as root
+func systemInstance() bool {
+	return os.Geteuid() == 0
+}
+
+// Path of the lock of a user instance, in its private runtime directory
+func userLockPath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	if err := ensurePrivateDir(dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "instance.lock"), nil
+}
+
+// Makes sure only one instance manages the system: one per user for the user instances, and
+// the system instance over all of them. An instance of the same kind already running is an
+// error, unless takeover is set, asking it to shut down through its control socket first.
+// The system instance always stops the user instances, a user instance never starts next to it.
+func acquireInstanceLock(takeover bool) error {
+	var path string
+	var perm os.FileMode = 0600
+	if systemInstance() {
+		// Readable by the users, for their instances to find it
+		path, perm = systemLockPath, 0644
+	} else {
+		if held, err := lockHeld(systemLockPath); err != nil {
+			return err
+		} else if held {
+			return errSystemInstance
+		}
+		var err error
+		if path, err = userLockPath(); err != nil {
+			return err
+		}
+	}
+
+	file, err := openLockFile(path, perm)
+	if err != nil {
+		return err
+	}
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		pid := lockOwner(file)
+		if !errors.Is(err, unix.EWOULDBLOCK) {
+			file.Close()
+			return fmt.Errorf("couldn't lock %s: %v", path, err)
+		}
+		if !takeover {
+			file.Close()
+			return fmt.Errorf("another instance of process_pillz (PID %s) is running, stop it or start with --takeover to replace it", pid)
+		}
+
+		Logger.Infof("Asking the running instance (PID %s) to shut down", pid)
+		socketPath, err := controlSocketPath()
+		if err == nil {
+			err = stopInstance(socketPath, path)
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+			file.Close()
+			return fmt.Errorf("couldn't lock %s after the running instance stopped: %v", path, err)
+		}
+	}
+
+	// The PID is for the errors of the next instances
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	instanceLock = file
+
+	if systemInstance() {
+		stopUserInstances()
+	}
+	return nil
+}
+
+// Opens a lock file, refusing one that isn't ours like with the state files
+func openLockFile(path string, perm os.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the lock file %s: %v", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := checkOwned(info); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("refusing to use the lock file %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// PID written by the instance holding a lock, for the messages
+func lockOwner(file *os.File) string {
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 32))
+	if pid := strings.TrimSpace(string(data)); err == nil && pid != "" {
+		return pid
+	}
+	return "unknown"
+}
+
+// Whether an instance holds a lock. The shared lock taken to check it is released right away.
+func lockHeld(path string) (bool, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("couldn't open the lock file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	err = unix.Flock(int(file.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("couldn't check the lock file %s: %v", path, err)
+	}
+	unix.Flock(int(file.Fd()), unix.LOCK_UN)
+	return false, nil
+}
+
+// Asks an instance to shut down through its control socket, and waits for it to release its
+// lock. It reverts its pill first, which can take up to its shutdown timeout.
+func stopInstance(socketPath string, lockPath string) error {
+	if err := sendControlTo(socketPath, []string{"shutdown"}, io.Discard); err != nil {
+		return fmt.Errorf("couldn't ask the running instance to shut down: %v", err)
+	}
+
+	deadline := time.Now().Add(shutdownTimeout + controlTimeout)
+	for time.Now().Before(deadline) {
+		if held, err := lockHeld(lockPath); err != nil || !held {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("the running instance didn't shut down within %s", shutdownTimeout+controlTimeout)
+}
+
+// Stops the instances of the users, found by their locks in the standard runtime directories
+func stopUserInstances() {
+	locks, _ := filepath.Glob("/run/user/*/process_pillz/instance.lock")
+	for _, path := range locks {
+		if held, err := lockHeld(path); err != nil || !held {
+			continue
+		}
+		dir := filepath.Dir(path)
+		Logger.Infof("A user instance runs in %s, asking it to shut down", dir)
+		if err := stopInstance(filepath.Join(dir, "control.sock"), path); err != nil {
+			Logger.Warnf("Couldn't stop the user instance running in %s, both instances manage the system : %v", dir, err)
+		}
+	}
+}
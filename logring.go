@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log lines kept in memory for the logs command, when not configured
+const defaultLogBuffer = 1000
+
+// Longest log line kept in memory, longer ones are truncated
+const maxLogLine = 4096
+
+// The last log entries at debug level, rendered without colors, whatever the level of the console.
+// Its size is fixed, so the memory it uses stays bounded.
+type logRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int    // Index of the next line to write
+	full    bool   // Whether the ring wrapped around
+	dropped uint64 // Lines overwritten by newer ones since the start
+}
+
+// Ring filled by the daemon's logger, nil for the subcommands
+var logBuffer *logRing
+
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]string, size)}
+}
+
+// Option sending the entries of a logger to the ring too
+func (r *logRing) tee() zap.Option {
+	encoder := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
+		MessageKey:     "message",
+		LevelKey:       "level",
+		TimeKey:        "time",
+		EncodeLevel:    zapcore.CapitalLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	})
+	ringCore := zapcore.NewCore(encoder, r, zap.DebugLevel)
+
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, ringCore)
+	})
+}
+
+// Receives the rendered entries, one per call
+func (r *logRing) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	if len(line) > maxLogLine {
+		line = line[:maxLogLine] + "..."
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.lines) == 0 {
+		return len(p), nil
+	}
+
+	if r.full {
+		r.dropped++
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	return len(p), nil
+}
+
+func (r *logRing) Sync() error { return nil }
+
+// Changes the number of lines kept, keeping the most recent ones. 0 disables the ring.
+func (r *logRing) resize(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if size == len(r.lines) {
+		return
+	}
+
+	kept := r.lastLocked(size)
+	r.lines = make([]string, size)
+	copy(r.lines, kept)
+	r.next, r.full = len(kept), len(kept) == size
+	if r.full {
+		r.next = 0
+	}
+}
+
+// Number of lines the ring dropped for newer ones
+func (r *logRing) droppedLines() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// Returns the last n lines, oldest first. n <= 0 returns all of them.
+func (r *logRing) last(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastLocked(n)
+}
+
+func (r *logRing) lastLocked(n int) []string {
+	var ordered []string
+	if r.full {
+		ordered = append(ordered, r.lines[r.next:]...)
+	}
+	ordered = append(ordered, r.lines[:r.next]...)
+
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -22,23 +30,96 @@ var (
 	BuildTime = "unknown"
 )
 
+// Config file given by --config, searched for when empty
+var configFlag string
+
 // Structure of the YAML configuration file.
 type Config struct {
-	ScanInterval int                          `yaml:"scan_interval"`
-	Triggers     map[string]string            `yaml:"triggers"`
-	Pills        map[string]map[string]string `yaml:"pills"`
-	Blacklist    []string                     `yaml:"blacklist"`
+	ScanInterval  string                  `yaml:"scan_interval"` // A duration like 750ms, or a number of seconds
+	Triggers      map[string]Trigger      `yaml:"triggers"`
+	Pills         map[string]Pill         `yaml:"pills"`
+	DefaultPill   string                  `yaml:"default_pill"` // Pill eaten when no trigger matches, the one named default unless set
+	Blacklist     []string                `yaml:"blacklist"`
+	Suppressors   []string                `yaml:"suppressors"`
+	PersistStats  bool                    `yaml:"persist_stats"`
+	PersistProcs  bool                    `yaml:"persist_proc_cache"`
+	FlapThreshold *int                    `yaml:"flap_threshold"`
+	RateLimit     *int                    `yaml:"max_transitions"`
+	RateWindow    int                     `yaml:"transition_window"`
+	MaxTreeSize   *int                    `yaml:"max_tree_size"`
+	FocusBoost    *FocusBoost             `yaml:"focus_boost"`
+	MinMemory     string                  `yaml:"min_available_memory"`
+	MaxLoadavg    float64                 `yaml:"max_loadavg"`
+	CrashRecovery *bool                   `yaml:"crash_recovery"`
+	TunedBus      string                  `yaml:"tuned_bus"`
+	ScxBus        string                  `yaml:"scx_bus"`
+	PpdBus        string                  `yaml:"ppd_bus"`
+	CgroupFilter  string                  `yaml:"cgroup_filter"`
+	LogBuffer     *int                    `yaml:"log_buffer"`
+	ScanPausers   []string                `yaml:"scan_pausers"`
+	PausedScan    int                     `yaml:"paused_scan_interval"`
+	FullScan      int                     `yaml:"full_scan_interval"` // Seconds between the full scans while no process starts or exits
+	Transitions   []TransitionRule        `yaml:"transitions"`
+	ReapplyWindow string                  `yaml:"reapply_window"` // Time after a revert within which a trigger coming back resumes its pill
+	Groups        map[string]TriggerGroup `yaml:"groups"`
+	WatchUser     string                  `yaml:"watch_user"` // User whose processes are watched, when running as a system service
+
+	scanInterval time.Duration // scan_interval, parsed by validateConfig
+}
+
+// Name of the pill eaten when no trigger matches, on startup and on shutdown
+func (c *Config) defaultPillName() string {
+	if c.DefaultPill != "" {
+		return c.DefaultPill
+	}
+	return "default"
+}
+
+// A trigger, either written as the name of its pill, as a list of patterns or as a mapping with options.
+type Trigger struct {
+	Pill          string   `yaml:"pill"`
+	MinCPUPercent float64  `yaml:"min_cpu_percent"`
+	MinRuntime    string   `yaml:"min_runtime"` // Age a process needs to trigger the pill, for launchers probing the game
+	ChildrenOnly  bool     `yaml:"children_only"`
+	Foreground    bool     `yaml:"foreground_only"`  // Only processes with a controlling terminal trigger the pill
+	ForegroundPgr bool     `yaml:"foreground_group"` // Only processes in the foreground of their terminal trigger the pill
+	OnExit        string   `yaml:"on_exit"`
+	Match         string   `yaml:"match"` // What the pattern is matched against: cmdline, exe, name or cgroup
+	IgnoreCase    bool     `yaml:"ignore_case"`
+	Group         string   `yaml:"group"`
+	Priority      int      `yaml:"priority"`
+	Patterns      []string `yaml:"patterns"` // Patterns matched instead of the key, which only names the trigger
+	Exclude       []string `yaml:"exclude"`  // Patterns of the processes ignored even though they match
+}
+
+func (t *Trigger) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&t.Pill)
+	}
+	// A list of patterns, the key being the pill
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&t.Patterns)
+	}
+
+	type plainTrigger Trigger
+	return value.Decode((*plainTrigger)(t))
 }
 
 // Create and configure the zap logger
 var Logger *zap.SugaredLogger
 
-func createLogger() *zap.SugaredLogger {
+func createLogger(output string) *zap.SugaredLogger {
 	// Custom encoder configuration for colored log output
 	encoderConfig := zapcore.EncoderConfig{
 		MessageKey: "message",
 		LevelKey:   "level",
 		EncodeLevel: func(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+			// The journal has its own priorities and colors
+			if underSystemd() {
+				enc.AppendString(strings.ToUpper(l.String())[:3])
+				return
+			}
+
 			// Use the built-in color encoder but trim to 3 letters
 			switch l {
 			case zapcore.DebugLevel:
@@ -68,7 +149,7 @@ func createLogger() *zap.SugaredLogger {
 		Sampling:         nil,
 		Encoding:         "console",
 		EncoderConfig:    encoderConfig,
-		OutputPaths:      []string{"stdout"},
+		OutputPaths:      []string{output},
 		ErrorOutputPaths: []string{"stderr"},
 	}
 
@@ -80,44 +161,179 @@ func createLogger() *zap.SugaredLogger {
 	return logger.Sugar()
 }
 
+// Shortest scan interval, scanning more often would keep a CPU busy for little gain
+const minScanInterval = 100 * time.Millisecond
+
+// Parses a scan interval, a Go duration like 750ms or, as in older configs, a number of seconds
+func parseInterval(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(seconds, 0) && !math.IsNaN(seconds) {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
 // Basic validation of the configuration
 func validateConfig(config *Config) error {
-	if config.ScanInterval <= 0 {
-		return fmt.Errorf("scan_interval must be greater than 0, got %d", config.ScanInterval)
+	interval, err := parseInterval(config.ScanInterval)
+	if err != nil {
+		return fmt.Errorf("scan_interval must be a duration like 2s or 750ms, or a number of seconds, got '%s'", config.ScanInterval)
 	}
+	if interval < minScanInterval {
+		return fmt.Errorf("scan_interval must be at least %s, got '%s'", minScanInterval, config.ScanInterval)
+	}
+	config.scanInterval = interval
 
 	if len(config.Triggers) == 0 {
 		return fmt.Errorf("triggers section cannot be empty")
 	}
 
+	if config.WatchUser != "" {
+		if _, err := user.Lookup(config.WatchUser); err != nil {
+			return fmt.Errorf("watch_user '%s': %v", config.WatchUser, err)
+		}
+	}
+
 	if len(config.Pills) == 0 {
 		return fmt.Errorf("pills section cannot be empty")
 	}
 
-	for triggerName, processName := range config.Triggers {
-		if strings.TrimSpace(triggerName) == "" {
-			return fmt.Errorf("trigger name cannot be empty")
-		}
-		if strings.TrimSpace(processName) == "" {
-			return fmt.Errorf("process name for trigger '%s' cannot be empty", triggerName)
+	// The default pill is what the system reverts to, it has to set things back
+	defaultPill := config.defaultPillName()
+	if _, exists := config.Pills[defaultPill]; !exists {
+		if config.DefaultPill != "" {
+			return fmt.Errorf("default_pill '%s' doesn't exist", config.DefaultPill)
 		}
+		return fmt.Errorf("pills must have a 'default' pill, eaten when no trigger matches, or default_pill must name one")
 	}
 
-	for pillName, pillConfig := range config.Pills {
+	// Parsed first, the checks of the triggers look at the options of their pills
+	for _, pillName := range sortedKeys(config.Pills) {
 		if strings.TrimSpace(pillName) == "" {
 			return fmt.Errorf("pill name cannot be empty")
 		}
-		if len(pillConfig) == 0 {
-			return fmt.Errorf("pill configuration for '%s' cannot be empty", pillName)
+		pill := config.Pills[pillName]
+		if err := pill.parse(pillName, pillName == defaultPill); err != nil {
+			return err
+		}
+		config.Pills[pillName] = pill
+	}
+
+	for triggerName, trigger := range config.Triggers {
+		if strings.TrimSpace(triggerName) == "" {
+			return fmt.Errorf("trigger name cannot be empty")
+		}
+		if strings.TrimSpace(trigger.Pill) == "" {
+			return fmt.Errorf("pill name for trigger '%s' cannot be empty", triggerName)
 		}
-		for key, value := range pillConfig {
-			if strings.TrimSpace(key) == "" {
-				return fmt.Errorf("configuration key in pill '%s' cannot be empty", pillName)
+		if !slices.Contains(matchTargets, trigger.Match) && trigger.Match != "" {
+			return fmt.Errorf("unknown match '%s' for trigger '%s', valid values are: %s", trigger.Match, triggerName, strings.Join(matchTargets, ", "))
+		}
+		for _, pattern := range trigger.patterns(triggerName) {
+			if strings.TrimSpace(pattern) == "" {
+				return fmt.Errorf("patterns of trigger '%s' cannot be empty", triggerName)
+			}
+			if _, err := compileMatcher(pattern, trigger); err != nil {
+				return fmt.Errorf("invalid pattern '%s' in trigger '%s': %v", pattern, triggerName, err)
+			}
+		}
+		for _, pattern := range trigger.Exclude {
+			if strings.TrimSpace(pattern) == "" {
+				return fmt.Errorf("exclude patterns of trigger '%s' cannot be empty", triggerName)
 			}
-			if strings.TrimSpace(value) == "" {
-				return fmt.Errorf("configuration value for key '%s' in pill '%s' cannot be empty", key, pillName)
+			if _, err := compileMatcher(pattern, trigger); err != nil {
+				return fmt.Errorf("invalid exclude pattern '%s' in trigger '%s': %v", pattern, triggerName, err)
 			}
 		}
+		if trigger.MinCPUPercent < 0 {
+			return fmt.Errorf("min_cpu_percent for trigger '%s' cannot be negative", triggerName)
+		}
+		if d, err := time.ParseDuration(trigger.MinRuntime); trigger.MinRuntime != "" && (err != nil || d < 0) {
+			return fmt.Errorf("min_runtime for trigger '%s' must be a duration, got '%s'", triggerName, trigger.MinRuntime)
+		}
+		if _, exists := config.Pills[trigger.OnExit]; trigger.OnExit != "" && !exists {
+			return fmt.Errorf("on_exit pill '%s' of trigger '%s' doesn't exist", trigger.OnExit, triggerName)
+		}
+		if config.Pills[trigger.OnExit].Scope == "process" {
+			return fmt.Errorf("on_exit pill '%s' of trigger '%s' cannot be process-scoped", trigger.OnExit, triggerName)
+		}
+		if config.Pills[trigger.Pill].Scope == "process" && (trigger.ChildrenOnly || trigger.OnExit != "" || trigger.MinCPUPercent > 0) {
+			return fmt.Errorf("trigger '%s' of a process-scoped pill cannot use children_only, on_exit or min_cpu_percent", triggerName)
+		}
+	}
+
+	if config.FlapThreshold != nil && *config.FlapThreshold < 0 {
+		return fmt.Errorf("flap_threshold cannot be negative, got %d", *config.FlapThreshold)
+	}
+
+	if config.RateLimit != nil && *config.RateLimit < 0 {
+		return fmt.Errorf("max_transitions cannot be negative, got %d", *config.RateLimit)
+	}
+	if config.RateWindow < 0 {
+		return fmt.Errorf("transition_window cannot be negative, got %d", config.RateWindow)
+	}
+
+	if config.MaxTreeSize != nil && *config.MaxTreeSize < 0 {
+		return fmt.Errorf("max_tree_size cannot be negative, got %d", *config.MaxTreeSize)
+	}
+
+	if config.MinMemory != "" {
+		if _, err := parseSize(config.MinMemory); err != nil {
+			return fmt.Errorf("min_available_memory: %v", err)
+		}
+	}
+	for option, bus := range map[string]string{"tuned_bus": config.TunedBus, "scx_bus": config.ScxBus, "ppd_bus": config.PpdBus} {
+		if bus != "" && bus != "system" && bus != "session" {
+			return fmt.Errorf("%s must be system or session, got '%s'", option, bus)
+		}
+	}
+	if config.CgroupFilter != "" && config.CgroupFilter != "user" && !strings.HasPrefix(config.CgroupFilter, "/") {
+		return fmt.Errorf("cgroup_filter must be user or a cgroup path starting with /, got '%s'", config.CgroupFilter)
+	}
+	if config.MaxLoadavg < 0 {
+		return fmt.Errorf("max_loadavg cannot be negative, got %g", config.MaxLoadavg)
+	}
+
+	if config.FocusBoost != nil {
+		if err := config.FocusBoost.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range config.Blacklist {
+		if strings.TrimSpace(strings.TrimPrefix(entry, blacklistCmdline)) == "" {
+			return fmt.Errorf("blacklist entries cannot be empty")
+		}
+	}
+	for _, suppressor := range config.Suppressors {
+		if strings.TrimSpace(suppressor) == "" {
+			return fmt.Errorf("suppressor pattern cannot be empty")
+		}
+	}
+	if err := validateTransitions(config); err != nil {
+		return err
+	}
+	if err := validateGroups(config); err != nil {
+		return err
+	}
+
+	for _, pauser := range config.ScanPausers {
+		if strings.TrimSpace(pauser) == "" {
+			return fmt.Errorf("scan_pausers names cannot be empty")
+		}
+	}
+	if config.LogBuffer != nil && *config.LogBuffer < 0 {
+		return fmt.Errorf("log_buffer cannot be negative, got %d", *config.LogBuffer)
+	}
+	if config.PausedScan < 0 {
+		return fmt.Errorf("paused_scan_interval cannot be negative, got %d", config.PausedScan)
+	}
+	if config.ReapplyWindow != "" {
+		if d, err := time.ParseDuration(config.ReapplyWindow); err != nil || d <= 0 {
+			return fmt.Errorf("reapply_window must be a positive duration, got '%s'", config.ReapplyWindow)
+		}
+	}
+	if config.FullScan < 0 {
+		return fmt.Errorf("full_scan_interval cannot be negative, got %d", config.FullScan)
 	}
 
 	return nil
@@ -135,6 +351,11 @@ func validateConfigSecurity(configPath string) error {
 		return fmt.Errorf("config file is world-writable")
 	}
 
+	// Running as root, whoever can write the config runs its commands as root
+	if os.Geteuid() == 0 && info.Mode().Perm()&0020 != 0 {
+		return fmt.Errorf("config file is group-writable, which is refused when running as root")
+	}
+
 	// Check ownership
 	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
 		if stat.Uid != uint32(os.Getuid()) {
@@ -147,6 +368,14 @@ func validateConfigSecurity(configPath string) error {
 
 // Find configuration file by searching in multiple locations
 func findConfigFile() (string, error) {
+	// A path given explicitly replaces the search, a missing file isn't hidden by another one
+	if path, source := explicitConfigPath(); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("configuration file %s given by %s: %v", path, source, err)
+		}
+		return filepath.Abs(path)
+	}
+
 	var searchPaths []string
 
 	// Get user config directory
@@ -174,6 +403,17 @@ func findConfigFile() (string, error) {
 	return "", fmt.Errorf("no configuration file found. Searched paths:\n  %s", strings.Join(triedPaths, "\n  "))
 }
 
+// Path of the config given by --config, or else by PROCESS_PILLZ_CONFIG, with where it comes from
+func explicitConfigPath() (string, string) {
+	if configFlag != "" {
+		return configFlag, "--config"
+	}
+	if path := os.Getenv("PROCESS_PILLZ_CONFIG"); path != "" {
+		return path, "PROCESS_PILLZ_CONFIG"
+	}
+	return "", ""
+}
+
 // Load and validate configuration from file
 func loadConfig() (*Config, string, error) {
 	configPath, err := findConfigFile()
@@ -181,32 +421,138 @@ func loadConfig() (*Config, string, error) {
 		return nil, "", err
 	}
 
+	config, _, err := readConfigFile(configPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The errors of the merged config can come from the drop-ins too
+	source := configPath
+	dropIns, err := mergeDropIns(config, configPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(dropIns) > 0 {
+		source = fmt.Sprintf("%s with the drop-ins of %s", configPath, dropInDir(configPath))
+	}
+
+	if err := prepareConfig(config); err != nil {
+		return nil, "", fmt.Errorf("config validation failed for %s: %v", source, err)
+	}
+
+	return config, configPath, nil
+}
+
+// Resolves what the config leaves implicit and validates it, once the drop-ins are merged
+func prepareConfig(config *Config) error {
+	// Triggers listing their patterns without a pill are named after it
+	for name, trigger := range config.Triggers {
+		if trigger.Pill == "" && len(trigger.Patterns) > 0 {
+			trigger.Pill = name
+			config.Triggers[name] = trigger
+		}
+	}
+
+	// Pills extending others get their options before anything checks them
+	if err := resolveExtends(config.Pills); err != nil {
+		return err
+	}
+
+	if err := dropUnmetPills(config); err != nil {
+		return err
+	}
+
+	return validateConfig(config)
+}
+
+// Reads a config file, the main one or a drop-in, and returns it with its content
+func readConfigFile(configPath string) (*Config, []byte, error) {
 	// Only validate security for user-owned files (not system examples)
 	if !strings.HasPrefix(configPath, "/usr/share/") {
 		if err := validateConfigSecurity(configPath); err != nil {
-			return nil, "", fmt.Errorf("config security validation failed for %s: %v", configPath, err)
+			return nil, nil, fmt.Errorf("config security validation failed for %s: %v", configPath, err)
 		}
 	}
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("error reading config file %s: %v", configPath, err)
+		return nil, nil, fmt.Errorf("error reading config file %s: %v", configPath, err)
 	}
 
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, "", fmt.Errorf("error parsing config file %s: %v", configPath, err)
+		return nil, nil, fmt.Errorf("error parsing config file %s: %v", configPath, explainYamlError(data, err))
+	}
+	warnUnknownKeys(data, configPath)
+
+	return &config, data, nil
+}
+
+// Top level keys of the config file, from the tags of Config
+func configKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := range t.NumField() {
+		// Fields without a tag are derived from the others
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name != "" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// Warns about the top level keys process_pillz doesn't know, likely typos.
+// Keys starting with x- are left for the user, like sections holding YAML anchors.
+func warnUnknownKeys(data []byte, configPath string) {
+	var top map[string]any
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return
+	}
+
+	known := configKeys()
+	for key := range top {
+		if !strings.HasPrefix(key, "x-") && !slices.Contains(known, key) {
+			Logger.Warnf("Unknown key '%s' in %s, ignoring it. Prefix it with x- if it is on purpose", key, configPath)
+		}
+	}
+}
+
+// Prints the config as process_pillz reads it, with the anchors and aliases expanded
+func printResolvedConfig() error {
+	configPath, err := findConfigFile()
+	if err != nil {
+		return err
+	}
+	if _, _, err := loadConfig(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading config file %s: %v", configPath, err)
 	}
 
-	if err := validateConfig(&config); err != nil {
-		return nil, "", fmt.Errorf("config validation failed for %s: %v", configPath, err)
+	// Decoding to plain values expands the aliases
+	var resolved map[string]any
+	if err := yaml.Unmarshal(data, &resolved); err != nil {
+		return fmt.Errorf("error parsing config file %s: %v", configPath, err)
+	}
+	if err := mergeResolvedDropIns(resolved, configPath); err != nil {
+		return err
 	}
 
-	return &config, configPath, nil
+	fmt.Printf("# Resolved from %s\n", configPath)
+	for _, path := range dropInFiles(configPath) {
+		fmt.Printf("# Merged with %s\n", path)
+	}
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return encoder.Encode(resolved)
 }
 
 // watchConfigFile watches the config file and sends a signal when it changes
-func watchConfigFile(configPath string, restartChan chan struct{}) {
+func watchConfigFile(configPath string, reloadChan chan struct{}) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		Logger.Errorf("Failed to create config watcher: %v", err)
@@ -214,16 +560,24 @@ func watchConfigFile(configPath string, restartChan chan struct{}) {
 	}
 	defer watcher.Close()
 
-	// Watch the config file
-	err = watcher.Add(configPath)
+	// Watch the directory, editors often replace the file instead of writing it
+	err = watcher.Add(filepath.Dir(configPath))
 	if err != nil {
 		Logger.Errorf("Failed to watch config file: %v", err)
 		return
 	}
 
+	// The drop-ins too, with the parents of their directory to notice it being created
+	dropIns := dropInDir(configPath)
+	for _, dir := range []string{filepath.Dir(dropIns), dropIns} {
+		if dir != filepath.Dir(configPath) {
+			watcher.Add(dir)
+		}
+	}
+
 	Logger.Infof("Watching config file for changes: %s", configPath)
 
-	// Debounce timer to avoid multiple rapid restarts
+	// Debounce timer to avoid multiple rapid reloads
 	var debounceTimer *time.Timer
 	const debounceDelay = 1 * time.Second
 
@@ -234,7 +588,22 @@ func watchConfigFile(configPath string, restartChan chan struct{}) {
 				return
 			}
 
-			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+			var changed bool
+			switch name := filepath.Clean(event.Name); {
+			case name == filepath.Clean(configPath):
+				changed = event.Has(fsnotify.Write) || event.Has(fsnotify.Create)
+			case filepath.Dir(name) == dropIns && filepath.Ext(name) == ".yaml":
+				// Removing a drop-in drops its pills
+				changed = event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+			case name == dropIns || name == filepath.Dir(dropIns):
+				if event.Has(fsnotify.Create) {
+					watcher.Add(name)
+					watcher.Add(dropIns)
+				}
+				changed = name == dropIns
+			}
+
+			if changed {
 				Logger.Infof("Config file changed: %s", event.Name)
 
 				// Reset debounce timer
@@ -243,9 +612,9 @@ func watchConfigFile(configPath string, restartChan chan struct{}) {
 				}
 				debounceTimer = time.AfterFunc(debounceDelay, func() {
 					select {
-					case restartChan <- struct{}{}:
+					case reloadChan <- struct{}{}:
 					default:
-						// Channel is full, restart already pending
+						// Channel is full, reload already pending
 					}
 				})
 			}
@@ -259,8 +628,150 @@ func watchConfigFile(configPath string, restartChan chan struct{}) {
 	}
 }
 
+// Runs a subcommand and returns the exit status
+func runSubcommand(args []string) int {
+	switch args[0] {
+	case "snapshot":
+		if err := writeSnapshot(os.Stdout); err != nil {
+			Logger.Errorf("Snapshot failed: %v", err)
+			return 1
+		}
+
+	case "simulate":
+		flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+		procs := flags.String("procs", "", "snapshot of the processes, as written by the snapshot subcommand")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *procs == "" {
+			Logger.Error("simulate needs a snapshot, use --procs snapshot.json")
+			return 2
+		}
+
+		config, configPath, err := loadConfig()
+		if err != nil {
+			Logger.Errorf("Configuration error: %v", err)
+			return 1
+		}
+		Logger.Infof("Using configuration file: %s", configPath)
+
+		if err := simulate(*config, *procs); err != nil {
+			Logger.Errorf("Simulation failed: %v", err)
+			return 1
+		}
+
+	case "explain":
+		if len(args) != 2 {
+			Logger.Error("Usage: process_pillz explain <pid>")
+			return 2
+		}
+		if err := sendControl(args, os.Stdout); err != nil {
+			Logger.Error(err)
+			return 1
+		}
+
+	case "config":
+		if err := printResolvedConfig(); err != nil {
+			Logger.Errorf("Configuration error: %v", err)
+			return 1
+		}
+
+	case "logs":
+		if len(args) != 1 && (len(args) != 3 || args[1] != "--last") {
+			Logger.Error("Usage: process_pillz logs [--last <lines>]")
+			return 2
+		}
+		if err := sendControl(args, os.Stdout); err != nil {
+			Logger.Error(err)
+			return 1
+		}
+
+	case "status":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "--json") {
+			Logger.Error("Usage: process_pillz status [--json]")
+			return 2
+		}
+		if err := sendControl(args, os.Stdout); err != nil {
+			Logger.Error(err)
+			return 1
+		}
+
+	case "audit":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "--restore") {
+			Logger.Error("Usage: process_pillz audit [--restore]")
+			return 2
+		}
+		if err := sendControl(args, os.Stdout); err != nil {
+			// Without the daemon, the journal tells what it left applied
+			Logger.Infof("%v, reading the journal instead", err)
+			if err := auditJournal(len(args) == 2); err != nil {
+				Logger.Error(err)
+				return 1
+			}
+		}
+
+	case "tree":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "--json") {
+			Logger.Error("Usage: process_pillz tree [--json]")
+			return 2
+		}
+		if err := sendControl(args, os.Stdout); err != nil {
+			Logger.Error(err)
+			return 1
+		}
+
+	case "switch":
+		if len(args) != 2 {
+			Logger.Error("Usage: process_pillz switch <pill>")
+			return 2
+		}
+		if err := sendControl(args, os.Stdout); err != nil {
+			Logger.Error(err)
+			return 1
+		}
+
+	case "backends":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "--json") {
+			Logger.Error("Usage: process_pillz backends [--json]")
+			return 2
+		}
+		if err := listBackends(os.Stdout, len(args) == 2); err != nil {
+			Logger.Error(err)
+			return 1
+		}
+
+	case "healthcheck":
+		healthy, err := healthcheck()
+		if err != nil {
+			Logger.Error(err)
+			return 1
+		}
+		if !healthy {
+			return 1
+		}
+
+	default:
+		Logger.Errorf("Unknown command %s, valid commands are: snapshot, simulate, explain, tree, config, logs, status, audit, switch, backends, healthcheck", args[0])
+		return 2
+	}
+	return 0
+}
+
 func main() {
-	Logger = createLogger()
+	debugDecisions := flag.Bool("debug-decisions", false, "log the inputs and the decision of every scan")
+	flag.StringVar(&configFlag, "config", "", "config file to use instead of searching for one")
+	takeover := flag.Bool("takeover", false, "ask the running instance to shut down instead of exiting")
+	flag.Parse()
+
+	// Subcommands print their results on stdout, the logs go out of the way
+	if flag.NArg() > 0 {
+		Logger = createLogger("stderr")
+		os.Exit(runSubcommand(flag.Args()))
+	}
+
+	// The ring keeps the debug logs for the logs command, its size is set by the config
+	logBuffer = newLogRing(defaultLogBuffer)
+	Logger = createLogger("stdout").WithOptions(logBuffer.tee())
 
 	Logger.Infof("Process Pillz %s (commit %s, built %s)", Version, GitCommit, BuildTime)
 
@@ -270,39 +781,114 @@ func main() {
 		Logger.Fatalf("Configuration error: %v", err)
 	}
 
-	Logger.Infof("Using configuration file: %s", configPath)
+	// Two instances would fight over the same settings every scan
+	if err := acquireInstanceLock(*takeover); errors.Is(err, errSystemInstance) {
+		Logger.Errorf("Not starting: %v", err)
+		os.Exit(exitSystemInstance)
+	} else if err != nil {
+		Logger.Fatalf("Not starting: %v", err)
+	}
 
-	// Create restart channel for config watcher
-	restartChan := make(chan struct{}, 1)
+	// Create reload channel for config watcher
+	reloadChan := make(chan struct{}, 1)
 
 	// Initializing the manager and starting the loop
 	pm := NewPillManager(*config)
+	pm.traceDecisions = *debugDecisions
 
 	pm.connectToDbus()
 
+	// TuneD profiles and scx schedulers listed by a previous instance, if still valid
+	pm.loadBackendCache()
+
+	// Undo what a crashed instance left applied, unless disabled
+	pm.recoverJournal(config.CrashRecovery == nil || *config.CrashRecovery)
+
+	// The system starts from the default pill, unless the recovery just ate it
+	if pm.CurrentPill == "" {
+		pm.eatPill(nil, pm.defaultPill, reasonStartup)
+	}
+
+	// Other tools changing the same knobs make pills look broken
+	pm.detectConflicts()
+
+	pm.logStartup(configPath)
+
 	defer pm.dbusConn.Close()
 	defer pm.ticker.Stop()
 
 	// Start config file watcher in a goroutine
-	go watchConfigFile(configPath, restartChan)
+	go watchConfigFile(configPath, reloadChan)
+
+	// Track the focused window, if enabled
+	focusChan := make(chan int32, 1)
+	if config.FocusBoost != nil {
+		watchFocus(*config.FocusBoost, focusChan)
+	}
+
+	// Check the triggers again as soon as the machine resumes
+	resumeChan := make(chan struct{}, 1)
+	watchSleep(pm.dbusConn, resumeChan)
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Log the status on demand
+	statusChan := make(chan os.Signal, 1)
+	signal.Notify(statusChan, syscall.SIGUSR1)
+
+	// Enable the actions disabled after failing
+	enableChan := make(chan os.Signal, 1)
+	signal.Notify(enableChan, syscall.SIGUSR2)
+
+	// Commands of the control socket, like explain
+	controlChan := make(chan controlRequest)
+	control, err := listenControl(controlChan)
+	if err != nil {
+		Logger.Warnf("Couldn't open the control socket, explain is unavailable : %v", err)
+	}
+
 	for {
 		select {
 		case <-sigChan:
 			Logger.Info("Shutting down...")
-			pm.eatPill(nil, "default") // Reset to default profile
-			pm.Close()
+			if control != nil {
+				control.Close()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			err := pm.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				Logger.Error(err)
+				os.Exit(1)
+			}
 			os.Exit(0)
 
-		case <-restartChan:
-			Logger.Info("Config file changed, restarting...")
-			pm.eatPill(nil, "default") // Reset to default profile
-			pm.Close()
-			os.Exit(42) // Special exit code to indicate restart needed
+		case <-reloadChan:
+			Logger.Info("Config file changed, reloading...")
+			newConfig, _, err := loadConfig()
+			if err != nil {
+				Logger.Errorf("Keeping the current configuration: %v", err)
+				continue
+			}
+			pm.reload(*newConfig)
+
+		case pid := <-focusChan:
+			pm.boostFocus(pid)
+
+		case <-statusChan:
+			pm.logStatus()
+
+		case <-enableChan:
+			pm.enableActions()
+
+		case request := <-controlChan:
+			request.reply <- pm.handleControl(request.args)
+
+		case <-resumeChan:
+			pm.clockJumped("Resumed from suspend")
+			pm.scanProcesses()
 
 		case <-pm.ticker.C:
 			pm.scanProcesses()
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// The tests log nowhere, and keep the run and state files in a directory of their own
+func TestMain(m *testing.M) {
+	Logger = zap.NewNop().Sugar()
+
+	dir, err := os.MkdirTemp("", "process_pillz_test")
+	if err != nil {
+		panic(err)
+	}
+	for _, env := range []string{"XDG_RUNTIME_DIR", "XDG_STATE_HOME", "XDG_CONFIG_HOME"} {
+		path := filepath.Join(dir, env)
+		if err := os.Mkdir(path, 0700); err != nil {
+			panic(err)
+		}
+		os.Setenv(env, path)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Interval between the checks of the processes of the tree whose MangoHud overlay isn't ready
+const hudRecheckInterval = 5 * time.Second
+
+// Time a process of the tree gets to load MangoHud and open its control socket after joining
+// the tree. The overlay starts with the renderer of the game, not with its process.
+const hudReadyTimeout = 2 * time.Minute
+
+// Time a MangoHud control socket gets to take the command
+const hudTimeout = time.Second
+
+// A process of the tree whose overlay wasn't toggled yet, with the mangohud option
+type hudPending struct {
+	member *treeMember
+	since  time.Time // When it joined the tree
+	queued bool      // Whether a check waits on the tree worker
+}
+
+// Whether a process loaded MangoHud, its Vulkan layer or its OpenGL library, from its memory maps
+func hudLoaded(pid int32) bool {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if bytes.Contains(scanner.Bytes(), []byte("libMangoHud")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Abstract socket MangoHud listens on for a process, with control=mangohud-%p in its config
+func hudSocket(pid int32) string {
+	return fmt.Sprintf("@mangohud-%d", pid)
+}
+
+// Shows or hides the overlay of a process, with the hud command of the MangoHud control
+// protocol. There is no command to read whether the overlay shows, only to toggle it.
+func toggleHud(pid int32) error {
+	conn, err := net.DialTimeout("unix", hudSocket(pid), hudTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(hudTimeout))
+	_, err = io.WriteString(conn, ":hud;")
+	return err
+}
+
+// Toggles the MangoHud overlay of the processes that joined the tree. Those that didn't load
+// MangoHud or open its control socket yet wait, and are checked again every hudRecheckInterval
+// until hudReadyTimeout. Processes without MangoHud are left alone.
+func (pm *PillManager) updateHud(members []*treeMember) {
+	if pm.treeRefused {
+		return
+	}
+
+	now := time.Now()
+	for _, member := range members {
+		pid := member.p.PID()
+		if member.procInfo.HudToggled || pm.hudWaiting[pid] != nil {
+			continue
+		}
+		pending := &hudPending{member: member, since: now}
+		pm.hudWaiting[pid] = pending
+		pm.checkHud(pending)
+	}
+
+	if now.Sub(pm.hudChecked) < hudRecheckInterval {
+		return
+	}
+	pm.hudChecked = now
+	for _, pid := range sortedKeys(pm.hudWaiting) {
+		if pending := pm.hudWaiting[pid]; !pm.currentScan[pid] {
+			delete(pm.hudWaiting, pid)
+		} else if !pending.queued {
+			pm.checkHud(pending)
+		}
+	}
+}
+
+// Toggles the overlay of a waiting process once MangoHud is ready, on the tree worker
+func (pm *PillManager) checkHud(pending *hudPending) {
+	pending.queued = true
+	procInfo := pending.member.procInfo
+	pid, name := pending.member.p.PID(), procInfo.Name
+
+	pm.queueTreeJob(func() func() {
+		loaded := hudLoaded(pid)
+		err := errors.New("MangoHud isn't loaded")
+		if loaded {
+			err = toggleHud(pid)
+		}
+
+		return func() {
+			pending.queued = false
+			if err == nil {
+				procInfo.HudToggled = true
+				delete(pm.hudWaiting, pid)
+				Logger.Infof("toggled the MangoHud overlay of %s (PID %d)", name, pid)
+				return
+			}
+			if time.Since(pending.since) < hudReadyTimeout {
+				return
+			}
+
+			delete(pm.hudWaiting, pid)
+			if loaded && !pm.hudWarned {
+				Logger.Warnf("%s (PID %d) loaded MangoHud, but its control socket can't be reached, add control=mangohud-%%p to the MangoHud config for the mangohud option : %v", name, pid, err)
+				pm.hudWarned = true
+			} else if !loaded {
+				Logger.Debugf("%s (PID %d) didn't load MangoHud, leaving it alone", name, pid)
+			}
+		}
+	})
+}
+
+// Toggles back the overlay of a process of the tree, when the pill is reverted
+func restoreHud(pid int32) error {
+	err := toggleHud(pid)
+	if err != nil {
+		if _, statErr := os.Stat(fmt.Sprintf("/proc/%d", pid)); errors.Is(statErr, os.ErrNotExist) {
+			return os.ErrNotExist
+		}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+// Stands for the control socket MangoHud opens for the test process, and returns what it receives
+func fakeHudSocket(t *testing.T) <-chan string {
+	t.Helper()
+	listener, err := net.Listen("unix", hudSocket(int32(os.Getpid())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+	return received
+}
+
+func TestToggleHud(t *testing.T) {
+	received := fakeHudSocket(t)
+	if err := toggleHud(int32(os.Getpid())); err != nil {
+		t.Fatal(err)
+	}
+	if command := <-received; command != ":hud;" {
+		t.Errorf("MangoHud received %q, want :hud;", command)
+	}
+
+	// Processes without MangoHud are found from their maps, and have no socket to toggle
+	if hudLoaded(int32(os.Getpid())) {
+		t.Error("the test process doesn't load MangoHud")
+	}
+	if err := toggleHud(int32(os.Getppid())); err == nil {
+		t.Error("toggled a process without MangoHud socket")
+	}
+}
+
+func TestMangoHudOption(t *testing.T) {
+	pills := map[string]string{
+		"{default: {scx: rusty}, game: {mangohud: true}}": "",
+		"{default: {scx: rusty}, game: {mangohud: yes}}":  "mangohud in pill 'game' must be true or false, got 'yes'",
+		"{default: {mangohud: true}, game: {nice: 5}}":    "mangohud cannot be used in the default pill 'default', it has no trigger",
+	}
+	for pills, want := range pills {
+		_, err := parseTestConfig(t, "scan_interval: 1\ntriggers: {zzgame: game}\npills: "+pills+"\n")
+		if (err == nil) != (want == "") || (err != nil && err.Error() != want) {
+			t.Errorf("%s: error %v, want %q", pills, err, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Eats a pill asked for with the switch command, for a benchmark or a game without a trigger.
+// Like an on_exit pill, it stays until a trigger matches. Switching to default hands the pill
+// back to the triggers, default standing for the pill named by default_pill.
+func (pm *PillManager) manualSwitch(pillName string) string {
+	if pillName == "default" {
+		pillName = pm.defaultPill
+	}
+	if _, exists := pm.Pillz[pillName]; !exists {
+		return fmt.Sprintf("%sno pill named %s, the pills are: %s\n", controlError, pillName, strings.Join(sortedKeys(pm.Pillz), ", "))
+	}
+	if pm.isProcessScoped(pillName) {
+		return fmt.Sprintf("%spill %s is process-scoped, it only applies to the trees of its triggers\n", controlError, pillName)
+	}
+	if pm.suppressedBy != "" {
+		return fmt.Sprintf("%spills are suppressed while '%s' runs\n", controlError, pm.suppressedBy)
+	}
+
+	Logger.Infof("Switching to pill %s on request", pillName)
+	previous := pm.lastEvent
+	pm.eatPill(nil, pillName, reasonManual)
+	if pm.lastEvent == previous {
+		return fmt.Sprintf("%spill %s wasn't eaten, see the log\n", controlError, pillName)
+	}
+
+	var failed []string
+	for _, action := range pm.lastEvent.Actions {
+		if action.Error != "" {
+			failed = append(failed, fmt.Sprintf("  %s: %s", action.Name, action.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Sprintf("%spill %s eaten, but actions failed:\n%s\n", controlError, pillName, strings.Join(failed, "\n"))
+	}
+	if pillName == pm.defaultPill {
+		return fmt.Sprintf("Eating pill %s, the triggers pick the pill again\n", pillName)
+	}
+	return fmt.Sprintf("Eating pill %s until a trigger matches, switch to %s to revert\n", pillName, pm.defaultPill)
+}
+
+// Pill eaten with the switch command and still held, empty for none
+func (pm *PillManager) manualHold() string {
+	if !pm.exitHeld || pm.lastEvent == nil || pm.lastEvent.Reason != reasonManual {
+		return ""
+	}
+	return pm.CurrentPill
+}
+
+// Eats the held pill again once the config is reloaded, so that its new options apply without
+// going through the default pill. Returns whether it is still held, the new config can lack it.
+func (pm *PillManager) keepHold(held string) bool {
+	if _, exists := pm.Pillz[held]; !exists || pm.isProcessScoped(held) {
+		Logger.Errorf("Pill %s was held by the switch command, but the new config has no such pill anymore, reverting to %s", held, pm.defaultPill)
+		pm.eatPill(nil, pm.defaultPill, reasonReload)
+		return false
+	}
+	Logger.Infof("Pill %s is held by the switch command, eating it again with the new config", held)
+	pm.eatPill(nil, held, reasonManual)
+	return true
+}
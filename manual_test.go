@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const holdConfig = `
+scan_interval: 1
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {nice: 5}
+  bench: {scx: %s}
+`
+
+func TestHoldSurvivesReload(t *testing.T) {
+	pm, _ := newFakeManager(t, fmt.Sprintf(holdConfig, "lavd"))
+	if reply := pm.manualSwitch("bench"); strings.HasPrefix(reply, controlError) {
+		t.Fatalf("switch failed: %s", reply)
+	}
+	expectPill(t, pm, "bench", 0)
+
+	// The held pill is eaten again with its new options, without going through default
+	cfg, err := parseTestConfig(t, fmt.Sprintf(holdConfig, "bpfland"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := observeLogs(t, zapcore.InfoLevel)
+	pm.reload(*cfg)
+	if pm.CurrentPill != "bench" || pm.lastEvent.Reason != reasonManual || pm.lastEvent.FromPill != "bench" {
+		t.Fatalf("pill %s after %v, want bench held", pm.CurrentPill, pm.lastEvent)
+	}
+	if logs.FilterMessageSnippet("pill bench is still held by the switch command").Len() != 1 {
+		t.Errorf("the reload log doesn't tell the hold: %v", logs.All())
+	}
+	expectPill(t, pm, "bench", 0)
+
+	if err := pm.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pm.CurrentPill != "default" {
+		t.Errorf("pill %s after the shutdown, want default", pm.CurrentPill)
+	}
+}
+
+func TestHoldLostOnReload(t *testing.T) {
+	pm, _ := newFakeManager(t, fmt.Sprintf(holdConfig, "lavd"))
+	pm.manualSwitch("bench")
+
+	cfg, err := parseTestConfig(t, fakeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := observeLogs(t, zapcore.ErrorLevel)
+	pm.reload(*cfg)
+	if pm.CurrentPill != "default" {
+		t.Fatalf("pill %s, want default once bench is gone", pm.CurrentPill)
+	}
+	if logs.FilterMessageSnippet("Pill bench was held").Len() != 1 {
+		t.Errorf("no error about the lost hold: %v", logs.All())
+	}
+}
+
+func TestReloadRevertsTriggeredPill(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+
+	cfg, err := parseTestConfig(t, fakeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.reload(*cfg)
+	if pm.CurrentPill != "default" || pm.lastEvent.Reason != reasonReload {
+		t.Fatalf("pill %s after %v, want default eaten by the reload", pm.CurrentPill, pm.lastEvent)
+	}
+	expectPill(t, pm, "game", 100)
+}
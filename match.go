@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Prefixes of the trigger keys holding a regular expression, a glob or an environment variable
+// instead of a substring
+const (
+	regexPrefix = "re:"
+	globPrefix  = "glob:"
+	envPrefix   = "env:"
+)
+
+// What the triggers can be matched against
+var matchTargets = []string{"cmdline", "exe", "name", "cgroup"}
+
+// Returns what a trigger is matched against for a process, false if the process doesn't have it
+func (t Trigger) target(procInfo *ProcessInfo) (string, bool) {
+	switch t.Match {
+	case "exe":
+		return procInfo.Exe, procInfo.Exe != ""
+	case "name":
+		return procInfo.Name, true
+	case "cgroup":
+		return procInfo.cgroup, procInfo.cgroup != ""
+	}
+	return procInfo.Cmdline, true
+}
+
+// Compiled pattern of a trigger, matched against the command lines
+type matcher struct {
+	substring string
+	exact     bool // Whether the substring must be the whole string, for process names
+	regex     *regexp.Regexp
+	env       string // Variable the environment must hold, NAME=value, or NAME for any value
+}
+
+// Compiles the key of a trigger. Keys starting with re: are regular expressions, those starting
+// with glob: are globs matching the whole command line or path, the others are substrings of it.
+// Process names are short and compared whole instead. Case-insensitive triggers become
+// regular expressions, so that the scans don't have to lowercase every command line.
+// Keys starting with env: match the environment of the process instead, whatever match is.
+func compileMatcher(pattern string, trigger Trigger) (matcher, error) {
+	if variable, isEnv := strings.CutPrefix(pattern, envPrefix); isEnv {
+		if name, _, _ := strings.Cut(variable, "="); name == "" {
+			return matcher{}, fmt.Errorf("the environment variable has no name")
+		}
+		return matcher{env: variable}, nil
+	}
+
+	var expr string
+	if glob, isGlob := strings.CutPrefix(pattern, globPrefix); isGlob {
+		var err error
+		if expr, err = globToRegex(glob); err != nil {
+			return matcher{}, err
+		}
+	} else if regex, isRegex := strings.CutPrefix(pattern, regexPrefix); isRegex {
+		expr = regex
+	} else if !trigger.IgnoreCase {
+		return matcher{substring: pattern, exact: trigger.Match == "name"}, nil
+	} else if trigger.Match == "name" {
+		expr = "^" + regexp.QuoteMeta(pattern) + "$"
+	} else {
+		expr = regexp.QuoteMeta(pattern)
+	}
+
+	if trigger.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return matcher{}, err
+	}
+	return matcher{regex: regex}, nil
+}
+
+// Translates a glob to an anchored regular expression. Command lines hold paths and arguments,
+// so * matches any characters, slashes included, ? matches one, and [...] a class of them.
+func globToRegex(glob string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			b.WriteString(".*")
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("trailing backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed character class at offset %d", i)
+			}
+			class := glob[i+1 : i+1+end]
+			if negated, found := strings.CutPrefix(class, "!"); found {
+				class = "^" + negated
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+
+	if _, err := regexp.Compile(b.String()); err != nil {
+		return "", fmt.Errorf("invalid character class: %v", err)
+	}
+	return b.String(), nil
+}
+
+func (m matcher) matches(s string, environ []string) bool {
+	if m.env != "" {
+		if strings.Contains(m.env, "=") {
+			return slices.Contains(environ, m.env)
+		}
+		return slices.ContainsFunc(environ, func(variable string) bool { return strings.HasPrefix(variable, m.env+"=") })
+	}
+	if m.regex != nil {
+		return m.regex.MatchString(s)
+	}
+	if m.exact {
+		return s == m.substring
+	}
+	return strings.Contains(s, m.substring)
+}
+
+// Patterns of a trigger, its key unless it lists them
+func (t Trigger) patterns(name string) []string {
+	if len(t.Patterns) > 0 {
+		return t.Patterns
+	}
+	return []string{name}
+}
+
+// Compiles the patterns of the triggers once, the scans reuse them.
+// The config was validated, patterns failing to compile were rejected.
+func compileMatchers(triggers map[string]Trigger) map[string][]matcher {
+	return compilePatterns(triggers, Trigger.patterns)
+}
+
+// Compiles the exclusion patterns of the triggers, they follow the same syntax
+func compileExclusions(triggers map[string]Trigger) map[string][]matcher {
+	return compilePatterns(triggers, func(t Trigger, _ string) []string { return t.Exclude })
+}
+
+func compilePatterns(triggers map[string]Trigger, patterns func(Trigger, string) []string) map[string][]matcher {
+	matchers := make(map[string][]matcher, len(triggers))
+	for name, trigger := range triggers {
+		for _, pattern := range patterns(trigger, name) {
+			if m, err := compileMatcher(pattern, trigger); err == nil {
+				matchers[name] = append(matchers[name], m)
+			}
+		}
+	}
+	return matchers
+}
+
+// Whether one of the patterns of a trigger matches
+func (pm *PillManager) includes(name string, target string, environ []string) bool {
+	for _, m := range pm.matchers[name] {
+		if m.matches(target, environ) {
+			return true
+		}
+	}
+	return false
+}
+
+// Index of the first exclusion pattern of a trigger that matches, -1 for none
+func (pm *PillManager) exclusion(name string, target string, environ []string) int {
+	for i, m := range pm.exclusions[name] {
+		if m.matches(target, environ) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Whether a trigger matches environment variables, the environments of the processes are
+// only read then
+func usesEnv(triggers map[string]Trigger) bool {
+	for name, trigger := range triggers {
+		for _, pattern := range append(trigger.patterns(name), trigger.Exclude...) {
+			if strings.HasPrefix(pattern, envPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether a trigger matches cgroup paths, the cgroups of the processes are only read then
+func usesCgroup(triggers map[string]Trigger) bool {
+	for _, trigger := range triggers {
+		if trigger.Match == "cgroup" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Patterns come from the config and command lines from any process: matching never panics, what
+// reaches the logs is printable, and a substring pattern matches the command lines holding it
+func FuzzMatch(f *testing.F) {
+	seeds := []struct {
+		pattern    string
+		cmdline    string
+		ignoreCase bool
+	}{
+		{"zzgame", "zzgame --fullscreen", false},
+		{"re:^wine.*\\.exe", "wine Z:\\Games\\Game.exe\x00-windowed", false},
+		{"glob:*/steamapps/common/*", "/home/user/.steam/steamapps/common/Game/game.exe", false},
+		{"glob:[!a-", "game", false},
+		{"Game.EXE", "C:\\GAMES\\game.exe -dx12", true},
+		{"zzgame", "\x1b[31mzzgame\x07 \x00\x01\x7f", false},
+		{"\xff", "\xff\xfe\xfd", false},
+		{"é", "caf\xc3\xa9 \xe2\x80\x8b game", true},
+		{"env:STEAM_COMPAT_APP_ID=1", "reaper SteamLaunch AppId=1", false},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.pattern, seed.cmdline, seed.ignoreCase)
+	}
+
+	f.Fuzz(func(t *testing.T, pattern string, cmdline string, ignoreCase bool) {
+		shown, _ := printable(cmdline, nil)
+		if !utf8.ValidString(shown) || strings.ContainsFunc(shown, func(r rune) bool { return !unicode.IsPrint(r) }) {
+			t.Fatalf("printable(%q) = %q, which isn't printable", cmdline, shown)
+		}
+
+		m, err := compileMatcher(pattern, Trigger{IgnoreCase: ignoreCase})
+		if err != nil {
+			return
+		}
+		m.matches(shown, []string{"STEAM_COMPAT_APP_ID=1"})
+
+		// A substring pattern matches a printable command line holding it
+		plain := !strings.HasPrefix(pattern, regexPrefix) && !strings.HasPrefix(pattern, globPrefix) && !strings.HasPrefix(pattern, envPrefix)
+		if clean, _ := printable(pattern, nil); plain && clean == pattern && !m.matches("run "+pattern+" --arg", nil) {
+			t.Fatalf("pattern %q doesn't match a command line holding it", pattern)
+		}
+	})
+}
+
+// Proton reports the executable of a game with the case it was launched with, which changes
+// between launches from Steam, a shortcut or the game's own launcher
+func TestIgnoreCase(t *testing.T) {
+	cmdlines := []string{
+		`Z:\home\user\Games\EldenRing\Game\eldenring.exe`,
+		`Z:\home\user\Games\EldenRing\Game\EldenRing.exe -eac-nop-loaded`,
+		`C:\PROGRAM FILES (X86)\STEAM\STEAMAPPS\COMMON\ELDEN RING\GAME\ELDENRING.EXE`,
+		`/home/user/.steam/steam/steamapps/common/Proton 9.0/files/bin/wine64 c:\Games\EldenRing.EXE`,
+	}
+	tests := []struct {
+		name    string
+		pattern string
+		trigger Trigger
+		matched []bool // By command line
+	}{
+		{"substring", "eldenring.exe", Trigger{IgnoreCase: true}, []bool{true, true, true, true}},
+		{"case-sensitive by default", "eldenring.exe", Trigger{}, []bool{true, false, false, false}},
+		{"glob", `glob:*\\game\\eldenring.exe*`, Trigger{IgnoreCase: true}, []bool{true, true, true, false}},
+		{"regex", `re:eldenring\.exe$`, Trigger{IgnoreCase: true}, []bool{true, false, true, true}},
+		{"regex flags of its own", `re:(?i)EldenRing\.exe`, Trigger{}, []bool{true, true, true, true}},
+		{"special characters quoted", "(x86)", Trigger{IgnoreCase: true}, []bool{false, false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := compileMatcher(tt.pattern, tt.trigger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, cmdline := range cmdlines {
+				if got := m.matches(cmdline, nil); got != tt.matched[i] {
+					t.Errorf("%s matches %s: %v, want %v", tt.pattern, cmdline, got, tt.matched[i])
+				}
+			}
+		})
+	}
+
+	// Names match whole, whatever the case
+	m, err := compileMatcher("EldenRing.exe", Trigger{IgnoreCase: true, Match: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"eldenring.exe": true, "ELDENRING.EXE": true, "eldenring.exe.bak": false} {
+		if got := m.matches(name, nil); got != want {
+			t.Errorf("name %s matched %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestIgnoreCaseScan(t *testing.T) {
+	pm, source := newFakeManager(t, `
+scan_interval: 1
+triggers:
+  EldenRing.exe: {pill: game, ignore_case: true}
+pills:
+  default: {scx: rusty}
+  game: {nice: 5}
+`)
+	for _, cmdline := range []string{`Z:\games\eldenring.exe`, `Z:\GAMES\ELDENRING.EXE -windowed`} {
+		source.spawn(100, 50, "eldenring.exe", cmdline)
+		expectPill(t, pm, "game", 100)
+		source.exit(100)
+		expectPill(t, pm, "default", 0)
+	}
+}
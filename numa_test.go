@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// A cgroup of the trigger in a cgroup hierarchy of the test, with the given processes and cpuset.mems
+func fakeCgroup(t *testing.T, procs string, mems string) string {
+	t.Helper()
+	previous := cgroupRoot
+	cgroupRoot = t.TempDir()
+	t.Cleanup(func() { cgroupRoot = previous })
+
+	dir := filepath.Join(cgroupRoot, "user.slice/app.slice/app-zzgame.scope")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(procs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if mems != "" {
+		if err := os.WriteFile(filepath.Join(dir, "cpuset.mems"), []byte(mems), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readMems(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "cpuset.mems"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNumaCgroup(t *testing.T) {
+	const cgroup = "/user.slice/app.slice/app-zzgame.scope"
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	source.spawn(101, 100, "zzgame", "zzgame --worker")
+	expectPill(t, pm, "game", 100)
+	pm.knownProcs[100].InTree = true
+	pm.knownProcs[101].InTree = true
+
+	// The scope of the game is bound to the node, and gets back what it had
+	dir := fakeCgroup(t, "100\n101\n", "\n")
+	pm.bindCgroupNode(cgroup, 100, 1)
+	if mems := readMems(t, dir); mems != "1" {
+		t.Fatalf("cpuset.mems %q, want 1", mems)
+	}
+	if err := pm.restoreNumaCgroup(); err != nil {
+		t.Fatal(err)
+	}
+	if mems := readMems(t, dir); mems != "\n" {
+		t.Errorf("cpuset.mems %q after the revert, want it empty", mems)
+	}
+	if pm.numaCgroup != "" || pm.numaBound {
+		t.Errorf("cgroup %q still bound after the revert", pm.numaCgroup)
+	}
+
+	// A cgroup shared with processes outside of the tree is left alone
+	dir = fakeCgroup(t, "50\n100\n101\n", "0-1\n")
+	pm.bindCgroupNode(cgroup, 100, 1)
+	if mems := readMems(t, dir); mems != "0-1\n" || pm.numaCgroup != "" {
+		t.Errorf("shared cgroup bound, cpuset.mems %q", mems)
+	}
+
+	// So is one without the cpuset controller
+	dir = fakeCgroup(t, "100\n101\n", "")
+	pm.bindCgroupNode(cgroup, 100, 1)
+	if _, err := os.Stat(filepath.Join(dir, "cpuset.mems")); !os.IsNotExist(err) || pm.numaCgroup != "" {
+		t.Errorf("cgroup without cpuset controller bound: %v", err)
+	}
+
+	// The scope removed with the game needs no revert
+	dir = fakeCgroup(t, "100\n101\n", "0\n")
+	pm.bindCgroupNode(cgroup, 100, 1)
+	os.RemoveAll(dir)
+	if err := pm.restoreNumaCgroup(); !processGone(err) {
+		t.Errorf("restoring a removed cgroup: %v", err)
+	}
+}
+
+func TestNumaPageNodes(t *testing.T) {
+	nodes, err := numaPageNodes(int32(os.Getpid()))
+	if os.IsNotExist(err) {
+		t.Skip("kernel without NUMA support")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) == 0 {
+		t.Fatal("the test process has pages on no node")
+	}
+	online, err := onlineNumaNodes()
+	if err != nil {
+		t.Skip("no online NUMA nodes")
+	}
+	for _, node := range nodes {
+		if !slices.Contains(online, node) {
+			t.Errorf("pages on node %d, online nodes %v", node, online)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "os"
+
+// Depth of the ancestry walked to find the daemon above a process
+const maxOwnDepth = 32
+
+// PID of the daemon, the processes it started are its descendants
+var selfPid = int32(os.Getpid())
+
+// Whether a process is the daemon or one it started, like the focus helper and its children.
+// Matching them as triggers or renicing them would loop, like a helper whose command line holds
+// the name of a game. Found once, they are blacklisted. Only checked for the processes about
+// to trigger a pill or join the tree, as walking the ancestry of every process costs.
+func (pm *PillManager) ownProcess(p Proc, procInfo *ProcessInfo) bool {
+	if procInfo.ownChecked {
+		return false
+	}
+	procInfo.ownChecked = true
+
+	// The PIDs of snapshots have nothing to do with the daemon's
+	if _, live := pm.source.(liveSource); !live {
+		return false
+	}
+
+	current := p
+	for range maxOwnDepth {
+		if current.PID() == selfPid {
+			procInfo.blacklisted = true
+			return true
+		}
+		if current.PID() <= 1 {
+			return false
+		}
+		// Ancestors already checked are not the daemon's
+		if info, known := pm.knownProcs[current.PID()]; known && current.PID() != p.PID() && info.ownChecked && !info.blacklisted {
+			return false
+		}
+
+		parent, err := current.Parent()
+		if err != nil {
+			return false
+		}
+		current = parent
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// The live scans see every process, the name of the game is only in the command line of the hook
+var hookGame = fmt.Sprintf("zzhook-%d", selfPid)
+
+var hookConfig = fmt.Sprintf(`
+scan_interval: 1
+triggers:
+  %s: game
+pills:
+  default: {scx: rusty}
+  game: {nice: 5}
+`, hookGame)
+
+// A hook whose command line holds the name of a game would eat the pill that runs it again
+func TestOwnProcessDoesNotTrigger(t *testing.T) {
+	// Any other process with that command line triggers the pill
+	pm, source := newFakeManager(t, hookConfig)
+	source.spawn(100, 50, "sleep", hookGame+" 30")
+	expectPill(t, pm, "game", 100)
+
+	// The same, started by the daemon
+	hook := exec.Command("/bin/sleep", "30")
+	hook.Args[0] = hookGame
+	if err := hook.Start(); err != nil {
+		t.Skipf("can't start the hook: %v", err)
+	}
+	t.Cleanup(func() {
+		hook.Process.Kill()
+		hook.Wait()
+	})
+	pid := int32(hook.Process.Pid)
+
+	pm, _ = newFakeManager(t, hookConfig)
+	pm.source = liveSource{}
+	logs := observeLogs(t, zapcore.DebugLevel)
+	for range 3 {
+		expectPill(t, pm, "default", 0)
+	}
+	if procInfo := pm.knownProcs[pid]; procInfo == nil || !procInfo.blacklisted {
+		t.Errorf("hook %d not blacklisted: %+v", pid, procInfo)
+	}
+	if logs.FilterMessageSnippet("matches trigger "+hookGame+" but was started by process_pillz").Len() != 1 {
+		t.Errorf("the ignored match isn't logged once: %v", logs.All())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Interval between the full scans while a scan pauser runs, when not configured
+const defaultPausedScanInterval = 30 * time.Second
+
+// Returns the name of a running scan pauser, like a package manager, if any.
+// Only the process names are read, the pausers usually run as root.
+func (pm *PillManager) findPauser(processes []Proc) string {
+	for _, p := range processes {
+		var name string
+		if procInfo, cached := pm.knownProcs[p.PID()]; cached {
+			name = procInfo.Name
+		} else if pName, err := p.Name(); err == nil {
+			name = pName
+		}
+
+		if slices.Contains(pm.pausers, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Whether the scan is skipped, as a scan pauser churns through processes.
+// The full scan still runs every paused_scan_interval, and right away once the pausers exited.
+func (pm *PillManager) scanPaused(processes []Proc) bool {
+	if len(pm.pausers) == 0 {
+		return false
+	}
+
+	pauser := pm.findPauser(processes)
+	if pauser == "" {
+		if pm.pausedBy != "" {
+			Logger.Infof("%s exited, resuming the scans", pm.pausedBy)
+			pm.pausedBy = ""
+		}
+		return false
+	}
+
+	if pm.pausedBy == "" {
+		Logger.Infof("%s is running, scanning every %s until it exits", pauser, pm.pausedScan)
+		pm.pausedAt = time.Now()
+	}
+	pm.pausedBy = pauser
+
+	if time.Since(pm.pausedAt) < pm.pausedScan {
+		return true
+	}
+	pm.pausedAt = time.Now()
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import "time"
+
+// Time-based state of the current pill and triggers. It belongs to one generation of the config,
+// and is replaced as a whole on reload, so that nothing set up by a previous config can act later.
+type pendingState struct {
+	generation    int               // Config generation the state belongs to
+	pill          string            // Pill the limits belong to
+	idleSince     time.Time         // Since when the trigger process has been idle
+	maxDuration   time.Duration     // Maximum duration of the pill
+	idleRevert    time.Duration     // Idle duration after which the pill is reverted
+	idleThreshold float64           // CPU percentage under which the trigger is idle
+	exhausted     map[int32]float64 // Trigger processes that can't eat a pill, with the CPU percentage that clears them
+	winner        string            // Pill winning the scans, waiting to be stable before the switch
+	winnerSince   time.Time         // Since when the winner has won every scan
+}
+
+// CPU percentage under which a trigger process is considered idle, when not configured
+const defaultIdleThreshold = 1.0
+
+func newPendingState(generation int) *pendingState {
+	return &pendingState{
+		generation: generation,
+		exhausted:  make(map[int32]float64),
+	}
+}
+
+// Whether the pending state still applies to the current config and pill
+func (pm *PillManager) pendingValid() bool {
+	if pm.pending.generation != pm.generation || pm.pending.pill != pm.CurrentPill {
+		return false
+	}
+	_, exists := pm.Pillz[pm.pending.pill]
+	return exists
+}
+
+// Discards all the pending state, when the config changes
+func (pm *PillManager) resetPending() {
+	pm.generation++
+	pm.pending = newPendingState(pm.generation)
+}
+
+// Reads the duration limits of a pill
+func (pm *PillManager) setPillLimits(pillName string, settings Pill) {
+	ps := pm.pending
+	ps.pill = pillName
+	ps.maxDuration = settings.MaxDuration
+	ps.idleRevert = settings.RevertIdle
+	ps.idleThreshold = settings.IdleCPU
+	ps.idleSince = time.Time{}
+	ps.winner = ""
+}
+
+// Reverts the current pill if it has been active for too long, or if its trigger is idle
+func (pm *PillManager) checkPillLimits(p Proc) {
+	ps := pm.pending
+	if !pm.pendingValid() {
+		Logger.Debugf("Ignoring limits of pill %s from config generation %d", ps.pill, ps.generation)
+		return
+	}
+
+	if ps.maxDuration > 0 && time.Since(pm.pillSince) >= ps.maxDuration {
+		Logger.Infof("Pill %s reached its maximum duration of %s", pm.CurrentPill, ps.maxDuration)
+		pm.exhaustTrigger(p.PID(), 0, reasonMaxDuration)
+		return
+	}
+
+	if ps.idleRevert <= 0 {
+		return
+	}
+
+	procInfo, exists := pm.knownProcs[p.PID()]
+	if !exists {
+		return
+	}
+
+	percent, ok := pm.cpuPercent(p, procInfo)
+	if !ok {
+		return
+	}
+
+	if percent >= ps.idleThreshold {
+		ps.idleSince = time.Time{}
+		return
+	}
+
+	if ps.idleSince.IsZero() {
+		ps.idleSince = time.Now()
+		Logger.Debugf("Trigger process %d is idle (%.1f%% CPU)", p.PID(), percent)
+	}
+
+	if time.Since(ps.idleSince) >= ps.idleRevert {
+		Logger.Infof("Trigger process %d has been idle for %s", p.PID(), ps.idleRevert)
+		pm.exhaustTrigger(p.PID(), ps.idleThreshold, reasonIdle)
+	}
+}
+
+// Reverts to default and prevents a trigger process from eating a pill again,
+// until its CPU usage reaches the threshold. A threshold of 0 never clears it.
+func (pm *PillManager) exhaustTrigger(pid int32, threshold float64, reason string) {
+	pm.pending.exhausted[pid] = threshold
+	pm.eatPill(nil, pm.defaultPill, reason)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const graceConfig = `
+scan_interval: 1
+transitions:
+  - {from: default, to: %[1]s, min_stable: 30s}
+triggers:
+  zzgame: %[1]s
+pills:
+  default: {scx: rusty}
+  %[1]s: {nice: 5}
+`
+
+// A switch waiting for min_stable when the config is reloaded waits again under the new config,
+// it never fires for a pill of the old one
+func TestReloadMidGracePeriod(t *testing.T) {
+	for _, next := range []string{"game", "stream"} {
+		t.Run(next, func(t *testing.T) {
+			pm, source := newFakeManager(t, fmt.Sprintf(graceConfig, "game"))
+			source.spawn(100, 50, "zzgame", "zzgame")
+			expectPill(t, pm, "default", 0)
+			if pm.pending.winner != "game" {
+				t.Fatalf("winner %q, want game waiting for min_stable", pm.pending.winner)
+			}
+
+			// The grace period of the old config is almost over
+			pm.pending.winnerSince = time.Now().Add(-29 * time.Second)
+			generation := pm.pending.generation
+
+			cfg, err := parseTestConfig(t, fmt.Sprintf(graceConfig, next))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pm.reload(*cfg)
+			if pm.pending.generation == generation || pm.pending.winner != "" {
+				t.Fatalf("pending state of generation %d kept after the reload: %+v", generation, pm.pending)
+			}
+
+			// Past the min_stable of the old winner, which must not switch
+			time.Sleep(time.Second)
+			expectPill(t, pm, "default", 0)
+			if pm.pending.winner != next || time.Since(pm.pending.winnerSince) > 5*time.Second {
+				t.Errorf("winner %q since %s, want %s waiting from the reload", pm.pending.winner, pm.pending.winnerSince, next)
+			}
+		})
+	}
+}
+
+const intervalConfig = `
+scan_interval: %s
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {nice: 5, max_duration: 10s}
+`
+
+// A reload changing scan_interval resets the ticker, and the limits of the pills keep counting
+// wall-clock time, whatever the cadence of the scans
+func TestReloadScanInterval(t *testing.T) {
+	pm, source := newFakeManager(t, fmt.Sprintf(intervalConfig, "1s"))
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+
+	cfg, err := parseTestConfig(t, fmt.Sprintf(intervalConfig, "150ms"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := observeLogs(t, zapcore.InfoLevel)
+	pm.reload(*cfg)
+	if pm.scanInterval != 150*time.Millisecond || logs.FilterMessage("Scanning every 150ms instead of 1s").Len() != 1 {
+		t.Errorf("interval %s after the reload, logs %v", pm.scanInterval, logs.All())
+	}
+
+	// The ticker was stopped by the test, the reload starts it on the new cadence
+	select {
+	case <-pm.ticker.C:
+	case <-time.After(time.Second):
+		t.Fatal("the ticker didn't tick on the new interval")
+	}
+	pm.ticker.Stop()
+
+	// The trigger eats its pill again, max_duration counts from there
+	expectPill(t, pm, "game", 100)
+	pm.pillSince = pm.pillSince.Add(-9 * time.Second)
+	expectPill(t, pm, "game", 100)
+	pm.pillSince = pm.pillSince.Add(-time.Second)
+	expectPill(t, pm, "default", 0)
+	if pm.lastEvent.Reason != reasonMaxDuration {
+		t.Errorf("transition %v, want a revert for max_duration", pm.lastEvent)
+	}
+
+	// The same interval keeps the ticker as it is
+	logs.TakeAll()
+	pm.reload(*cfg)
+	if logs.FilterMessageSnippet("Scanning every").Len() != 0 {
+		t.Errorf("interval change logged without a change: %v", logs.All())
+	}
+}
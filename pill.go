@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options a pill can set. The hardware conditions are removed when the config loads.
+var pillOptions = []string{
+	"scx", "tuned", "ppd", "irq_affinity", "rlimits", "gamescope",
+	"nice", "nice_match", "enforce_nice", "timer_slack", "numa_node", "mangohud", "renice_budget", "target",
+	"max_duration", "revert_if_idle", "idle_cpu_percent", "scope", "ignore_guards", "min_cpus", "requires", "extends",
+}
+
+// Options needing the trigger process, its tree, or deciding how long the pill lasts. The default pill has no trigger.
+var triggerOptions = []string{"rlimits", "gamescope", "nice", "nice_match", "enforce_nice", "timer_slack", "numa_node", "mangohud", "renice_budget", "target", "max_duration", "revert_if_idle", "idle_cpu_percent", "ignore_guards"}
+
+// A pill, written in the config as a flat mapping of options, like game: {tuned: gaming, nice: -5}.
+// The options are checked and parsed when the config loads, so that a typo or a value out of range
+// stops the daemon from starting instead of failing mid-game.
+type Pill struct {
+	// Global actions, with the values given to the backends
+	Scx         string
+	Tuned       string
+	Ppd         string
+	IrqAffinity string
+	Rlimits     string // Set on the trigger process
+	Gamescope   string // Set on the gamescope display of the trigger
+
+	// Per-process options, for the tree of the trigger
+	Nice         *int // Nice value, or delta from each process's own
+	NiceDelta    bool
+	NiceTree     bool // Whether nice applies to the tree of the trigger
+	NiceName     bool // Whether nice applies to the processes named like the trigger
+	EnforceNice  bool
+	TimerSlack   time.Duration
+	NumaNode     *int
+	MangoHud     bool // Whether the MangoHud overlay of the processes of the tree is toggled
+	ReniceBudget int
+	GPUOnly      bool // Only the processes of the tree using the GPU get the per-process options
+
+	// Limits and behaviour of the pill
+	MaxDuration  time.Duration
+	RevertIdle   time.Duration
+	IdleCPU      float64 // CPU percentage under which the trigger is idle
+	Scope        string
+	IgnoreGuards bool
+
+	options map[string]string // Options as written, for the checks looking at their names
+}
+
+// Decodes the flat mapping of options, parsed later by validateConfig
+func (p *Pill) UnmarshalYAML(value *yaml.Node) error {
+	return value.Decode(&p.options)
+}
+
+// A global action of a pill, named after its option
+type pillAction struct {
+	name  string
+	value string
+}
+
+// Global actions of the pills, in the order a pill applies them
+var globalActions = []string{"scx", "tuned", "ppd", "irq_affinity", "rlimits", "gamescope"}
+
+// Value of a global action of the pill, empty when it doesn't set it
+func (p Pill) action(name string) string {
+	switch name {
+	case "scx":
+		return p.Scx
+	case "tuned":
+		return p.Tuned
+	case "ppd":
+		return p.Ppd
+	case "irq_affinity":
+		return p.IrqAffinity
+	case "rlimits":
+		return p.Rlimits
+	case "gamescope":
+		return p.Gamescope
+	}
+	return ""
+}
+
+// Global actions set by the pill, in the order they are applied
+func (p Pill) actions() []pillAction {
+	var actions []pillAction
+	for _, name := range globalActions {
+		if value := p.action(name); value != "" {
+			actions = append(actions, pillAction{name, value})
+		}
+	}
+	return actions
+}
+
+// Whether the pill sets an option
+func (p Pill) has(option string) bool {
+	_, exists := p.options[option]
+	return exists
+}
+
+// Checks the options of a pill and fills its fields from them
+func (p *Pill) parse(pillName string, isDefault bool) error {
+	if len(p.options) == 0 {
+		return fmt.Errorf("pill configuration for '%s' cannot be empty", pillName)
+	}
+
+	for _, key := range sortedKeys(p.options) {
+		value := p.options[key]
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("configuration key in pill '%s' cannot be empty", pillName)
+		}
+		if !slices.Contains(pillOptions, key) {
+			return fmt.Errorf("unknown option '%s' in pill '%s', valid options are: %s", key, pillName, strings.Join(pillOptions, ", "))
+		}
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("configuration value for key '%s' in pill '%s' cannot be empty", key, pillName)
+		}
+		if isDefault && slices.Contains(triggerOptions, key) {
+			return fmt.Errorf("%s cannot be used in the default pill '%s', it has no trigger", key, pillName)
+		}
+	}
+
+	*p = Pill{options: p.options, NiceTree: true, ReniceBudget: defaultReniceBudget, IdleCPU: defaultIdleThreshold}
+	options := p.options
+
+	if value, ok := options["scx"]; ok {
+		if _, _, err := parseScx(value); err != nil {
+			return fmt.Errorf("scx in pill '%s': %v", pillName, err)
+		}
+		p.Scx = value
+	}
+	p.Tuned = options["tuned"]
+	p.Ppd = options["ppd"]
+	if value, ok := options["irq_affinity"]; ok {
+		if _, err := parseIrqAffinity(value); err != nil {
+			return fmt.Errorf("irq_affinity in pill '%s': %v", pillName, err)
+		}
+		p.IrqAffinity = value
+	}
+	if value, ok := options["rlimits"]; ok {
+		if _, err := parseRlimits(value); err != nil {
+			return fmt.Errorf("rlimits in pill '%s': %v", pillName, err)
+		}
+		p.Rlimits = value
+	}
+	if value, ok := options["gamescope"]; ok {
+		if _, err := parseGamescope(value); err != nil {
+			return fmt.Errorf("gamescope in pill '%s': %v", pillName, err)
+		}
+		p.Gamescope = value
+	}
+
+	if value, ok := options["nice"]; ok {
+		nice, delta, err := parseNice(value)
+		if err != nil {
+			return fmt.Errorf("nice in pill '%s': %v", pillName, err)
+		}
+		p.Nice, p.NiceDelta = &nice, delta
+	}
+	if value, ok := options["nice_match"]; ok {
+		tree, name, err := parseNiceMatch(value)
+		if err != nil {
+			return fmt.Errorf("nice_match in pill '%s': %v", pillName, err)
+		}
+		p.NiceTree, p.NiceName = tree, name
+	}
+	if value, ok := options["numa_node"]; ok {
+		node, err := strconv.Atoi(value)
+		if err != nil || node < 0 {
+			return fmt.Errorf("numa_node in pill '%s' must be a node number, got '%s'", pillName, value)
+		}
+		// Single node systems ignore the option, so that configs can be shared
+		if nodes, err := onlineNumaNodes(); err == nil && len(nodes) > 1 && !slices.Contains(nodes, node) {
+			return fmt.Errorf("numa_node %d in pill '%s' is not online (online nodes: %v)", node, pillName, nodes)
+		}
+		p.NumaNode = &node
+	}
+	if value, ok := options["renice_budget"]; ok {
+		budget, err := strconv.Atoi(value)
+		if err != nil || budget <= 0 {
+			return fmt.Errorf("renice_budget in pill '%s' must be a positive number, got '%s'", pillName, value)
+		}
+		p.ReniceBudget = budget
+	}
+	if value, ok := options["target"]; ok {
+		if !slices.Contains(pillTargets, value) {
+			return fmt.Errorf("unknown target '%s' in pill '%s', valid targets are: %s", value, pillName, strings.Join(pillTargets, ", "))
+		}
+		p.GPUOnly = value == "gpu_process"
+	}
+
+	var err error
+	if p.TimerSlack, err = durationOption(options, "timer_slack", pillName); err != nil {
+		return err
+	}
+	if p.MaxDuration, err = durationOption(options, "max_duration", pillName); err != nil {
+		return err
+	}
+	if p.RevertIdle, err = durationOption(options, "revert_if_idle", pillName); err != nil {
+		return err
+	}
+	if value, ok := options["idle_cpu_percent"]; ok {
+		percent, err := strconv.ParseFloat(value, 64)
+		if err != nil || percent <= 0 {
+			return fmt.Errorf("idle_cpu_percent in pill '%s' must be a positive number, got '%s'", pillName, value)
+		}
+		p.IdleCPU = percent
+	}
+
+	if p.EnforceNice, err = boolOption(options, "enforce_nice", pillName); err != nil {
+		return err
+	}
+	if p.MangoHud, err = boolOption(options, "mangohud", pillName); err != nil {
+		return err
+	}
+	if p.IgnoreGuards, err = boolOption(options, "ignore_guards", pillName); err != nil {
+		return err
+	}
+
+	switch p.Scope = options["scope"]; p.Scope {
+	case "", "global":
+	case "process":
+		if isDefault {
+			return fmt.Errorf("the default pill '%s' cannot be process-scoped", pillName)
+		}
+		for _, key := range sortedKeys(options) {
+			if !slices.Contains(processScopeOptions, key) {
+				return fmt.Errorf("%s cannot be used in the process-scoped pill '%s', valid options are: %s", key, pillName, strings.Join(processScopeOptions, ", "))
+			}
+		}
+	default:
+		return fmt.Errorf("unknown scope '%s' in pill '%s', valid scopes are: global, process", p.Scope, pillName)
+	}
+	return nil
+}
+
+// Replaces the extends option of the pills with the options of their base, which the pill
+// overrides. Bases can extend other pills, a chain looping back is an error.
+func resolveExtends(pills map[string]Pill) error {
+	resolved := make(map[string]bool)
+	var resolve func(pillName string, chain []string) error
+	resolve = func(pillName string, chain []string) error {
+		pill := pills[pillName]
+		base, extends := pill.options["extends"]
+		if resolved[pillName] || !extends {
+			resolved[pillName] = true
+			return nil
+		}
+
+		chain = append(chain, pillName)
+		if loop := slices.Index(chain, base); loop >= 0 {
+			return fmt.Errorf("pill '%s' extends itself: %s -> %s", base, strings.Join(chain[loop:], " -> "), base)
+		}
+		if _, exists := pills[base]; !exists {
+			return fmt.Errorf("pill '%s' extends unknown pill '%s'", pillName, base)
+		}
+		if err := resolve(base, chain); err != nil {
+			return err
+		}
+
+		options := maps.Clone(pills[base].options)
+		if options == nil {
+			options = make(map[string]string)
+		}
+		for key, value := range pill.options {
+			if key != "extends" {
+				options[key] = value
+			}
+		}
+		pill.options = options
+		pills[pillName] = pill
+		resolved[pillName] = true
+		return nil
+	}
+
+	for _, pillName := range sortedKeys(pills) {
+		if err := resolve(pillName, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a positive duration option of a pill, 0 when unset
+func durationOption(options map[string]string, key string, pillName string) (time.Duration, error) {
+	value, ok := options[key]
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s in pill '%s' must be a positive duration, got '%s'", key, pillName, value)
+	}
+	return d, nil
+}
+
+// Reads a true or false option of a pill, false when unset
+func boolOption(options map[string]string, key string, pillName string) (bool, error) {
+	value, ok := options[key]
+	if ok && value != "true" && value != "false" {
+		return false, fmt.Errorf("%s in pill '%s' must be true or false, got '%s'", key, pillName, value)
+	}
+	return value == "true", nil
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
 	"os/user"
 	"slices"
 	"strconv"
@@ -8,204 +12,833 @@ import (
 	"time"
 
 	"github.com/godbus/dbus/v5"
-	"github.com/shirou/gopsutil/v4/process"
+	"golang.org/x/sys/unix"
 )
 
 type ProcessInfo struct {
-	Name     string
-	Cmdline  string
-	Username string
-	Reniced  bool
+	Name       string
+	Cmdline    string
+	Exe        string // Resolved executable path, empty if it couldn't be read
+	Username   string
+	Reniced    bool
+	Suppressor string    // Suppressor pattern matched by the command line, if any
+	cpuTime    float64   // Total CPU time at the last sample, in seconds
+	cpuSampled time.Time // Time of the last CPU sample
+	cpuIdle    bool      // Whether the process was too idle to trigger its pill
+	created    int64     // Start time, in milliseconds since the epoch, 0 until read
+	young      bool      // Whether the process was too young to trigger its pill
+	tty        int32     // Controlling terminal, 0 for none
+	ttyRead    bool      // Whether the controlling terminal was read
+	background bool      // Whether the process was refused its pill for not running in the foreground
+	matched    string    // Trigger matched by the process, empty for none
+	environ    []string  // Environment variables, only read when a trigger matches them
+	cgroup     string    // Path of the cgroup, only read when a trigger matches it
+	matchRead  bool      // Whether the triggers were matched, the names and command lines don't change
+
+	InTree        bool  // Whether the process is part of the trigger's tree
+	OriginalNice  int   // Nice value before the process was reniced
+	EffectiveNice int   // Nice value read back after renicing, RLIMIT_NICE can clamp it
+	groupReniced  bool  // Whether the process was reniced along with its process group
+	SlackSet      bool  // Whether the timer slack of the process was changed
+	NumaMoved     bool  // Whether the memory of the process was moved to the pill's NUMA node
+	NumaNode      int   // NUMA node the memory of the process was moved to
+	NumaFrom      []int // NUMA nodes the memory of the process was on before, it goes back there
+	HudToggled    bool  // Whether the MangoHud overlay of the process was toggled
+	OriginalSlack int64 // Timer slack before it was changed, in nanoseconds
+	ppid          int32 // Parent PID, read when first needed
+	blacklisted   bool  // Whether the process is in the blacklist, it never triggers pills nor gets reniced
+	ownChecked    bool  // Whether the process was checked to be started by the daemon
 }
 
 // PillManager holds the state of the pill management system.
 type PillManager struct {
-	Triggers      map[string]string
-	Pillz         map[string]map[string]string
-	dbusConn      *dbus.Conn
-	ticker        *time.Ticker
-	scanInterval  time.Duration
-	CurrentPill   string
-	currentProc   int32
-	currentParent int32
-	userName      string                 // User running the daemon
-	blacklist     []string               // Processes that are blacklisted for renice
-	knownProcs    map[int32]*ProcessInfo // Cached process information
-	currentScan   map[int32]bool         // Reused map for tracking current scan
+	Triggers        map[string]Trigger
+	Pillz           map[string]Pill
+	defaultPill     string // Pill eaten when no trigger matches, on startup and on shutdown
+	dbusConn        *dbus.Conn
+	ticker          *time.Ticker
+	scanInterval    time.Duration
+	CurrentPill     string
+	currentProc     int32
+	currentStart    uint64 // Start time of the trigger process, telling it from a process that got its PID
+	currentParent   int32
+	userName        string                   // User whose processes are watched, the one running the daemon unless watch_user is set
+	matchers        map[string][]matcher     // Compiled patterns of the triggers
+	exclusions      map[string][]matcher     // Compiled exclusion patterns of the triggers
+	savedProcs      map[int32]savedProc      // Processes of the cache before a restart or reload, checked on their first scan
+	envTriggers     bool                     // Whether a trigger matches environment variables, the environments are only read then
+	cgroupTriggers  bool                     // Whether a trigger matches cgroup paths, the cgroups are only read then
+	triggerOrder    []string                 // Names of the triggers, by decreasing priority then name
+	cgroupPrefix    string                   // Processes outside of this cgroup are not scanned, if set
+	procHidden      bool                     // Whether /proc hides the processes of the other users
+	blacklist       []string                 // Processes that are blacklisted for renice
+	suppressors     []string                 // Processes that inhibit trigger based pills
+	suppressedBy    string                   // Suppressor currently inhibiting pills
+	pausers         []string                 // Processes during which the scans are spaced out
+	pausedBy        string                   // Scan pauser currently running
+	pausedScan      time.Duration            // Interval between the full scans while paused
+	pausedAt        time.Time                // Last full scan while paused
+	fullScan        time.Duration            // Interval between the full scans while no process starts or exits
+	fullScanAt      time.Time                // Last full scan
+	scanClock       time.Time                // Time of the last scan, to tell the suspends and clock steps
+	scanWall        time.Time                // Wall clock of the last scan
+	scanSignature   uint64                   // Hash of the PIDs of the last full scan
+	scanSettled     bool                     // Whether the last full scan left nothing to check again
+	scanSkipping    bool                     // Whether the scans are skipped, for logging it once
+	pillSince       time.Time                // When the current pill was eaten
+	generation      int                      // Incremented on each config reload
+	pending         *pendingState            // Time-based state of the current config generation
+	stats           map[string]*PillStats    // Counters of each pill
+	persistStats    bool                     // Whether the counters are saved across restarts
+	persistProcs    bool                     // Whether the process cache is saved across restarts
+	transitions     []time.Time              // Pill transitions of the last minute
+	flapThreshold   int                      // Transitions per minute above which pills are flapping
+	flapWarnedAt    time.Time                // Last time flapping was reported
+	rateLimit       int                      // Pill transitions allowed within the rate limit window
+	rateWindow      time.Duration            // Window of the rate limit
+	rateTimes       []time.Time              // Pill transitions within the rate limit window
+	rateWarnedAt    time.Time                // Last time a deferred transition was reported
+	rateDeferred    int                      // Transitions deferred by the rate limit since the start
+	hysteresis      hysteresisRules          // Time the winner must be stable for, before some transitions
+	maxTreeSize     int                      // Processes of the tree above which per-process settings are refused
+	treeRefused     bool                     // Whether the tree of the current pill was too large
+	gpuWaiting      map[int32]*treeMember    // Processes of the tree waiting to use the GPU, with target gpu_process
+	gpuChecked      time.Time                // When the waiting processes were last checked
+	gpuProbed       bool                     // Whether the GPU usage stats were looked for, for the current pill
+	hudWaiting      map[int32]*hudPending    // Processes of the tree whose MangoHud overlay isn't ready, with the mangohud option
+	hudChecked      time.Time                // Last check of the waiting processes
+	hudWarned       bool                     // Whether a MangoHud without control socket was reported, for the current pill
+	gpuStats        bool                     // Whether the GPU drivers report their usage in fdinfo
+	treeBacklog     []*treeMember            // Processes of the tree left to the next scan by renice_budget
+	focusNice       int                      // Nice delta of the focused window's tree
+	focusBoosted    map[int32]int            // Processes boosted for having the focus, with their original nice
+	tunedProfiles   []string                 // Cached TuneD profiles
+	tunedFetched    time.Time                // When the TuneD profiles were listed
+	tunedSaved      *tunedSnapshot           // TuneD profile and mode before the pills, restored by default
+	busOwners       chan *dbus.Signal        // Owner changes of the cached services' names
+	busWatched      []string                 // Buses and names of the cached services whose owner changes are watched
+	busSignals      []string                 // Buses whose signals go to busOwners
+	sessionConn     *dbus.Conn               // Session bus, for the backends configured on it
+	backendBus      map[string]string        // Bus of each backend configured off the system bus
+	rootReason      string                   // Why the current tree root was chosen
+	savedBackends   map[string]savedBackend  // Backend data saved for the next instance
+	scxCaps         *scxCapabilities         // Cached schedulers and modes of scx_loader
+	traceDecisions  bool                     // Whether the decision of each scan is logged
+	lowestNice      int                      // Lowest nice value the daemon is allowed to set
+	gamescopeSaved  map[string]string        // gamescope atoms before the current pill, empty when unset
+	gamescopeDpy    string                   // Display of the gamescope instance changed by the current pill
+	adopt           *lastTrigger             // Trigger process chosen before a restart, to pick again
+	reapplyWindow   time.Duration            // Time after a revert within which the trigger coming back resumes its pill
+	reverted        *revertedPill            // Pill reverted by its trigger exiting, for reapply_window
+	childTrigger    bool                     // Whether the trigger process is the child of a children_only launcher
+	lastPick        int32                    // Trigger process picked by the last scan with several matches
+	groups          map[string]TriggerGroup  // Groups of triggers, by name
+	triggerGroup    string                   // Group of the trigger of the current pill
+	minAvailable    uint64                   // Available memory under which pills are deferred, in bytes
+	maxLoadavg      float64                  // Load average above which pills are deferred
+	guardedPill     string                   // Pill currently deferred by the guards
+	deferredPill    string                   // Pill of the last deferred switch, replaced by a later one
+	deferredSkipped []string                 // Deferred pills replaced before they could be eaten
+	journal         *Journal                 // Global actions applied since the last default pill
+	exitPill        string                   // Pill to eat when the current trigger process exits
+	exitHeld        bool                     // Whether the current pill is an on_exit or manual pill, kept without a trigger
+	niceClamped     bool                     // Whether the clamped nice warning was logged for the current pill
+	groupEffective  int                      // Nice value read back after renicing the process group
+	treeJobs        chan treeJob             // Per-process settings waiting for the tree worker
+	treeResults     chan func()              // Results of the tree worker, to record in the process cache
+	treeQueued      int                      // Tree jobs whose result wasn't recorded yet
+	scoped          map[int32]*scopedPill    // Process-scoped pills, by trigger process
+	knownProcs      map[int32]*ProcessInfo   // Cached process information
+	currentScan     map[int32]bool           // Reused map for tracking current scan
+	ppdCookie       uint32                   // Cookie of the power-profiles-daemon hold
+	ppdHeld         bool                     // Whether a power-profiles-daemon profile is held
+	reniceGroup     int32                    // Process group reniced at once, if any
+	groupNice       int                      // Nice value of the process group before renicing
+	slackBroken     bool                     // Whether the system doesn't allow changing timer slack
+	numaNodes       []int                    // Online NUMA nodes, read when first needed
+	numaBound       bool                     // Whether binding the cgroup of the trigger to its NUMA node was tried
+	numaCgroup      string                   // Cgroup of the trigger whose cpuset.mems was changed, if any
+	numaMems        string                   // cpuset.mems of that cgroup before
+	irqSaved        map[int]string           // Affinity of the IRQs moved by the current pill
+	rlimitSaved     map[int]unix.Rlimit      // Resource limits of the trigger before the current pill
+	rlimitPid       int32                    // Trigger process whose resource limits were changed
+	lastEvent       *TransitionEvent         // Last pill transition
+	source          ProcSource               // Where the processes are read from
+	cpuClock        func() time.Time         // Time of the CPU samples, that of the snapshot when simulating
+	actionFailures  map[string]actionFailure // Consecutive permanent failures of each action
+	applied         map[string]string        // Last value each action was applied with successfully
+	appliedAt       map[string]uint64        // Rank of the last application of each action, for reverting them in reverse
+	applySeq        uint64                   // Rank of the last action applied
+	backend         actionBackend            // What the global actions change
+	shutdownDone    chan struct{}            // Closed once the revert of Shutdown finishes
+	disabledActions map[string]string        // Actions disabled after failing, with the failure class
+	dryRun          bool                     // Whether pills are only reported, not applied
 }
 
 var invalidParents = []string{"systemd", "srt-bwrap", "steam"}
 
+// Prefix of the blacklist entries matched against the command lines instead of the names
+const blacklistCmdline = "cmdline:"
+
 // Function that returns the parent process, or the process itself if the parent was unusable
-func (pm *PillManager) getValidParent(p *process.Process) int32 {
+func (pm *PillManager) getValidParent(p Proc) int32 {
+	// Children of launchers are roots, their siblings belong to the launcher
+	if pm.childTrigger {
+		pm.rootReason = "the trigger is a child of a children_only launcher"
+		return p.PID()
+	}
+
+	if root, found := pm.steamTreeRoot(p); found {
+		pm.rootReason = "Steam reaper or pressure-vessel wrapper above the trigger"
+		return root
+	}
+
 	pPar, err := p.Parent()
 	if err != nil {
-		Logger.Warnf("Couldn't find the parent of trigger process %d", p.Pid)
-		return p.Pid
+		Logger.Warnf("Couldn't find the parent of trigger process %d", p.PID())
+		pm.rootReason = "the parent of the trigger couldn't be found"
+		return p.PID()
 	}
 
 	parName, err := pPar.Name()
 	if err != nil {
-		Logger.Warnf("Couldn't find the parent name %d", p.Pid)
-		return p.Pid
+		Logger.Warnf("Couldn't find the parent name %d", p.PID())
+		pm.rootReason = "the name of the trigger's parent couldn't be read"
+		return p.PID()
 	}
 
 	if slices.Contains(invalidParents, parName) {
-		Logger.Warnf("Invalid parent name %s", parName)
-		return -1
+		// The trigger becomes the root, its siblings stay out but its children still join
+		Logger.Warnf("The parent %s of trigger process %d is shared by other programs, the tree is reduced to the trigger and its descendants", parName, p.PID())
+		pm.rootReason = fmt.Sprintf("the parent %s is shared by other programs, the trigger is the root", parName)
+		return p.PID()
 	}
 
-	return pPar.Pid
+	pm.rootReason = "parent of the trigger"
+	return pPar.PID()
 }
 
-// The object storing the state of the pill manager
+// The object storing the state of the pill manager, scanning /proc and picking up the state
+// saved by the previous instance: the counters, the last trigger and the process cache
 func NewPillManager(cfg Config) *PillManager {
+	pm := newPillManager(cfg, liveSource{})
+	pm.loadStats()
+	pm.adopt = loadLastTrigger()
+	pm.savedProcs = pm.loadProcCache()
+	return pm
+}
+
+// A pill manager scanning source, which starts over without the state of a previous instance
+func newPillManager(cfg Config, source ProcSource) *PillManager {
 	user, err := user.Current()
 	if err != nil {
 		Logger.Fatalf("Couldn't find the current user's name. %v", err)
 	}
 
-	// Setting the polling rate
-	var scanInterval time.Duration
-	if cfg.ScanInterval <= 0 {
-		Logger.Warn("Error with scan_interval value. Using 3 seconds as sane default")
-		scanInterval = 3 * time.Second
-	} else {
-		scanInterval = time.Duration(cfg.ScanInterval) * time.Second
+	// Setting the polling rate, checked by validateConfig
+	scanInterval := cfg.scanInterval
+	ticker := time.NewTicker(scanInterval)
+
+	pm := &PillManager{
+		dbusConn:        nil,
+		ticker:          ticker,
+		scanInterval:    scanInterval,
+		CurrentPill:     "",
+		currentProc:     0,
+		currentParent:   0,
+		userName:        watchedUser(cfg, user.Username),
+		knownProcs:      make(map[int32]*ProcessInfo),
+		currentScan:     make(map[int32]bool),
+		pending:         newPendingState(0),
+		irqSaved:        make(map[int]string),
+		rlimitSaved:     make(map[int]unix.Rlimit),
+		stats:           make(map[string]*PillStats),
+		source:          source,
+		cpuClock:        time.Now,
+		actionFailures:  make(map[string]actionFailure),
+		applied:         make(map[string]string),
+		appliedAt:       make(map[string]uint64),
+		disabledActions: make(map[string]string),
+		focusBoosted:    make(map[int32]int),
+		gamescopeSaved:  make(map[string]string),
+		scoped:          make(map[int32]*scopedPill),
+		gpuWaiting:      make(map[int32]*treeMember),
+		hudWaiting:      make(map[int32]*hudPending),
+		treeJobs:        make(chan treeJob, treeQueueSize),
+		treeResults:     make(chan func(), treeQueueSize),
+	}
+	pm.backend = liveBackend{pm}
+	go runTreeWorker(pm.treeJobs, pm.treeResults)
+	if pm.userName != user.Username && os.Geteuid() != 0 {
+		Logger.Warnf("Watching the processes of %s, which user %s can't renice. watch_user is meant for a system service running as root", pm.userName, user.Username)
 	}
 
-	ticker := time.NewTicker(scanInterval)
+	pm.applyConfig(cfg)
+	return pm
+}
+
+// User whose processes trigger the pills and get reniced, the daemon's unless watch_user is set
+func watchedUser(cfg Config, daemonUser string) string {
+	if cfg.WatchUser != "" {
+		return cfg.WatchUser
+	}
+	return daemonUser
+}
+
+// Sets the fields of the manager coming from the config
+func (pm *PillManager) applyConfig(cfg Config) {
+	pm.Triggers = cfg.Triggers
+	pm.matchers = compileMatchers(cfg.Triggers)
+	pm.exclusions = compileExclusions(cfg.Triggers)
+	pm.envTriggers = usesEnv(cfg.Triggers)
+	pm.cgroupTriggers = usesCgroup(cfg.Triggers)
+	pm.triggerOrder = orderTriggers(cfg.Triggers)
+	pm.groups = cfg.Groups
+	pm.Pillz = cfg.Pills
+	pm.defaultPill = cfg.defaultPillName()
+	pm.blacklist = cfg.Blacklist
+	pm.suppressors = cfg.Suppressors
+	pm.pausers = cfg.ScanPausers
+	if logBuffer != nil {
+		size := defaultLogBuffer
+		if cfg.LogBuffer != nil {
+			size = *cfg.LogBuffer
+		}
+		logBuffer.resize(size)
+	}
+	pm.pausedBy = ""
+	pm.pausedScan = defaultPausedScanInterval
+	if cfg.PausedScan > 0 {
+		pm.pausedScan = time.Duration(cfg.PausedScan) * time.Second
+	}
+	pm.fullScan = defaultFullScanInterval
+	if cfg.FullScan > 0 {
+		pm.fullScan = time.Duration(cfg.FullScan) * time.Second
+	}
+	pm.scanSettled = false
+	pm.persistStats = cfg.PersistStats
+	pm.persistProcs = cfg.PersistProcs
+
+	pm.flapThreshold = defaultFlapThreshold
+	if cfg.FlapThreshold != nil {
+		pm.flapThreshold = *cfg.FlapThreshold
+	}
+
+	pm.hysteresis = parseTransitions(cfg.Transitions)
+
+	pm.rateLimit = defaultMaxTransitions
+	if cfg.RateLimit != nil {
+		pm.rateLimit = *cfg.RateLimit
+	}
+	pm.minAvailable = 0
+	if cfg.MinMemory != "" {
+		pm.minAvailable, _ = parseSize(cfg.MinMemory)
+	}
+	pm.maxLoadavg = cfg.MaxLoadavg
+	pm.reapplyWindow, _ = time.ParseDuration(cfg.ReapplyWindow)
+	pm.reverted = nil
+	pm.cgroupPrefix = cgroupPrefix(cfg.CgroupFilter)
+	pm.backendBus = map[string]string{"tuned": cfg.TunedBus, "scx": cfg.ScxBus, "ppd": cfg.PpdBus}
+
+	pm.focusNice = 0
+	if cfg.FocusBoost != nil {
+		pm.focusNice = cfg.FocusBoost.Nice
+	}
+
+	pm.maxTreeSize = defaultMaxTreeSize
+	if cfg.MaxTreeSize != nil {
+		pm.maxTreeSize = *cfg.MaxTreeSize
+	}
+
+	pm.rateWindow = defaultTransitionWindow * time.Second
+	if cfg.RateWindow > 0 {
+		pm.rateWindow = time.Duration(cfg.RateWindow) * time.Second
+	}
+
+	// Unprivileged, nice values can be raised but not lowered, nor restored afterwards
+	pm.lowestNice = lowestAllowedNice()
+	for pillName, pill := range pm.Pillz {
+		if pill.Nice == nil {
+			continue
+		}
+		nice, delta := *pill.Nice, pill.NiceDelta
+		if delta && nice < 0 && pm.lowestNice > -20 {
+			Logger.Warnf("Pill %s sets nice %s, but process_pillz isn't allowed to lower nice values under %d without CAP_SYS_NICE or a higher RLIMIT_NICE. It stops there", pillName, pill.options["nice"], pm.lowestNice)
+		} else if !delta && nice < 0 && nice < pm.lowestNice {
+			Logger.Warnf("Pill %s sets nice %d, but process_pillz isn't allowed to lower nice values that far without CAP_SYS_NICE or a higher RLIMIT_NICE. Its nice option is ignored", pillName, nice)
+		} else if nice > 0 && pm.lowestNice > 0 {
+			Logger.Warnf("Pill %s sets nice %s, but process_pillz can't lower nice values without CAP_SYS_NICE or RLIMIT_NICE. The processes will keep it after the pill", pillName, pill.options["nice"])
+		}
+	}
+
+	// Raising hard limits can't work without the capability, better know it early
+	for pillName, pill := range pm.Pillz {
+		if pill.Rlimits != "" && !hasCapability(unix.CAP_SYS_RESOURCE) {
+			Logger.Warnf("Pill %s sets rlimits, but raising hard limits requires CAP_SYS_RESOURCE, which process_pillz lacks", pillName)
+		}
+	}
+}
+
+// Switches to a new config without restarting. Everything derived from the previous config is
+// dropped: the pill is reverted, and the cached verdicts and pending state are discarded.
+// A pill eaten with the switch command is held instead, eaten again as the new config has it.
+func (pm *PillManager) reload(cfg Config) {
+	held := pm.manualHold()
+	if held == "" {
+		pm.eatPill(nil, pm.defaultPill, reasonReload)
+	}
+	pm.restoreScoped()
+
+	// A default pill renamed by default_pill is eaten too, the system starts from it again
+	previousDefault := pm.defaultPill
+	pm.applyConfig(cfg)
+	if held == "" && pm.defaultPill != previousDefault {
+		pm.eatPill(nil, pm.defaultPill, reasonReload)
+	}
+
+	// The timers of the pills count from the next pill, reverted above, and wall-clock based:
+	// only the ticker runs on the old cadence
+	if interval := cfg.scanInterval; interval != pm.scanInterval {
+		Logger.Infof("Scanning every %s instead of %s", interval, pm.scanInterval)
+		pm.scanInterval = interval
+		pm.ticker.Reset(interval)
+	}
+	if daemonUser, err := user.Current(); err == nil {
+		if watched := watchedUser(cfg, daemonUser.Username); watched != pm.userName {
+			Logger.Infof("Watching the processes of %s instead of %s", watched, pm.userName)
+			pm.userName = watched
+		}
+	}
+
+	pm.resetPending()
+	pm.enableActions()
+	pm.tunedProfiles = nil
+	pm.scxCaps = nil
+	// The verdicts depend on the config, what was read of the processes doesn't
+	pm.savedProcs = pm.savedProcesses()
+	pm.knownProcs = make(map[int32]*ProcessInfo)
+	pm.suppressedBy = ""
+
+	// The held pill is eaten again once nothing of the previous config is left
+	if held != "" && pm.keepHold(held) {
+		Logger.Infof("Configuration reloaded (generation %d), pill %s is still held by the switch command until a trigger matches or %s is switched to", pm.generation, held, pm.defaultPill)
+		return
+	}
+	Logger.Infof("Configuration reloaded (generation %d)", pm.generation)
+}
+
+func (pm *PillManager) checkTriggerMatch(procInfo *ProcessInfo) (string, *Trigger) {
+	if !procInfo.matchRead {
+		procInfo.matchRead = true
+		for _, name := range pm.triggerOrder {
+			if pm.matchesTrigger(name, pm.Triggers[name], procInfo) {
+				procInfo.matched = name
+				break
+			}
+		}
+	}
+
+	trigger, exists := pm.Triggers[procInfo.matched]
+	if procInfo.matched == "" || !exists {
+		return "", nil
+	}
+	return procInfo.matched, &trigger
+}
+
+// Whether a process matches one of the patterns of a trigger, on its command line, executable path or name,
+// and none of its exclusions
+func (pm *PillManager) matchesTrigger(name string, trigger Trigger, procInfo *ProcessInfo) bool {
+	target, ok := trigger.target(procInfo)
+	if !ok {
+		return false
+	}
+	return pm.includes(name, target, procInfo.environ) && pm.exclusion(name, target, procInfo.environ) < 0
+}
+
+// Checks that a process matching a trigger uses enough CPU to activate it
+func (pm *PillManager) checkTriggerCPU(p Proc, procInfo *ProcessInfo, name string, trigger *Trigger) bool {
+	if trigger.MinCPUPercent <= 0 {
+		return true
+	}
+
+	percent, ok := pm.cpuPercent(p, procInfo)
+	if ok && percent >= trigger.MinCPUPercent {
+		procInfo.cpuIdle = false
+		return true
+	}
 
-	return &PillManager{
-		Triggers:      cfg.Triggers,
-		Pillz:         cfg.Pills,
-		dbusConn:      nil,
-		ticker:        ticker,
-		scanInterval:  scanInterval,
-		CurrentPill:   "",
-		currentProc:   0,
-		currentParent: 0,
-		userName:      user.Username,
-		blacklist:     cfg.Blacklist,
-		knownProcs:    make(map[int32]*ProcessInfo),
-		currentScan:   make(map[int32]bool),
+	if !procInfo.cpuIdle {
+		Logger.Debugf("Process %d matches trigger '%s' but uses %.1f%% CPU, under the %.1f%% threshold", p.PID(), name, percent, trigger.MinCPUPercent)
+		procInfo.cpuIdle = true
 	}
+	return false
 }
 
-func (pm *PillManager) checkTriggerMatch(cmd string) string {
-	for trigger, pill := range pm.Triggers {
-		if strings.Contains(cmd, trigger) {
-			return pill
+// Checks that a process matching a trigger has run for its min_runtime, so that the processes
+// launchers start for a split second don't flip the pills. Scans run every scan_interval, a
+// shorter min_runtime lets the process trigger on the first scan seeing it.
+func (pm *PillManager) checkTriggerAge(p Proc, procInfo *ProcessInfo, name string, trigger *Trigger) bool {
+	minRuntime, _ := time.ParseDuration(trigger.MinRuntime)
+	if minRuntime <= 0 {
+		return true
+	}
+
+	// The creation time is on the wall clock as of when it was read, it is read again while
+	// the process is young in case the clock stepped since
+	if procInfo.created == 0 || procInfo.young {
+		created, err := p.CreateTime()
+		if err != nil {
+			return true
+		}
+		procInfo.created = created
+	}
+
+	age := time.Since(time.UnixMilli(procInfo.created))
+	if age >= minRuntime {
+		procInfo.young = false
+		return true
+	}
+
+	if !procInfo.young {
+		Logger.Debugf("Process %d matches trigger '%s' but only runs for %s, under the min_runtime of %s", p.PID(), name, age.Round(time.Millisecond), minRuntime)
+		procInfo.young = true
+	}
+	return false
+}
+
+// Whether a process matching a trigger runs in a terminal, for the triggers requiring it.
+// The controlling terminal doesn't change and is read once, the foreground group is read on every scan.
+func (pm *PillManager) checkTriggerForeground(p Proc, procInfo *ProcessInfo, name string, trigger *Trigger) bool {
+	if !trigger.Foreground && !trigger.ForegroundPgr {
+		return true
+	}
+
+	inForeground := true
+	if !procInfo.ttyRead || trigger.ForegroundPgr {
+		tty, foreground, err := p.Terminal()
+		if err != nil {
+			return false
+		}
+		procInfo.tty = tty
+		procInfo.ttyRead = true
+		inForeground = foreground
+	}
+
+	if procInfo.tty != 0 && (!trigger.ForegroundPgr || inForeground) {
+		procInfo.background = false
+		return true
+	}
+
+	if !procInfo.background {
+		if procInfo.tty == 0 {
+			Logger.Debugf("Process %d matches trigger '%s' but has no controlling terminal", p.PID(), name)
+		} else {
+			Logger.Debugf("Process %d matches trigger '%s' but is in the background of its terminal", p.PID(), name)
+		}
+		procInfo.background = true
+	}
+	return false
+}
+
+// Whether a process is in the blacklist, by its name or, for entries starting with cmdline:,
+// by a substring of its command line
+func (pm *PillManager) checkBlacklist(name string, cmd string) bool {
+	for _, entry := range pm.blacklist {
+		if substring, isCmdline := strings.CutPrefix(entry, blacklistCmdline); isCmdline {
+			if strings.Contains(cmd, substring) {
+				return true
+			}
+		} else if entry == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (pm *PillManager) checkSuppressorMatch(cmd string) string {
+	for _, suppressor := range pm.suppressors {
+		if strings.Contains(cmd, suppressor) {
+			return suppressor
 		}
 	}
 	return ""
 }
 
+// Per-process settings of a pill, applied to the trigger's tree
+type treeSettings struct {
+	isNice      bool
+	nice        int
+	niceDelta   bool // Whether nice is added to the nice value of each process
+	niceFloor   int  // Lowest nice value a delta can reach
+	enforceNice bool // Whether nice values changed by someone else are set back
+	niceTree    bool // Whether the processes of the tree are reniced
+	niceName    bool // Whether the processes named like the trigger are reniced
+	timerSlack  time.Duration
+	isNuma      bool
+	numaNode    int
+	mangohud    bool // Whether the MangoHud overlay of the processes is toggled
+	gpuOnly     bool // Whether only the processes of the tree using the GPU get the settings
+	budget      int  // Syscalls changing the processes of the tree per scan, the others wait for the next scans
+}
+
+// Parses a nice_match value, a space separated list of "tree" and "trigger_name"
+func parseNiceMatch(value string) (tree bool, name bool, err error) {
+	for _, mode := range strings.Fields(value) {
+		switch mode {
+		case "tree":
+			tree = true
+		case "trigger_name":
+			name = true
+		default:
+			return false, false, fmt.Errorf("unknown nice_match mode '%s', valid modes are: tree, trigger_name", mode)
+		}
+	}
+
+	if !tree && !name {
+		return false, false, fmt.Errorf("nice_match cannot be empty")
+	}
+	return tree, name, nil
+}
+
+// Parses a nice value. A signed value like "+5" or "-5" is a delta from the nice value of
+// each process, others are absolute. An "=" prefix makes a negative value absolute, as in "=-10".
+func parseNice(value string) (nice int, delta bool, err error) {
+	absolute, isAbsolute := strings.CutPrefix(value, "=")
+	delta = !isAbsolute && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-"))
+
+	nice, err = strconv.Atoi(absolute)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid nice value '%s'", value)
+	}
+	if delta && (nice < -39 || nice > 39) {
+		return 0, false, fmt.Errorf("nice delta must be between -39 and +39, got %s", value)
+	}
+	if !delta && (nice < -20 || nice > 20) {
+		return 0, false, fmt.Errorf("nice must be between -20 and 20, got %s", value)
+	}
+	return nice, delta, nil
+}
+
+// Nice value for a process whose nice value was original before the pill
+func (t treeSettings) niceFor(original int) int {
+	if !t.niceDelta {
+		return t.nice
+	}
+
+	// Lowering stops where process_pillz is allowed to go, without raising processes already lower
+	target := original + t.nice
+	if t.nice < 0 {
+		target = max(target, min(t.niceFloor, original))
+	}
+	return min(max(target, -20), 19)
+}
+
+func (t treeSettings) any() bool {
+	return t.isNice || t.timerSlack > 0 || t.isNuma || t.mangohud
+}
+
+// Per-process settings of a pill, from its options parsed when the config loaded
+func (pm *PillManager) getTreeSettings(pillName string) treeSettings {
+	var tree treeSettings
+	if pillName == pm.defaultPill {
+		return tree
+	}
+
+	pill := pm.Pillz[pillName]
+
+	// Nice values process_pillz isn't allowed to reach are ignored, deltas stop at the floor
+	if nice := pill.Nice; nice != nil && (*nice >= 0 || *nice >= pm.lowestNice || (pill.NiceDelta && pm.lowestNice < 20)) {
+		tree.isNice, tree.nice, tree.niceDelta = true, *nice, pill.NiceDelta
+		tree.niceFloor = max(pm.lowestNice, -20)
+	}
+
+	tree.enforceNice = pill.EnforceNice
+	tree.gpuOnly = pill.GPUOnly
+	tree.budget = pill.ReniceBudget
+	tree.niceTree, tree.niceName = pill.NiceTree, pill.NiceName
+	tree.timerSlack = pill.TimerSlack
+	tree.mangohud = pill.MangoHud
+	if pill.NumaNode != nil {
+		tree.isNuma, tree.numaNode = true, *pill.NumaNode
+	}
+
+	return tree
+}
+
+// Looks up what is left of the trigger process, for the decision of the scan
+func (pm *PillManager) triggerState() (scanState, Proc) {
+	state := scanState{defaultPill: pm.defaultPill, currentPill: pm.CurrentPill, currentProc: pm.currentProc, triggerStart: pm.currentStart}
+	if pm.currentProc == 0 {
+		return state, nil
+	}
+	current, err := pm.source.Process(pm.currentProc)
+	if err != nil {
+		return state, nil
+	}
+	start, zombie, err := current.Lifetime()
+	if err != nil {
+		return state, nil // Gone while it was read
+	}
+	state.currentFound, state.currentStart, state.currentZombie = true, start, zombie
+	return state, current
+}
+
 // Look for a process matching one in the triggers list
 func (pm *PillManager) scanProcesses() {
+	// What the tree worker did since the last scan goes to the process cache first
+	pm.flushTree()
+
+	// A suspend or a clock step since the last scan makes it a full one
+	pm.checkClock()
+	if pm.scanUnchanged() {
+		return
+	}
+	pm.scanSkipping = false
+
 	// Fetching all the currently running processes
-	processes, err := process.Processes()
+	processes, err := pm.source.Processes()
 	if err != nil {
 		Logger.Errorf("Couldn't get running processes: %v", err)
 		return
 	}
+	pm.checkProcVisibility()
+	if pm.scanPaused(processes) {
+		return
+	}
 
-	var shouldKeepCurrentPill bool
-	var newPillToSwitch string
-	var triggerProcess *process.Process
+	var triggerProcess Proc
+	var suppressor string
+	var exitPill string
 
-	current, err := process.NewProcess(pm.currentProc)
-	if err != nil {
-		shouldKeepCurrentPill = false
-	} else {
-		shouldKeepCurrentPill = true
+	state, current := pm.triggerState()
+	if state.triggerRunning() {
 		triggerProcess = current
+	} else if state.currentFound {
+		// The cache still describes the trigger, not the process that got its PID
+		delete(pm.knownProcs, pm.currentProc)
 	}
+	trace := pm.newTrace(state.triggerRunning())
 
 	// initialise global variables out of the loop
-	curPill := pm.Pillz[pm.CurrentPill]
-
-	// Getting nice config, and checking if a valid nice value is provided in the pill's config
-	niceStr, isNice := curPill["nice"]
+	tree := pm.getTreeSettings(pm.CurrentPill)
+	var newMembers []*treeMember
+	var candidates []triggerCandidate
+	armed := make(map[int32]string) // Launchers whose children trigger their pill, with their trigger
+	scopedMatches := make(map[int32]string)
+	settled := true // Whether the scan is left with nothing to check again while the processes stay the same
 
 	// Clear and reuse the currentScan map
 	for k := range pm.currentScan {
 		delete(pm.currentScan, k)
 	}
 
-	var nice int
-
-	if isNice && pm.CurrentPill != "default" {
-		nice, err = strconv.Atoi(niceStr)
-		if err != nil || nice < -20 || nice > 20 {
-			Logger.Errorf("Invalid nice value in config: %s", niceStr)
-			isNice = false
-		}
-	} else {
-		isNice = false
-	}
+	// Processes the cache doesn't know yet are read at once, bursts in parallel
+	fresh := pm.readNewProcesses(processes)
 
 	// Run through the list of processes
 	for _, p := range processes {
 		// If the process has already been tested, use cached info
-		procInfo, exists := pm.knownProcs[p.Pid]
+		procInfo, exists := pm.knownProcs[p.PID()]
 		if !exists {
-			pUser, err := p.Username()
-			if err != nil {
-				Logger.Warn("Can't get the user of a process : %v", err)
+			if procInfo = fresh[p.PID()]; procInfo == nil {
 				continue
 			}
+			pm.knownProcs[p.PID()] = procInfo
+		}
+		// Store this process' PID in the list of processes seen during this scan
+		pm.currentScan[p.PID()] = true
 
-			// Do not deal with non user processes
-			if pUser != pm.userName {
-				continue
-			}
+		if suppressor == "" {
+			suppressor = procInfo.Suppressor
+		}
 
-			pCmd, err := p.Cmdline()
-			if err != nil {
-				Logger.Warnf("Could not get command line of process %d", p.Pid)
+		// Exhausted processes are cleared when they get busy again
+		if threshold, isExhausted := pm.pending.exhausted[p.PID()]; isExhausted && threshold > 0 {
+			if percent, ok := pm.cpuPercent(p, procInfo); ok && percent >= threshold {
+				Logger.Infof("Process %d is busy again (%.1f%% CPU), it can trigger pills", p.PID(), percent)
+				delete(pm.pending.exhausted, p.PID())
 			}
+		}
 
-			pName, err := p.Name()
-			if err != nil {
-				Logger.Warnf("Could not get name of process %d", p.Pid)
-				pName = "unknown"
+		// Every match of the scan is collected, the decision is taken once they are all known,
+		// so that it doesn't depend on the order of the processes
+		if _, isExhausted := pm.pending.exhausted[p.PID()]; !isExhausted && !procInfo.blacklisted {
+			triggerName, trigger := pm.checkTriggerMatch(procInfo)
+			if trigger != nil && pm.ownProcess(p, procInfo) {
+				Logger.Debugf("%s (PID %d) matches trigger %s but was started by process_pillz, ignoring it", procInfo.Name, p.PID(), triggerName)
+				trigger = nil
 			}
-
-			// Create a new ProcessInfo and add it to the knownProcs map
-			pm.knownProcs[p.Pid] = &ProcessInfo{
-				Name:     pName,
-				Cmdline:  pCmd,
-				Username: pUser,
-				Reniced:  false,
+			// Refused for now, the next scans check them again
+			if trigger != nil && !pm.checkTriggerForeground(p, procInfo, triggerName, trigger) {
+				trigger, settled = nil, false
 			}
-			procInfo = pm.knownProcs[p.Pid]
-		}
-		// Store this process' PID in the list of processes seen during this scan
-		pm.currentScan[p.Pid] = true
-
-		if !shouldKeepCurrentPill {
-			// Check if this cached process matches a trigger
-			pillName := pm.checkTriggerMatch(procInfo.Cmdline)
-			if pillName != "" {
-				if pillName == pm.CurrentPill {
-					shouldKeepCurrentPill = true
-					triggerProcess = p
+			// The children of launchers are checked instead, they are the ones triggering the pill
+			if trigger != nil && !trigger.ChildrenOnly && !pm.checkTriggerAge(p, procInfo, triggerName, trigger) {
+				trigger, settled = nil, false
+			}
+			if trigger != nil && pm.isProcessScoped(trigger.Pill) {
+				scopedMatches[p.PID()] = trigger.Pill
+			} else if trigger != nil && pm.checkTriggerCPU(p, procInfo, triggerName, trigger) {
+				pillName := trigger.Pill
+				trace.match(p.PID(), triggerName, pillName)
+				// Check if there is a pill with that name
+				if _, pillExists := pm.Pillz[pillName]; !pillExists && pillName != pm.CurrentPill {
+					Logger.Errorf("No pill named '%s'", pillName)
+				} else if trigger.ChildrenOnly {
+					armed[p.PID()] = triggerName
 				} else {
-					// Check if there is a pill with that name
-					if _, pillExists := pm.Pillz[pillName]; pillExists {
-						newPillToSwitch = pillName
-						triggerProcess = p
-					} else {
-						Logger.Errorf("No pill named '%s'", pillName)
-					}
+					candidates = append(candidates, triggerCandidate{p: p, pill: pillName, onExit: trigger.OnExit, group: trigger.Group, trigger: triggerName, priority: trigger.Priority})
 				}
+			} else if trigger != nil {
+				settled = false
 			}
 		}
 
-		// Do renice check if needed
-		if isNice && !procInfo.Reniced {
-			pm.reniceCheck(p, nice)
+		// Do tree check if needed
+		if tree.any() && !procInfo.InTree && !pm.dryRun {
+			if member := pm.treeCheck(p, tree); member != nil {
+				newMembers = append(newMembers, member)
+			}
+		}
+	}
+
+	pm.applyTree(newMembers, tree)
+	// Children listed before a parent that just joined, like after the PIDs wrapped around, join on the next scan
+	if len(newMembers) > 0 {
+		settled = false
+	}
+	if tree.mangohud && !pm.dryRun {
+		pm.updateHud(newMembers)
+	}
+	if tree.enforceNice && !pm.dryRun {
+		pm.enforceNice()
+	}
+
+	// Picking the trigger process deterministically among the matches. The current trigger
+	// is kept while it runs, unless a group with a higher priority has a match.
+	candidates = append(candidates, pm.launcherChildren(processes, armed)...)
+	// Exited processes waiting to be reaped still match, they don't trigger pills
+	candidates = slices.DeleteFunc(candidates, func(c triggerCandidate) bool {
+		_, zombie, _ := c.p.Lifetime()
+		return zombie
+	})
+	if len(pm.groups) > 0 {
+		var others map[int32]string
+		candidates, others = pm.splitGroups(candidates)
+		maps.Copy(scopedMatches, others)
+		if state.triggerRunning() && len(candidates) > 0 && pm.groupPriority(candidates[0].group) > pm.groupPriority(pm.triggerGroup) {
+			Logger.Infof("Group %s takes the pill over from group %s", groupName(candidates[0].group), groupName(pm.triggerGroup))
+			state.takenOver = true
+		}
+	}
+	if !state.keepsTrigger() {
+		if best := pm.pickTrigger(candidates); best != nil {
+			triggerProcess = best.p
+			exitPill = best.onExit
+			pm.childTrigger = best.launcher != 0
+			pm.triggerGroup = best.group
+			pm.leaveGroupScope(best.p.PID())
+			state.picked, state.pickedPid = best.pill, best.p.PID()
 		}
 	}
 
@@ -214,78 +847,299 @@ func (pm *PillManager) scanProcesses() {
 		_, exists := pm.currentScan[pid]
 		if !exists {
 			delete(pm.knownProcs, pid)
+			delete(pm.pending.exhausted, pid)
+		}
+	}
+
+	// Suppressors gate the whole trigger mechanism
+	if suppressor != "" {
+		if pm.suppressedBy == "" {
+			Logger.Infof("Pills suppressed while '%s' is running", suppressor)
+		}
+		pm.suppressedBy = suppressor
+
+		pm.logTrace(trace, "suppressed")
+		pm.scanSettled = settled
+		if pm.CurrentPill != pm.defaultPill {
+			pm.eatPill(nil, pm.defaultPill, reasonSuppressed)
 		}
+		pm.restoreScoped()
+		return
+	}
+
+	if pm.suppressedBy != "" {
+		Logger.Infof("Suppressor '%s' is gone, pills are allowed again", pm.suppressedBy)
+		pm.suppressedBy = ""
 	}
 
+	pm.updateScoped(processes, scopedMatches)
+
+	// A new winner has to win consecutive scans to be stable
+	lastWinner := pm.pending.winner
+	pm.pending.winner = ""
+
 	// Trigger and pills logic
-	if !shouldKeepCurrentPill && pm.CurrentPill != "default" {
-		pm.eatPill(nil, "default")
+	state.exitPill, state.exitHeld = pm.exitPill, pm.exitHeld
+	state.transitions, state.winner, state.wonFor = pm.hysteresis, lastWinner, pm.wonFor(lastWinner)
+	outcome, pillName := decideScan(state)
+	if outcome == outcomeHold || outcome == outcomeEatExitPill || outcome == outcomeRevert || outcome == outcomeSwitch {
+		pm.trackWinner(pillName, lastWinner)
+	}
+
+	switch outcome {
+	case outcomeHold:
+		pm.logTrace(trace, "hold "+pm.CurrentPill+", "+pm.holdReason(pillName))
+
+	case outcomeKeepHeld:
+		pm.dropDeferred()
+		pm.logTrace(trace, "keep the on_exit or manual pill, no trigger is running")
+		pm.scanSettled = settled
+
+	case outcomeEatExitPill:
+		pm.logTrace(trace, "trigger exited, eat its on_exit pill "+pillName)
+		pm.eatPill(nil, pillName, reasonOnExit)
+
+	case outcomeRevert:
+		pm.logTrace(trace, "revert, no trigger of the current pill is running")
+		pm.eatPill(nil, pillName, reasonTriggerGone)
+
+	case outcomeSwitch:
+		if guard := pm.guardTripped(pillName); guard != "" {
+			if pm.guardedPill != pillName {
+				Logger.Infof("Deferring pill %s: %s", pillName, guard)
+				pm.guardedPill = pillName
+			}
+			pm.deferSwitch(pillName)
+			pm.logTrace(trace, "defer "+pillName+", "+guard)
+			return
+		}
+		pm.guardedPill = ""
 
-	} else if newPillToSwitch != "" && newPillToSwitch != pm.CurrentPill {
-		pm.eatPill(triggerProcess, newPillToSwitch)
+		pm.logTrace(trace, "switch to "+pillName)
+		pm.eatPill(triggerProcess, pillName, reasonTrigger)
+		if pm.CurrentPill == pillName {
+			pm.exitPill = exitPill
+		}
 
-	} else if shouldKeepCurrentPill && triggerProcess.Pid != pm.currentProc {
-		pm.currentProc = triggerProcess.Pid
+	case outcomeChangeTrigger:
+		pm.dropDeferred()
+		pm.logTrace(trace, "keep, with another trigger process")
+		pm.exitPill, pm.exitHeld = exitPill, false
+		pm.currentProc = triggerProcess.PID()
+		pm.currentStart, _, _ = triggerProcess.Lifetime()
 		pm.currentParent = pm.getValidParent(triggerProcess)
+		pm.saveLastTrigger(triggerProcess)
 		Logger.Infof("Changed trigger process to %d with parent %d", pm.currentProc, pm.currentParent)
+
+	case outcomeKeep:
+		pm.dropDeferred()
+		pm.logTrace(trace, "keep, the trigger process is still running")
+		pm.checkTreeRoot(triggerProcess)
+		pm.checkPillLimits(triggerProcess)
+		pm.scanSettled = settled
+
+	case outcomeStay:
+		pm.dropDeferred()
+		pm.logTrace(trace, "stay on default, no trigger is running")
+		pm.scanSettled = settled
+	}
+}
+
+// Returns the CPU usage of a process since its last sample, in percent of one CPU.
+// The first sample of a process doesn't give any usage.
+func (pm *PillManager) cpuPercent(p Proc, procInfo *ProcessInfo) (float64, bool) {
+	total, err := p.CPUTime()
+	if err != nil {
+		return 0, false
+	}
+
+	now := pm.cpuClock()
+	prevTotal, prevSampled := procInfo.cpuTime, procInfo.cpuSampled
+	procInfo.cpuTime, procInfo.cpuSampled = total, now
+
+	if prevSampled.IsZero() {
+		return 0, false
 	}
+
+	elapsed := now.Sub(prevSampled).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	return (total - prevTotal) / elapsed * 100, true
 }
 
 // Apply a profile
-func (pm *PillManager) eatPill(p *process.Process, pillName string) {
-	Logger.Infof("\033[1m[Eating %s pill]\033[0m", pillName)
+func (pm *PillManager) eatPill(p Proc, pillName string, reason string) {
+	// Whatever the outcome, the next scan checks everything again
+	pm.scanSettled = false
+	resume := pm.resumeOf(pillName, reason)
+	if resume == nil && !pm.transitionAllowed(pillName, reason) {
+		pm.deferSwitch(pillName)
+		return
+	}
+	pm.captureRevert(pillName, reason)
+
+	Logger.Info(bold(fmt.Sprintf("[Eating %s pill]", pillName)))
 
 	settings := pm.Pillz[pillName]
 
-	for name, value := range settings {
-		switch name {
-		case "scx":
-			err := pm.setScx(value)
-			if err != nil {
-				Logger.Errorf("Failed to change the scheduler : %v", err)
-			} else {
-				Logger.Infof("Scheduler set to %s", value)
-			}
+	event := &TransitionEvent{
+		Time:     time.Now(),
+		Reason:   reason,
+		FromPill: pm.CurrentPill,
+		ToPill:   pillName,
+		Actions:  []ActionResult{},
+		Resume:   resume,
+	}
 
-		case "tuned":
-			err := pm.setTunedProfile(value)
-			if err != nil {
-				Logger.Errorf("Failed to set TuneD profile : %v", err)
-			} else {
-				Logger.Infof("TuneD profile set to %s", value)
-			}
+	// Only the latest of the switches waiting for the rate limit or the guards is eaten
+	pm.deferSwitch(pillName)
+	event.Skipped, pm.deferredPill, pm.deferredSkipped = pm.deferredSkipped, "", nil
 
-		case "nice":
-			if pillName == "default" {
-				Logger.Warn("Nice is not autorized in the default profile, ignoring")
-			}
+	// Eating the current pill again for the same trigger leaves what it applied in place
+	unchanged := pillName == pm.CurrentPill && ((p == nil && pm.currentProc == 0) || (p != nil && p.PID() == pm.currentProc))
 
-		default:
-			Logger.Errorf("Unknown option: %s", name)
+	// What the previous pill applied goes back in the reverse order it was applied, each action
+	// before the pill sets it again. TuneD gets its mode back once the profile of the default
+	// pill is set. The actions the previous pill didn't apply come last, in the order of the pill.
+	reverting := !pm.dryRun && !unchanged
+	toDefault := pillName == pm.defaultPill
+	for _, name := range pm.revertOrder() {
+		if reverting && name != "tuned" && pm.backend.revert(event, name, settings, toDefault) {
+			delete(pm.applied, name)
+		}
+		if value := settings.action(name); value != "" {
+			pm.applyAction(event, p, pillName, name, value, resume)
+		}
+		if reverting && name == "tuned" {
+			pm.backend.revert(event, name, settings, toDefault)
 		}
 	}
 
-	// Reseting the known processes
-	for _, procInfo := range pm.knownProcs {
-		procInfo.Reniced = false
+	if resume != nil {
+		Logger.Infof("Pill %s is back %s after its trigger exited, within the reapply_window of %s. Still in effect: %s", pillName, resume.After, resume.Window, resume.skipped())
+	}
+
+	pm.recordTransition(pillName)
+	pm.pillSince = time.Now()
+
+	// The on_exit pill of a trigger is eaten once, and stays until another trigger matches,
+	// like a pill switched to by hand
+	pm.exitPill = ""
+	pm.exitHeld = reason == reasonOnExit || (reason == reasonManual && pillName != pm.defaultPill)
+	pm.niceClamped = false
+
+	pm.setPillLimits(pillName, settings)
+
+	// Reseting the known processes, unless they already have the settings of the pill
+	if reverting {
+		pm.restoreProcesses(event)
+	}
+
+	// Every restore is done by now, the event reports those that failed
+	for _, restore := range event.Restores {
+		if restore.Failed > 0 {
+			Logger.Warnf("Restoring %s failed %d times when pill %s dropped : %s", restore.Name, restore.Failed, event.FromPill, restore.Error)
+		}
 	}
 
 	if p != nil {
-		pm.currentProc = p.Pid
+		pm.currentProc = p.PID()
+		pm.currentStart, _, _ = p.Lifetime()
 		pm.currentParent = pm.getValidParent(p)
+		pm.saveLastTrigger(p)
+		pm.adopt = nil
+
+		// Renicing the trigger's process group at once when possible
+		if tree := pm.getTreeSettings(pillName); tree.isNice && tree.niceTree && !tree.gpuOnly && !pm.dryRun && !unchanged {
+			pm.reniceTriggerGroup(tree)
+		}
+
+		event.TriggerPid = pm.currentProc
+		event.ParentPid = pm.currentParent
+		if procInfo, exists := pm.knownProcs[p.PID()]; exists {
+			event.TriggerName = procInfo.Name
+			event.TriggerCmdline = procInfo.Cmdline
+		}
+		event.SteamAppID = pm.currentSteamAppID()
 
 	} else {
 		pm.currentProc = 0
+		pm.currentStart = 0
 		pm.currentParent = 0
 	}
 
 	pm.CurrentPill = pillName
+	pm.lastEvent = event
+	pm.saveStats()
+	if !pm.dryRun {
+		pm.saveRunState(event)
+		if pillName == pm.defaultPill {
+			pm.clearJournal()
+		} else {
+			pm.saveJournal()
+		}
+	}
 
 	Logger.Infof("current %d, parent %d", pm.currentProc, pm.currentParent)
+	if data, err := event.JSON(); err == nil {
+		Logger.Debugf("Transition %s", data)
+	}
+}
+
+// Global actions, those the pills applied last first, then the others in the order of the pills
+func (pm *PillManager) revertOrder() []string {
+	order := slices.Clone(globalActions)
+	slices.SortStableFunc(order, func(a, b string) int {
+		return cmp.Compare(pm.appliedSeq(b), pm.appliedSeq(a))
+	})
+	return order
+}
+
+// Rank of the last application of an action, 0 when it isn't applied
+func (pm *PillManager) appliedSeq(name string) uint64 {
+	if _, applied := pm.applied[name]; !applied {
+		return 0
+	}
+	return pm.appliedAt[name]
+}
+
+// Applies a global action of the pill being eaten, unless it is disabled or already applied
+func (pm *PillManager) applyAction(event *TransitionEvent, p Proc, pillName string, name string, value string, resume *ResumeInfo) {
+	if pm.dryRun {
+		event.addAction(name, value, nil)
+		return
+	}
+
+	if pm.actionDisabled(name) {
+		Logger.Debugf("Skipping disabled action %s", name)
+		return
+	}
+
+	if applied, exists := pm.applied[name]; exists && applied == value {
+		Logger.Debugf("%s already applied, skipping", name)
+		if resume != nil {
+			resume.Skipped = append(resume.Skipped, name)
+		}
+		return
+	}
+
+	if slices.Contains(journaledActions, name) {
+		pm.journalAction(pillName, name)
+	}
+
+	if applied, err := pm.backend.apply(p, pillName, name, value); applied {
+		pm.recordAction(event, name, value, err)
+	}
 }
 
 func (pm *PillManager) Close() {
+	pm.removeRunState()
 	if pm.dbusConn != nil {
 		pm.dbusConn.Close()
 	}
+	if pm.sessionConn != nil {
+		pm.sessionConn.Close()
+	}
 }
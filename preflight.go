@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// States of a backend found by the preflight
+const (
+	backendReachable   = "reachable"
+	backendUnreachable = "unreachable"
+	backendDenied      = "access denied"
+)
+
+// Methods of each backend the pills call, for the policy the user may be missing
+var backendMethods = map[string]string{
+	"tuned": "com.redhat.tuned.control.switch_profile",
+	"scx":   "org.scx.Loader.SwitchScheduler",
+	"ppd":   "HoldProfile",
+}
+
+// Whether a backend refused the call because of the bus policy or polkit
+func accessDenied(err error) bool {
+	var dbusErr dbus.Error
+	return errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.DBus.Error.AccessDenied"
+}
+
+// Makes a harmless read-only call on a backend
+func (pm *PillManager) preflightCall(backend string) error {
+	conn, err := pm.backendConn(backend)
+	if err != nil {
+		return err
+	}
+
+	switch backend {
+	case "tuned":
+		var profile string
+		return conn.Object("com.redhat.tuned", "/Tuned").Call("com.redhat.tuned.control.active_profile", 0).Store(&profile)
+	case "scx":
+		_, err := scxProperties(conn.Object("org.scx.Loader", "/org/scx/Loader"))
+		return err
+	case "ppd":
+		obj, iface, err := pm.ppdObject()
+		if err != nil {
+			return err
+		}
+		_, err = obj.GetProperty(iface + ".ActiveProfile")
+		return err
+	}
+	return nil
+}
+
+// Checks that the backends used by the pills answer the user running the daemon, before a pill
+// finds out mid-game. Backends refusing access are disabled, like actions failing repeatedly.
+func (pm *PillManager) preflight() map[string]string {
+	states := make(map[string]string)
+	for backend, reachable := range pm.reachableBackends() {
+		if !reachable {
+			states[backend] = backendUnreachable
+			continue
+		}
+
+		states[backend] = backendReachable
+		err := pm.preflightCall(backend)
+		if err == nil {
+			continue
+		}
+		if !accessDenied(err) {
+			Logger.Warnf("Preflight call to %s failed : %v", backend, err)
+			continue
+		}
+
+		states[backend] = backendDenied
+		pm.disabledActions[backend] = backendDenied
+		bus := pm.backendBus[backend]
+		if bus == "" {
+			bus = "system"
+		}
+		Logger.Errorf("%s refuses the calls of user %s on the %s bus (%v). A polkit rule or a busconfig policy in /etc/dbus-1/system.d is likely needed to allow %s, or process_pillz has to run as a user allowed to. %s is disabled until the config is reloaded or SIGUSR2 is received", backend, pm.userName, bus, err, backendMethods[backend], backend)
+	}
+	return states
+}
+
+// Runs the preflight without starting the daemon, for the healthcheck command
+func healthcheck() (bool, error) {
+	config, _, err := loadConfig()
+	if err != nil {
+		return false, fmt.Errorf("Configuration error: %v", err)
+	}
+
+	pm := NewPillManager(*config)
+	pm.ticker.Stop()
+	pm.persistStats = false
+	defer pm.Close()
+	pm.connectToDbus()
+
+	healthy := true
+	if procVisible() {
+		fmt.Println("proc: processes of all users visible")
+	} else {
+		fmt.Printf("proc: %s\n", hiddenProcsMessage())
+		healthy = false
+	}
+
+	states := pm.preflight()
+	for _, backend := range sortedKeys(states) {
+		fmt.Printf("%s: %s\n", backend, states[backend])
+		if states[backend] != backendReachable {
+			healthy = false
+		}
+	}
+	if len(states) == 0 {
+		fmt.Println("No backend used by the pills")
+	}
+	return healthy, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+// A process of the cache, kept across restarts and config reloads so that the first scan
+// doesn't read every process again. Command lines can hold tokens and passwords, only their
+// hash is written: the command line is read again and must match it.
+type savedProc struct {
+	Pid         int32  `json:"pid"`
+	CreateTime  int64  `json:"create_time"`
+	User        string `json:"user"`
+	Name        string `json:"name"`
+	CmdlineHash string `json:"cmdline_sha256"`
+	Exe         string `json:"exe,omitempty"`
+}
+
+func cmdlineHash(cmdline string) string {
+	sum := sha256.Sum256([]byte(cmdline))
+	return hex.EncodeToString(sum[:])
+}
+
+// Path of the process cache saved on exit, in the runtime directory as PIDs don't outlive boots
+func procCacheFilePath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "procs.json"), nil
+}
+
+// What the cache knows of the processes, without what depends on the config
+func (pm *PillManager) savedProcesses() map[int32]savedProc {
+	saved := make(map[int32]savedProc, len(pm.knownProcs))
+	for pid, procInfo := range pm.knownProcs {
+		if procInfo.created == 0 {
+			continue
+		}
+		saved[pid] = savedProc{Pid: pid, CreateTime: procInfo.created, User: procInfo.Username, Name: procInfo.Name, CmdlineHash: cmdlineHash(procInfo.Cmdline), Exe: procInfo.Exe}
+	}
+	return saved
+}
+
+// Saves the process cache for the next instance of the daemon, if enabled. Best effort.
+func (pm *PillManager) saveProcCache() {
+	if pm.dryRun || !pm.persistProcs {
+		return
+	}
+
+	path, err := procCacheFilePath()
+	if err != nil {
+		return
+	}
+
+	saved := pm.savedProcesses()
+	procs := make([]savedProc, 0, len(saved))
+	for _, pid := range sortedKeys(saved) {
+		procs = append(procs, saved[pid])
+	}
+	data, err := json.Marshal(procs)
+	if err != nil {
+		return
+	}
+
+	if err := writeStateFile(path, data); err != nil {
+		Logger.Debugf("Couldn't write the process cache %s : %v", path, err)
+	}
+}
+
+// Reads the process cache saved by the previous instance, if enabled. A missing or corrupt file
+// gives an empty cache, the processes are then read as usual.
+func (pm *PillManager) loadProcCache() map[int32]savedProc {
+	saved := make(map[int32]savedProc)
+	if !pm.persistProcs {
+		return saved
+	}
+	path, err := procCacheFilePath()
+	if err != nil {
+		return saved
+	}
+
+	data, err := readStateFile(path)
+	if err != nil {
+		return saved
+	}
+
+	var procs []savedProc
+	if err := json.Unmarshal(data, &procs); err != nil {
+		return saved
+	}
+	for _, proc := range procs {
+		if proc.Pid > 0 && proc.CreateTime > 0 {
+			saved[proc.Pid] = proc
+		}
+	}
+	return saved
+}
+
+// Rebuilds the cache entry of a process from the saved cache, when it is still the same
+// process: the PID may have been reused since, and the command line changed by the process.
+// Returns nil when the process must be read. Called by the workers reading the new processes,
+// which only read the saved cache.
+func (pm *PillManager) restoreProcessInfo(p Proc) *ProcessInfo {
+	saved, ok := pm.savedProcs[p.PID()]
+	if !ok {
+		return nil
+	}
+
+	created, err := p.CreateTime()
+	if err != nil || created != saved.CreateTime || saved.User != pm.userName || !pm.inScannedCgroup(p.PID()) {
+		return nil
+	}
+	cmdline, err := p.Cmdline()
+	if err != nil || cmdlineHash(cmdline) != saved.CmdlineHash {
+		return nil
+	}
+	return pm.newProcessInfo(p, saved.Name, cmdline, saved.Exe, saved.User, created)
+}
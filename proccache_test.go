@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProcCache(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "mysql", "mysql --password=hunter2")
+	source.spawn(101, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 101)
+
+	path, err := procCacheFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+
+	// Nothing is saved unless persist_proc_cache is set
+	pm.dryRun = false
+	pm.saveProcCache()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("saved the process cache with persist_proc_cache off: %v", err)
+	}
+	pm.persistProcs = true
+	pm.saveProcCache()
+	pm.dryRun = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatalf("the process cache holds the command lines: %s", data)
+	}
+
+	// Processes whose command line changed since are read again
+	pm.savedProcs = pm.loadProcCache()
+	source.procs[100].cmdline = "mysql"
+	if procInfo := pm.restoreProcessInfo(source.procs[100]); procInfo != nil {
+		t.Errorf("restored %q for a process whose command line changed", procInfo.Cmdline)
+	}
+	procInfo := pm.restoreProcessInfo(source.procs[101])
+	if procInfo == nil || procInfo.Name != "zzgame" || procInfo.Cmdline != "zzgame" {
+		t.Fatalf("restored %+v, want zzgame", procInfo)
+	}
+
+	// And so are those whose PID was reused
+	source.spawn(101, 50, "zzgame", "zzgame")
+	source.procs[101].created++
+	if procInfo := pm.restoreProcessInfo(source.procs[101]); procInfo != nil {
+		t.Error("restored a process whose PID was reused")
+	}
+}
+
+// After a restart, every process is new to the scan: the saved ones are checked by the workers
+func TestProcCacheBurst(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	var procs []Proc
+	for pid := int32(100); pid < 100+2*procBurstSize; pid++ {
+		procs = append(procs, source.spawn(pid, 1, "worker", fmt.Sprintf("worker --id=%d", pid)))
+	}
+	pm.scanProcesses()
+	pm.savedProcs = pm.savedProcesses()
+	clear(pm.knownProcs)
+
+	// One of them changed its command line since
+	source.procs[100].cmdline = "worker"
+	infos, restored := pm.readProcesses(procs, procWorkers)
+	if restored != len(procs)-1 {
+		t.Errorf("restored %d processes, want %d", restored, len(procs)-1)
+	}
+	if len(infos) != len(procs) || infos[100] == nil || infos[100].Cmdline != "worker" {
+		t.Errorf("read %d processes, with %+v for the changed one", len(infos), infos[100])
+	}
+}
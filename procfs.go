@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Directory of the process files, the tests give one of fake processes
+var procRoot = "/proc"
+
+// The priority system calls, the tests keep the nice values of fake processes instead
+var (
+	setPriority = syscall.Setpriority
+	getPriority = syscall.Getpriority
+)
+
+// Fields of /proc/<pid>/stat used by process_pillz
+type procStat struct {
+	State     byte // R, S, Z...
+	Ppid      int32
+	Pgrp      int32
+	TtyNr     int32  // Controlling terminal, 0 for none
+	Tpgid     int32  // Foreground process group of the controlling terminal
+	StartTime uint64 // Clock ticks between the boot and the start of the process
+}
+
+// Reads /proc/<pid>/stat
+func readProcStat(pid int32) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("%s/%d/stat", procRoot, pid))
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// The command name can contain spaces and parenthesis, the fields start after the last one
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return procStat{}, fmt.Errorf("malformed stat file for process %d", pid)
+	}
+
+	// state ppid pgrp session tty_nr tpgid ... starttime is the 20th
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return procStat{}, fmt.Errorf("malformed stat file for process %d", pid)
+	}
+
+	ppid, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed ppid for process %d", pid)
+	}
+
+	pgrp, err := strconv.ParseInt(fields[2], 10, 32)
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed pgrp for process %d", pid)
+	}
+
+	ttyNr, err := strconv.ParseInt(fields[4], 10, 32)
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed tty_nr for process %d", pid)
+	}
+
+	tpgid, err := strconv.ParseInt(fields[5], 10, 32)
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed tpgid for process %d", pid)
+	}
+
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return procStat{}, fmt.Errorf("malformed starttime for process %d", pid)
+	}
+
+	return procStat{State: fields[0][0], Ppid: int32(ppid), Pgrp: int32(pgrp), TtyNr: int32(ttyNr), Tpgid: int32(tpgid), StartTime: startTime}, nil
+}
+
+// Returns the nice value of a process
+func getNice(pid int32) (int, error) {
+	// The raw syscall returns 20 - nice, to avoid negative values
+	prio, err := getPriority(syscall.PRIO_PROCESS, int(pid))
+	if err != nil {
+		return 0, err
+	}
+	return 20 - prio, nil
+}
+
+// Returns the lowest nice value the daemon can set, from CAP_SYS_NICE or RLIMIT_NICE
+func lowestAllowedNice() int {
+	if hasCapability(unix.CAP_SYS_NICE) {
+		return -20
+	}
+
+	// The limit is expressed as 20 - nice
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NICE, &limit); err != nil {
+		return 20
+	}
+	if limit.Cur == unix.RLIM_INFINITY {
+		return -20
+	}
+	return 20 - int(min(limit.Cur, 40))
+}
+
+// Returns the memory available for new allocations without swapping, in bytes
+func readMemAvailable() (uint64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, "MemAvailable:"); found {
+			kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB")), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("malformed MemAvailable in /proc/meminfo")
+			}
+			return kb << 10, nil
+		}
+	}
+	return 0, fmt.Errorf("no MemAvailable in /proc/meminfo")
+}
+
+// Returns the load average over the last minute
+func readLoadavg() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// Returns the cgroup of a process, from the unified hierarchy or the systemd one of cgroup v1
+func readCgroup(pid int32) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) == 3 && (fields[0] == "0" || fields[1] == "name=systemd") {
+			return fields[2], nil
+		}
+	}
+	return "", fmt.Errorf("no systemd cgroup for process %d", pid)
+}
+
+// Returns the cgroup of a process in the unified hierarchy, the only one with cgroup v2 controllers
+func unifiedCgroup(pid int32) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, found := strings.CutPrefix(line, "0::"); found {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("process %d isn't in a cgroup v2 hierarchy", pid)
+}
+
+// Returns the timer slack of a process, in nanoseconds
+func readTimerSlack(pid int32) (int64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("%s/%d/timerslack_ns", procRoot, pid))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Sets the timer slack of a process, in nanoseconds
+func writeTimerSlack(pid int32, slack int64) error {
+	return os.WriteFile(fmt.Sprintf("%s/%d/timerslack_ns", procRoot, pid), []byte(strconv.FormatInt(slack, 10)), 0)
+}
+
+// Parses a list like "0-3,8,10-11", as used by the kernel for CPUs and nodes
+func parseList(list string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list %q", list)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid list %q", list)
+			}
+		}
+
+		for v := start; v <= end; v++ {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// Formats a list of values like "0-3,8,10-11", the values being sorted
+func formatList(values []int) string {
+	var parts []string
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(values[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", values[i], values[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Online CPUs of the system, in sysfs
+var onlineCPUsPath = "/sys/devices/system/cpu/online"
+
+// Returns the online CPUs. CPUs can be hotplugged and SMT disabled at any time, so it is read every time.
+func onlineCPUs() ([]int, error) {
+	data, err := os.ReadFile(onlineCPUsPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseList(string(data))
+}
+
+// Returns the online NUMA nodes
+func onlineNumaNodes() ([]int, error) {
+	data, err := os.ReadFile("/sys/devices/system/node/online")
+	if err != nil {
+		return nil, err
+	}
+	return parseList(string(data))
+}
+
+// Returns the NUMA nodes holding pages of a process, from its memory maps
+func numaPageNodes(pid int32) ([]int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/numa_maps", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []int
+	for _, field := range strings.Fields(string(data)) {
+		count, found := strings.CutPrefix(field, "N")
+		if !found {
+			continue
+		}
+		node, _, found := strings.Cut(count, "=")
+		if n, err := strconv.Atoi(node); found && err == nil && !slices.Contains(nodes, n) {
+			nodes = append(nodes, n)
+		}
+	}
+	slices.Sort(nodes)
+	return nodes, nil
+}
+
+// Moves the pages of a process from some NUMA nodes to others
+func migratePages(pid int32, from []int, to []int) error {
+	maxNode := 0
+	for _, n := range append(slices.Clone(from), to...) {
+		maxNode = max(maxNode, n)
+	}
+
+	words := maxNode/64 + 1
+	fromMask := make([]uint64, words)
+	toMask := make([]uint64, words)
+	for _, n := range from {
+		fromMask[n/64] |= 1 << (n % 64)
+	}
+	for _, n := range to {
+		toMask[n/64] |= 1 << (n % 64)
+	}
+
+	// The kernel ignores the last bit of maxnode
+	_, _, errno := syscall.Syscall6(syscall.SYS_MIGRATE_PAGES, uintptr(pid), uintptr(words*64+1),
+		uintptr(unsafe.Pointer(&fromMask[0])), uintptr(unsafe.Pointer(&toMask[0])), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Whether the daemon has a capability in its effective set
+func hasCapability(capability int) bool {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, "CapEff:"); found {
+			caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			return err == nil && caps&(1<<capability) != 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package main
+
+import "sync"
+
+// Workers reading the new processes of a scan, and the number of new processes from which
+// they are used. Below it, starting them costs more than reading the processes in a row.
+const (
+	procWorkers   = 4
+	procBurstSize = 32
+)
+
+// A new process read by a worker, nil info for the processes the scan skips
+type procRead struct {
+	pid   int32
+	info  *ProcessInfo
+	saved bool // Whether it came from the saved cache
+}
+
+// Reads a process the cache doesn't know. Returns nil for the processes the scan skips,
+// those of other users or outside of the scanned cgroup.
+func (pm *PillManager) readProcessInfo(p Proc) *ProcessInfo {
+	if !pm.inScannedCgroup(p.PID()) {
+		return nil
+	}
+
+	pUser, err := p.Username()
+	if err != nil {
+		Logger.Warnf("Can't get the user of a process : %v", err)
+		return nil
+	}
+
+	// Do not deal with non user processes
+	if pUser != pm.userName {
+		return nil
+	}
+
+	pCmd, err := p.Cmdline()
+	if err != nil {
+		Logger.Warnf("Could not get command line of process %d", p.PID())
+	}
+
+	pName, err := p.Name()
+	if err != nil {
+		Logger.Warnf("Could not get name of process %d", p.PID())
+		pName = "unknown"
+	}
+
+	// Kernel threads and processes of other namespaces have none, exe triggers skip them
+	pExe, _ := p.Exe()
+
+	// Tells the process apart from a later one reusing its PID, in the saved cache
+	created, _ := p.CreateTime()
+
+	return pm.newProcessInfo(p, pName, pCmd, pExe, pUser, created)
+}
+
+// Creates the cache entry of a process, with what depends on the config
+func (pm *PillManager) newProcessInfo(p Proc, pName string, pCmd string, pExe string, pUser string, created int64) *ProcessInfo {
+	procInfo := &ProcessInfo{
+		Name:       pName,
+		Cmdline:    pCmd,
+		Exe:        pExe,
+		Username:   pUser,
+		Reniced:    false,
+		Suppressor: pm.checkSuppressorMatch(pCmd),
+		created:    created,
+	}
+	// Reading every environment is costly, errors like those of setuid processes are ignored
+	if pm.envTriggers {
+		procInfo.environ, _ = p.Environ()
+	}
+	// Unreadable cgroups stay empty and match no trigger
+	if pm.cgroupTriggers {
+		procInfo.cgroup, _ = p.Cgroup()
+	}
+	if procInfo.blacklisted = pm.checkBlacklist(pName, pCmd); procInfo.blacklisted {
+		Logger.Debugf("Process %s (PID %d) is blacklisted, skipping it", pName, p.PID())
+	}
+	return procInfo
+}
+
+// Reads the processes of a scan the cache doesn't know. Bursts, like a compile starting or the
+// first scan after a restart, are read by a few workers, whose results are merged here, so that
+// only the scan writes the cache. The workers are done when it returns.
+func (pm *PillManager) readNewProcesses(processes []Proc) map[int32]*ProcessInfo {
+	var unknown []Proc
+	for _, p := range processes {
+		if _, known := pm.knownProcs[p.PID()]; !known {
+			unknown = append(unknown, p)
+		}
+	}
+
+	workers := procWorkers
+	if len(unknown) < procBurstSize {
+		workers = 1
+	}
+	infos, restored := pm.readProcesses(unknown, workers)
+
+	// The saved processes the scan didn't see are gone
+	if len(pm.savedProcs) > 0 {
+		Logger.Debugf("Reused %d of the %d processes of the saved cache", restored, len(pm.savedProcs))
+		clear(pm.savedProcs)
+	}
+	return infos
+}
+
+// Reads a process the cache doesn't know, or only checks that a process of the saved cache is
+// still the same. Returns whether the process came from the saved cache.
+func (pm *PillManager) readNewProcess(p Proc) (*ProcessInfo, bool) {
+	if procInfo := pm.restoreProcessInfo(p); procInfo != nil {
+		return procInfo, true
+	}
+	return pm.readProcessInfo(p), false
+}
+
+// Reads processes the cache doesn't know, in a row for a single worker. Returns how many came
+// from the saved cache.
+func (pm *PillManager) readProcesses(procs []Proc, workers int) (map[int32]*ProcessInfo, int) {
+	infos := make(map[int32]*ProcessInfo, len(procs))
+	restored := 0
+	if workers <= 1 {
+		for _, p := range procs {
+			procInfo, saved := pm.readNewProcess(p)
+			infos[p.PID()] = procInfo
+			if saved {
+				restored++
+			}
+		}
+		return infos, restored
+	}
+
+	jobs := make(chan Proc)
+	results := make(chan procRead)
+	var group sync.WaitGroup
+	for range workers {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for p := range jobs {
+				procInfo, saved := pm.readNewProcess(p)
+				results <- procRead{pid: p.PID(), info: procInfo, saved: saved}
+			}
+		}()
+	}
+	go func() {
+		for _, p := range procs {
+			jobs <- p
+		}
+		close(jobs)
+		group.Wait()
+		close(results)
+	}()
+
+	for read := range results {
+		infos[read.pid] = read.info
+		if read.saved {
+			restored++
+		}
+	}
+	return infos, restored
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Count processes of the system, read again from /proc like new ones
+func liveProcs(b *testing.B, count int) []Proc {
+	b.Helper()
+	pids, err := liveSource{}.Pids()
+	if err != nil || len(pids) == 0 {
+		b.Skipf("no processes to read: %v", err)
+	}
+	procs := make([]Proc, 0, count)
+	for i := 0; len(procs) < count; i++ {
+		if p, err := (liveSource{}).Process(pids[i%len(pids)]); err == nil {
+			procs = append(procs, p)
+		}
+	}
+	return procs
+}
+
+// A process whose command line takes a while to read, like one whose memory is being mapped
+type slowProc struct {
+	Proc
+	delay time.Duration
+}
+
+func (p slowProc) Cmdline() (string, error) {
+	time.Sleep(p.delay)
+	return p.Proc.Cmdline()
+}
+
+// The new processes of a scan, after a compile started and on an idle desktop, read in a row,
+// by the workers, and as readNewProcesses picks. Reading /proc, the burst gets faster with as
+// many workers as CPUs, while the few new processes are still read in a row. With reads that
+// block, the workers overlap them even on a single CPU.
+func BenchmarkReadNewProcesses(b *testing.B) {
+	reads := map[string]func(pm *PillManager, procs []Proc){
+		"serial": func(pm *PillManager, procs []Proc) { pm.readProcesses(procs, 1) },
+		"pooled": func(pm *PillManager, procs []Proc) { pm.readProcesses(procs, procWorkers) },
+		"scan":   func(pm *PillManager, procs []Proc) { pm.readNewProcesses(procs) },
+	}
+	for _, source := range []string{"proc", "blocking"} {
+		for _, count := range []int{500, 5} {
+			for _, mode := range []string{"serial", "pooled", "scan"} {
+				b.Run(fmt.Sprintf("%s/%d new/%s", source, count, mode), func(b *testing.B) {
+					pm, _ := newFakeManager(b, fakeConfig)
+					for range b.N {
+						b.StopTimer()
+						procs := liveProcs(b, count)
+						if source == "blocking" {
+							for i, p := range procs {
+								procs[i] = slowProc{p, 20 * time.Microsecond}
+							}
+						}
+						b.StartTimer()
+
+						reads[mode](pm, procs)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// A process, as seen by the scan
+type Proc interface {
+	PID() int32
+	Parent() (Proc, error)
+	Name() (string, error)
+	Cmdline() (string, error)
+	Exe() (string, error)       // Resolved path of the executable
+	Environ() ([]string, error) // Environment variables, as NAME=value
+	Cgroup() (string, error)    // Path of the systemd cgroup, like /user.slice/.../app-steam-1234.scope
+	Username() (string, error)
+	CPUTime() (float64, error)       // Total user and system CPU time, in seconds
+	CreateTime() (int64, error)      // Start time, in milliseconds since the epoch
+	Terminal() (int32, bool, error)  // Controlling terminal, 0 for none, and whether the process is in its foreground
+	Lifetime() (uint64, bool, error) // Start time in clock ticks since the boot, which unlike CreateTime doesn't follow the clock, and whether the process exited and waits to be reaped
+}
+
+// Where the scan gets the processes from
+type ProcSource interface {
+	Processes() ([]Proc, error)
+	Process(pid int32) (Proc, error)
+}
+
+// Processes of the running system, read from /proc
+type liveSource struct{}
+
+type liveProc struct {
+	p *process.Process
+}
+
+func (liveSource) Processes() ([]Proc, error) {
+	processes, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	procs := make([]Proc, len(processes))
+	for i, p := range processes {
+		procs[i] = liveProc{p}
+	}
+	return procs, nil
+}
+
+func (liveSource) Pids() ([]int32, error) {
+	return process.Pids()
+}
+
+func (liveSource) Process(pid int32) (Proc, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	return liveProc{p}, nil
+}
+
+func (lp liveProc) PID() int32 { return lp.p.Pid }
+
+func (lp liveProc) Parent() (Proc, error) {
+	parent, err := lp.p.Parent()
+	if err != nil {
+		return nil, err
+	}
+	return liveProc{parent}, nil
+}
+
+func (lp liveProc) Name() (string, error)     { return printable(lp.p.Name()) }
+func (lp liveProc) Cmdline() (string, error)  { return printable(lp.p.Cmdline()) }
+func (lp liveProc) Exe() (string, error)      { return printable(lp.p.Exe()) }
+func (lp liveProc) Username() (string, error) { return lp.p.Username() }
+
+func (lp liveProc) Environ() ([]string, error) { return lp.p.Environ() }
+func (lp liveProc) Cgroup() (string, error)    { return readCgroup(lp.p.Pid) }
+
+func (lp liveProc) CreateTime() (int64, error) { return lp.p.CreateTime() }
+
+func (lp liveProc) Terminal() (int32, bool, error) {
+	stat, err := readProcStat(lp.p.Pid)
+	if err != nil {
+		return 0, false, err
+	}
+	return stat.TtyNr, stat.TtyNr != 0 && stat.Tpgid == stat.Pgrp, nil
+}
+
+func (lp liveProc) Lifetime() (uint64, bool, error) {
+	stat, err := readProcStat(lp.p.Pid)
+	if err != nil {
+		return 0, false, err
+	}
+	return stat.StartTime, stat.State == 'Z', nil
+}
+
+func (lp liveProc) CPUTime() (float64, error) {
+	times, err := lp.p.Times()
+	if err != nil {
+		return 0, err
+	}
+	return times.User + times.System, nil
+}
+
+// Escapes the non-printable characters of a name or command line. Processes choose them,
+// and they end up in the logs, where escape sequences would reach the terminal.
+func printable(s string, err error) (string, error) {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s, err
+	}
+
+	var b strings.Builder
+	for i, w := 0, 0; i < len(s); i += w {
+		var r rune
+		r, w = utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && w == 1:
+			fmt.Fprintf(&b, "\\x%02x", s[i])
+		case !unicode.IsPrint(r):
+			b.WriteString(strings.Trim(strconv.QuoteRune(r), "'"))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), err
+}
+
+// A process of a snapshot file
+type SnapshotProc struct {
+	Pid        int32    `json:"pid"`
+	Ppid       int32    `json:"ppid"`
+	Name       string   `json:"name"`
+	Cmdline    string   `json:"cmdline"`
+	Exe        string   `json:"exe,omitempty"`
+	User       string   `json:"user"`
+	CPUPercent float64  `json:"cpu_percent,omitempty"`
+	CreateTime int64    `json:"create_time,omitempty"`
+	TTY        int32    `json:"tty,omitempty"`
+	Foreground bool     `json:"foreground,omitempty"`
+	Cgroup     string   `json:"cgroup,omitempty"`
+	Environ    []string `json:"environ,omitempty"` // Not recorded, environments hold secrets. Added by hand to simulate env: triggers
+	Zombie     bool     `json:"zombie,omitempty"`  // Exited, waiting for its parent to reap it
+}
+
+// Structure of a snapshot file
+type Snapshot struct {
+	User      string         `json:"user"` // User running process_pillz on the snapshotted system
+	Processes []SnapshotProc `json:"processes"`
+}
+
+// Processes of a snapshot. Their CPU usage stays at the snapshotted percentage, over a time
+// that only goes forward between the replayed scans.
+type snapshotSource struct {
+	procs   map[int32]*SnapshotProc
+	order   []int32
+	started time.Time
+	now     time.Time
+}
+
+type snapshotProc struct {
+	source *snapshotSource
+	info   *SnapshotProc
+}
+
+func loadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot %s: %v", path, err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot %s: %v", path, err)
+	}
+	return &snapshot, nil
+}
+
+func newSnapshotSource(snapshot *Snapshot) *snapshotSource {
+	source := &snapshotSource{
+		procs:   make(map[int32]*SnapshotProc),
+		started: time.Now(),
+	}
+	source.now = source.started
+	for i := range snapshot.Processes {
+		proc := &snapshot.Processes[i]
+		source.procs[proc.Pid] = proc
+		source.order = append(source.order, proc.Pid)
+	}
+	return source
+}
+
+func (s *snapshotSource) Processes() ([]Proc, error) {
+	procs := make([]Proc, 0, len(s.order))
+	for _, pid := range s.order {
+		procs = append(procs, snapshotProc{s, s.procs[pid]})
+	}
+	return procs, nil
+}
+
+func (s *snapshotSource) Process(pid int32) (Proc, error) {
+	info, exists := s.procs[pid]
+	if !exists {
+		return nil, fmt.Errorf("process %d not in the snapshot", pid)
+	}
+	return snapshotProc{s, info}, nil
+}
+
+func (sp snapshotProc) PID() int32 { return sp.info.Pid }
+
+func (sp snapshotProc) Parent() (Proc, error) {
+	return sp.source.Process(sp.info.Ppid)
+}
+
+func (sp snapshotProc) Name() (string, error)     { return printable(sp.info.Name, nil) }
+func (sp snapshotProc) Cmdline() (string, error)  { return printable(sp.info.Cmdline, nil) }
+func (sp snapshotProc) Exe() (string, error)      { return printable(sp.info.Exe, nil) }
+func (sp snapshotProc) Username() (string, error) { return sp.info.User, nil }
+
+func (sp snapshotProc) Environ() ([]string, error) { return sp.info.Environ, nil }
+func (sp snapshotProc) Cgroup() (string, error)    { return sp.info.Cgroup, nil }
+
+func (sp snapshotProc) CreateTime() (int64, error) { return sp.info.CreateTime, nil }
+
+func (sp snapshotProc) Terminal() (int32, bool, error) {
+	return sp.info.TTY, sp.info.TTY != 0 && sp.info.Foreground, nil
+}
+
+// The creation time stands for the start time, it doesn't change within a snapshot
+func (sp snapshotProc) Lifetime() (uint64, bool, error) {
+	return uint64(sp.info.CreateTime), sp.info.Zombie, nil
+}
+
+func (sp snapshotProc) CPUTime() (float64, error) {
+	return sp.info.CPUPercent / 100 * sp.source.now.Sub(sp.source.started).Seconds(), nil
+}
+
+// Time of the replayed scans
+func (s *snapshotSource) clock() time.Time { return s.now }
+
+// Writes a snapshot of the running processes, sampling their CPU usage over a second
+func writeSnapshot(out *os.File) error {
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("Couldn't find the current user's name. %v", err)
+	}
+
+	procs, err := liveSource{}.Processes()
+	if err != nil {
+		return fmt.Errorf("Couldn't get running processes: %v", err)
+	}
+
+	const sampleDelay = time.Second
+	before := make(map[int32]float64)
+	for _, p := range procs {
+		if cpuTime, err := p.CPUTime(); err == nil {
+			before[p.PID()] = cpuTime
+		}
+	}
+	time.Sleep(sampleDelay)
+
+	snapshot := Snapshot{User: currentUser.Username, Processes: []SnapshotProc{}}
+	for _, p := range procs {
+		lp := p.(liveProc)
+		pName, err := lp.Name()
+		if err != nil {
+			continue // Gone during the sampling
+		}
+		ppid, _ := lp.p.Ppid()
+		pCmd, _ := lp.Cmdline()
+		pExe, _ := lp.Exe()
+		pUser, _ := lp.Username()
+
+		createTime, _ := lp.CreateTime()
+		tty, foreground, _ := lp.Terminal()
+		cgroup, _ := lp.Cgroup()
+		_, zombie, _ := lp.Lifetime()
+
+		proc := SnapshotProc{Pid: lp.PID(), Ppid: ppid, Name: pName, Cmdline: pCmd, Exe: pExe, User: pUser, CreateTime: createTime, TTY: tty, Foreground: foreground, Cgroup: cgroup, Zombie: zombie}
+		if cpuTime, err := lp.CPUTime(); err == nil {
+			if previous, sampled := before[lp.PID()]; sampled {
+				proc.CPUPercent = (cpuTime - previous) / sampleDelay.Seconds() * 100
+			}
+		}
+		snapshot.Processes = append(snapshot.Processes, proc)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+// Replays the scan against a snapshot, printing the transitions that would occur.
+// Pills are not applied, and neither D-Bus nor /proc are touched.
+func simulate(cfg Config, snapshotPath string) error {
+	snapshot, err := loadSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	source := newSnapshotSource(snapshot)
+	pm := newPillManager(cfg, source)
+	pm.ticker.Stop()
+	pm.cpuClock = source.clock
+	pm.dryRun = true
+	pm.persistStats = false
+	pm.cgroupPrefix = ""
+	if snapshot.User != "" {
+		pm.userName = snapshot.User
+	}
+
+	// The first scans only sample the CPU usage of the processes
+	const scans = 3
+	var last *TransitionEvent
+	for range scans {
+		source.now = source.now.Add(pm.scanInterval)
+		pm.scanProcesses()
+
+		if pm.lastEvent != last {
+			last = pm.lastEvent
+			fmt.Println(last)
+			slices.SortFunc(last.Actions, func(a, b ActionResult) int { return strings.Compare(a.Name, b.Name) })
+			for _, action := range last.Actions {
+				fmt.Printf("  %s: %s\n", action.Name, action.Value)
+			}
+		}
+	}
+
+	fmt.Printf("Final pill: %s\n", pm.CurrentPill)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Pill transitions allowed within the rate limit window, when not configured
+const defaultMaxTransitions = 10
+
+// Rate limit window, in seconds, when not configured
+const defaultTransitionWindow = 60
+
+// Whether a transition is allowed by the rate limit. Reverting on shutdown, reload, recovery and audit always is,
+// and so is the switch command, a user asking for a pill isn't flapping.
+// A deferred transition is tried again on the next scan.
+func (pm *PillManager) transitionAllowed(pillName string, reason string) bool {
+	if reason == reasonStartup || reason == reasonShutdown || reason == reasonReload || reason == reasonRecovery || reason == reasonAudit || reason == reasonManual || pm.rateLimit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	pm.rateTimes = slices.DeleteFunc(pm.rateTimes, func(t time.Time) bool {
+		return now.Sub(t) > pm.rateWindow
+	})
+
+	if len(pm.rateTimes) < pm.rateLimit {
+		pm.rateTimes = append(pm.rateTimes, now)
+		return true
+	}
+
+	pm.rateDeferred++
+	if now.Sub(pm.rateWarnedAt) > pm.rateWindow {
+		Logger.Warnf("Pills are flapping (%d transitions in the last %s), deferring the switch to %s (%s) until the window clears. Consider making triggers more specific",
+			len(pm.rateTimes), pm.rateWindow, pillName, reason)
+		pm.rateWarnedAt = now
+	}
+	return false
+}
+
+// Keeps the switch to a pill for when it is allowed, in place of the one deferred before it
+func (pm *PillManager) deferSwitch(pillName string) {
+	if pm.deferredPill != "" && pm.deferredPill != pillName {
+		Logger.Infof("The deferred switch to %s is skipped, %s was asked for since", pm.deferredPill, pillName)
+		pm.deferredSkipped = append(pm.deferredSkipped, pm.deferredPill)
+	}
+	pm.deferredPill = pillName
+}
+
+// Forgets the deferred switch once the current pill is wanted again, nothing is left to eat
+func (pm *PillManager) dropDeferred() {
+	if pm.deferredPill == "" {
+		return
+	}
+	Logger.Infof("The deferred switch to %s is skipped, %s is wanted again", pm.deferredPill, pm.CurrentPill)
+	pm.deferredPill, pm.deferredSkipped, pm.guardedPill = "", nil, ""
+}
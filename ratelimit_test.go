@@ -0,0 +1,87 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRateLimit(t *testing.T) {
+	pm, source := newFakeManager(t, `
+scan_interval: 1
+max_transitions: 2
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {nice: 5}
+  bench: {scx: lavd}
+`)
+
+	// The startup pill doesn't count, the next two transitions fill the window
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+	source.spawn(101, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "default", 0)
+	if pm.rateDeferred != 1 {
+		t.Errorf("%d transitions deferred, want 1", pm.rateDeferred)
+	}
+
+	// The switch command isn't limited
+	if reply := pm.manualSwitch("bench"); strings.HasPrefix(reply, controlError) || pm.CurrentPill != "bench" {
+		t.Fatalf("switch replied %q on pill %s, want bench eaten", reply, pm.CurrentPill)
+	}
+	if reply := pm.manualSwitch("default"); strings.HasPrefix(reply, controlError) || pm.CurrentPill != "default" {
+		t.Fatalf("switch replied %q on pill %s, want default eaten", reply, pm.CurrentPill)
+	}
+}
+
+// Of the switches deferred by the rate limit, only the latest is eaten once the window clears
+func TestRateLimitSkipsDeferred(t *testing.T) {
+	pm, source := newFakeManager(t, `
+scan_interval: 1
+max_transitions: 1
+triggers:
+  zzgame: game
+  zzbench: bench
+pills:
+  default: {scx: rusty}
+  game: {nice: 5}
+  bench: {scx: lavd}
+`)
+	logs := observeLogs(t, zapcore.InfoLevel)
+
+	// The window is full, game then bench are deferred
+	pm.rateTimes = []time.Time{time.Now()}
+	source.spawn(100, 1, "zzgame", "zzgame")
+	expectPill(t, pm, "default", 0)
+	source.exit(100)
+	source.spawn(101, 1, "zzbench", "zzbench")
+	expectPill(t, pm, "default", 0)
+
+	pm.rateTimes = nil
+	expectPill(t, pm, "bench", 101)
+	if !slices.Equal(pm.lastEvent.Skipped, []string{"game"}) || !strings.Contains(pm.lastEvent.String(), "skipping the deferred game") {
+		t.Errorf("transition %s skipped %q, want the deferred game", pm.lastEvent, pm.lastEvent.Skipped)
+	}
+
+	// A deferred switch whose pill isn't wanted anymore is dropped, the next transition skips nothing
+	source.exit(101)
+	expectPill(t, pm, "bench", 101)
+	source.spawn(102, 1, "zzbench", "zzbench")
+	expectPill(t, pm, "bench", 102)
+	if logs.FilterMessageSnippet("The deferred switch to default is skipped, bench is wanted again").Len() != 1 {
+		t.Errorf("dropping the deferred switch wasn't logged: %v", logs.All())
+	}
+	pm.rateTimes = nil
+	source.exit(102)
+	expectPill(t, pm, "default", 0)
+	if pm.lastEvent.Skipped != nil {
+		t.Errorf("transition %s skipped %q, want nothing", pm.lastEvent, pm.lastEvent.Skipped)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Pill reverted when its trigger exited. Games relaunching themselves, like for DRM or a
+// launcher handing off, get it back cheaply within reapply_window.
+type revertedPill struct {
+	pill string
+	at   time.Time
+}
+
+// A pill eaten again within reapply_window, reported in the transition
+type ResumeInfo struct {
+	Window  string   `json:"reapply_window"`
+	After   string   `json:"after"`             // Time since the revert
+	Skipped []string `json:"skipped,omitempty"` // Actions still in effect since the revert, not run again
+}
+
+// Remembers the current pill on a revert caused by its trigger exiting
+func (pm *PillManager) captureRevert(pillName string, reason string) {
+	pm.reverted = nil
+	if pm.reapplyWindow > 0 && reason == reasonTriggerGone && pillName == pm.defaultPill && pm.CurrentPill != pm.defaultPill {
+		pm.reverted = &revertedPill{pill: pm.CurrentPill, at: time.Now()}
+	}
+}
+
+// Returns the resume of the reverted pill, when its trigger is back within reapply_window.
+// The actions the default pill left in place aren't run again, like for any pill eaten,
+// and the resume isn't held back by the rate limit.
+func (pm *PillManager) resumeOf(pillName string, reason string) *ResumeInfo {
+	reverted := pm.reverted
+	if reverted == nil || reason != reasonTrigger || pillName != reverted.pill || pm.CurrentPill != pm.defaultPill {
+		return nil
+	}
+	after := time.Since(reverted.at)
+	if after > pm.reapplyWindow {
+		return nil
+	}
+	return &ResumeInfo{Window: pm.reapplyWindow.String(), After: after.Round(time.Millisecond).String()}
+}
+
+// Names of the actions skipped by a resume, for the logs
+func (r *ResumeInfo) skipped() string {
+	if len(r.Skipped) == 0 {
+		return "none"
+	}
+	return strings.Join(r.Skipped, ", ")
+}
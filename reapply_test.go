@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+const reapplyConfig = `
+scan_interval: 1
+reapply_window: 10s
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {scx: lavd, nice: 5}
+`
+
+// A trigger exiting and coming back between two scans never leaves its pill
+func TestRetriggerBetweenScans(t *testing.T) {
+	pm, source := newFakeManager(t, reapplyConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	eaten := pm.lastEvent
+
+	source.exit(100)
+	source.spawn(101, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 101)
+	if pm.lastEvent != eaten || pm.stats["game"].Activations != 1 {
+		t.Fatalf("transition %v after %d activations, want the pill applied once", pm.lastEvent, pm.stats["game"].Activations)
+	}
+}
+
+// A→default→A across scans is a revert, then a resume of the pill
+func TestReapplyWithinWindow(t *testing.T) {
+	pm, source := newFakeManager(t, reapplyConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+
+	source.spawn(101, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 101)
+	if pm.lastEvent.Resume == nil || pm.lastEvent.Resume.Window != "10s" {
+		t.Fatalf("transition %v, want a resume within 10s", pm.lastEvent)
+	}
+
+	// Without the window, it is a new pill
+	pm, source = newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+	source.spawn(101, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 101)
+	if pm.lastEvent.Resume != nil {
+		t.Fatalf("transition %v, want no resume without reapply_window", pm.lastEvent)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Resource limits that can be set by the rlimits option
+var rlimitResources = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// A resource limit of the rlimits option
+type rlimitSetting struct {
+	Name     string
+	Resource int
+	Limit    unix.Rlimit
+}
+
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// Parses an rlimits value like "memlock=unlimited nofile=524288:1048576".
+// A single value sets both the soft and the hard limits.
+func parseRlimits(value string) ([]rlimitSetting, error) {
+	var settings []rlimitSetting
+	for _, field := range strings.Fields(value) {
+		name, limits, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rlimits entry '%s', expected name=soft[:hard]", field)
+		}
+
+		resource, known := rlimitResources[name]
+		if !known {
+			names := make([]string, 0, len(rlimitResources))
+			for n := range rlimitResources {
+				names = append(names, n)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("unknown resource limit '%s', valid limits are: %s", name, strings.Join(names, ", "))
+		}
+
+		softStr, hardStr, hasHard := strings.Cut(limits, ":")
+		if !hasHard {
+			hardStr = softStr
+		}
+
+		soft, err := parseRlimitValue(softStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid soft limit '%s' for %s", softStr, name)
+		}
+		hard, err := parseRlimitValue(hardStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hard limit '%s' for %s", hardStr, name)
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("soft limit of %s is above its hard limit", name)
+		}
+
+		settings = append(settings, rlimitSetting{Name: name, Resource: resource, Limit: unix.Rlimit{Cur: soft, Max: hard}})
+	}
+
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("rlimits cannot be empty")
+	}
+	return settings, nil
+}
+
+func formatRlimitValue(value uint64) string {
+	if value == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+// Sets the resource limits of the trigger process, saving the previous ones.
+// Children spawned afterwards inherit them.
+func (pm *PillManager) setRlimits(pid int32, value string) error {
+	settings, err := parseRlimits(value)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, setting := range settings {
+		var previous unix.Rlimit
+		if err := unix.Prlimit(int(pid), setting.Resource, nil, &previous); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", setting.Name, err))
+			continue
+		}
+
+		if setting.Limit.Max > previous.Max && !hasCapability(unix.CAP_SYS_RESOURCE) {
+			failed = append(failed, fmt.Sprintf("%s (raising the hard limit from %s requires CAP_SYS_RESOURCE)", setting.Name, formatRlimitValue(previous.Max)))
+			continue
+		}
+
+		limit := setting.Limit
+		if err := unix.Prlimit(int(pid), setting.Resource, &limit, nil); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", setting.Name, err))
+			continue
+		}
+
+		if _, saved := pm.rlimitSaved[setting.Resource]; !saved {
+			pm.rlimitSaved[setting.Resource] = previous
+		}
+		Logger.Debugf("%s limit of PID %d set to %s:%s", setting.Name, pid, formatRlimitValue(limit.Cur), formatRlimitValue(limit.Max))
+	}
+
+	pm.rlimitPid = pid
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Couldn't set limits %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Restores the resource limits of the trigger process changed by the current pill
+func (pm *PillManager) restoreRlimits(event *TransitionEvent) {
+	var restored, failed int
+	var lastErr error
+	for resource, previous := range pm.rlimitSaved {
+		err := unix.Prlimit(int(pm.rlimitPid), resource, &previous, nil)
+		switch {
+		case err == nil:
+			restored++
+		case processGone(err):
+			// The limits went away with the trigger
+		default:
+			Logger.Debugf("Couldn't restore resource limit %d of PID %d : %v", resource, pm.rlimitPid, err)
+			failed, lastErr = failed+1, err
+		}
+		delete(pm.rlimitSaved, resource)
+	}
+	pm.rlimitPid = 0
+	event.addRestore("rlimits", restored, failed, lastErr)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// Current state, for other tools wanting to know the pill and the game process
+type RunState struct {
+	Pill        string    `json:"pill"`
+	TriggerPid  int32     `json:"trigger_pid,omitempty"`
+	TriggerName string    `json:"trigger_name,omitempty"`
+	ParentPid   int32     `json:"parent_pid,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+func runStateFilePath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// Writes the state file after a transition. It is replaced atomically, readers never see partial JSON.
+func (pm *PillManager) saveRunState(event *TransitionEvent) {
+	path, err := runStateFilePath()
+	if err != nil {
+		Logger.Warnf("Couldn't find the state file : %v", err)
+		return
+	}
+
+	state := RunState{
+		Pill:        event.ToPill,
+		TriggerPid:  event.TriggerPid,
+		TriggerName: event.TriggerName,
+		ParentPid:   event.ParentPid,
+		Time:        event.Time,
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		Logger.Warnf("Couldn't encode the state : %v", err)
+		return
+	}
+
+	if err := writeStateFile(path, data); err != nil {
+		Logger.Warnf("Couldn't write the state file %s : %v", path, err)
+	}
+}
+
+// Removes the state file when the daemon exits cleanly
+func (pm *PillManager) removeRunState() {
+	path, err := runStateFilePath()
+	if err != nil {
+		return
+	}
+
+	if err := removeStateFile(path); err != nil {
+		Logger.Warnf("Couldn't remove the state file %s : %v", path, err)
+	}
+}
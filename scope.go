@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Options of a process-scoped pill, they only change the trigger's tree
+var processScopeOptions = []string{"scope", "nice", "timer_slack"}
+
+// Whether a pill only changes the tree of its trigger, alongside the global pill
+func (pm *PillManager) isProcessScoped(pillName string) bool {
+	return pm.Pillz[pillName].Scope == "process"
+}
+
+// A process-scoped pill, active on the tree of one trigger process
+type scopedPill struct {
+	pill    string
+	tree    treeSettings
+	members map[int32]*scopedMember
+}
+
+// What a process-scoped pill changed on a process of its tree
+type scopedMember struct {
+	reniced       bool
+	originalNice  int
+	effectiveNice int
+	slackSet      bool
+	originalSlack int64
+}
+
+// A process that joined the tree of a process-scoped pill during a scan
+type scopedJoin struct {
+	pid    int32
+	scoped *scopedPill
+	parent *scopedMember // nil for the trigger process
+}
+
+// Returns the process-scoped pill whose tree a process belongs to
+func (pm *PillManager) scopedOf(pid int32) (*scopedPill, *scopedMember) {
+	for _, scoped := range pm.scoped {
+		if member, exists := scoped.members[pid]; exists {
+			return scoped, member
+		}
+	}
+	return nil, nil
+}
+
+// Starts the process-scoped pills of new trigger processes, extends their trees to the new
+// children, and reverts the pills whose trigger process exited
+func (pm *PillManager) updateScoped(processes []Proc, matches map[int32]string) {
+	for root, scoped := range pm.scoped {
+		if !pm.currentScan[root] {
+			Logger.Infof("Trigger process %d of pill %s exited, reverting its tree", root, scoped.pill)
+			pm.revertScoped(scoped)
+			delete(pm.scoped, root)
+			continue
+		}
+		for pid := range scoped.members {
+			if !pm.currentScan[pid] {
+				delete(scoped.members, pid)
+			}
+		}
+	}
+
+	var joined []scopedJoin
+	for root, pillName := range matches {
+		if _, active := pm.scoped[root]; active {
+			continue
+		}
+		if other, _ := pm.scopedOf(root); other != nil {
+			continue // Already changed by the pill of an ancestor
+		}
+
+		Logger.Info(bold(fmt.Sprintf("[Eating %s pill for process %d]", pillName, root)))
+		scoped := &scopedPill{pill: pillName, tree: pm.getTreeSettings(pillName), members: make(map[int32]*scopedMember)}
+		scoped.members[root] = &scopedMember{}
+		pm.scoped[root] = scoped
+		joined = append(joined, scopedJoin{pid: root, scoped: scoped})
+	}
+
+	if len(pm.scoped) == 0 {
+		return
+	}
+
+	// Children join the tree of their parent. The process list is not sorted by parent,
+	// so the pass is repeated until the trees stop growing.
+	for grown := true; grown; {
+		grown = false
+		for _, p := range processes {
+			procInfo, known := pm.knownProcs[p.PID()]
+			if !known {
+				continue
+			}
+			if scoped, _ := pm.scopedOf(p.PID()); scoped != nil {
+				continue
+			}
+
+			if procInfo.ppid == 0 {
+				parent, err := p.Parent()
+				if err != nil {
+					continue
+				}
+				procInfo.ppid = parent.PID()
+			}
+
+			if scoped, parent := pm.scopedOf(procInfo.ppid); scoped != nil {
+				member := &scopedMember{}
+				scoped.members[p.PID()] = member
+				joined = append(joined, scopedJoin{pid: p.PID(), scoped: scoped, parent: parent})
+				grown = true
+			}
+		}
+	}
+
+	// Processes of the global pill's tree keep its settings, simulations only report the trees
+	for _, join := range joined {
+		procInfo := pm.knownProcs[join.pid]
+		if procInfo.blacklisted || procInfo.InTree || pm.dryRun {
+			continue
+		}
+		pm.applyScoped(join, procInfo.Name)
+	}
+}
+
+// Applies the settings of a process-scoped pill to a process of its tree, on the tree worker
+func (pm *PillManager) applyScoped(join scopedJoin, name string) {
+	pid, tree := join.pid, join.scoped.tree
+	member, parent := join.scoped.members[pid], join.parent
+
+	if tree.isNice {
+		pm.queueTreeJob(func() func() {
+			original, err := getNice(pid)
+			nice := tree.niceFor(original)
+			if err == nil {
+				err = setPriority(syscall.PRIO_PROCESS, int(pid), nice)
+			}
+			if err != nil {
+				Logger.Warnf("Couldn't change nice value of %s (PID %d) : %v", name, pid, err)
+				return nil
+			}
+
+			effective, err := getNice(pid)
+			if err != nil {
+				effective = nice
+			}
+
+			return func() {
+				// Children forked after their parent was reniced inherited its value, not the original one
+				if parent != nil && parent.reniced && original == parent.effectiveNice {
+					original = parent.originalNice
+
+					// A delta applies to the original value, not to the inherited one
+					if tree.niceDelta && tree.niceFor(original) != nice {
+						nice = tree.niceFor(original)
+						if err := setPriority(syscall.PRIO_PROCESS, int(pid), nice); err == nil {
+							effective = pm.effectiveNice(pid, nice)
+						}
+					}
+				}
+				member.reniced, member.originalNice, member.effectiveNice = true, original, effective
+				pm.warnClamped(nice, effective)
+				Logger.Infof("reniced %s (PID %d) to %d for pill %s", name, pid, effective, join.scoped.pill)
+			}
+		})
+	}
+
+	if tree.timerSlack > 0 && !pm.slackBroken {
+		pm.queueTreeJob(func() func() {
+			original, err := readTimerSlack(pid)
+			if err == nil {
+				err = writeTimerSlack(pid, tree.timerSlack.Nanoseconds())
+			}
+
+			return func() {
+				if err != nil {
+					if pm.slackBroken {
+						return
+					}
+					if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+						Logger.Warnf("Timer slack can't be changed on this system, ignoring timer_slack : %v", err)
+						pm.slackBroken = true
+					} else {
+						Logger.Warnf("Couldn't change timer slack of %s (PID %d) : %v", name, pid, err)
+					}
+					return
+				}
+				member.slackSet, member.originalSlack = true, original
+			}
+		})
+	}
+}
+
+// Undoes what a process-scoped pill changed on the processes of its tree still running
+func (pm *PillManager) revertScoped(scoped *scopedPill) {
+	pm.flushTree()
+
+	for pid, member := range scoped.members {
+		if !pm.currentScan[pid] {
+			continue
+		}
+
+		if member.reniced {
+			// The global pill reniced it since, and restores it when it drops
+			if procInfo, exists := pm.knownProcs[pid]; exists && procInfo.Reniced {
+				procInfo.OriginalNice = member.originalNice
+			} else if current, err := getNice(pid); err == nil && current != member.effectiveNice {
+				Logger.Infof("PID %d was reniced to %d by someone else, leaving it", pid, current)
+			} else if err := setPriority(syscall.PRIO_PROCESS, int(pid), member.originalNice); err != nil {
+				Logger.Debugf("Couldn't restore nice value of PID %d : %v", pid, err)
+			}
+		}
+
+		if member.slackSet {
+			if err := writeTimerSlack(pid, member.originalSlack); err != nil {
+				Logger.Debugf("Couldn't restore timer slack of PID %d : %v", pid, err)
+			}
+		}
+	}
+}
+
+// Reverts every process-scoped pill
+func (pm *PillManager) restoreScoped() {
+	for root, scoped := range pm.scoped {
+		Logger.Infof("Reverting pill %s of process %d", scoped.pill, root)
+		pm.revertScoped(scoped)
+		delete(pm.scoped, root)
+	}
+}
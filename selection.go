@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// A process matching a trigger during a scan
+type triggerCandidate struct {
+	p          Proc
+	pill       string
+	createTime int64 // Milliseconds since the epoch, 0 if unknown
+	launcher   int32 // children_only launcher the process is a child of
+	onExit     string
+	group      string
+	trigger    string // Name of the matched trigger
+	priority   int    // Priority of the matched trigger
+}
+
+// Returns the children of the armed children_only launchers as candidates.
+// Children matching the trigger themselves, like the helper processes of the launcher, are not.
+func (pm *PillManager) launcherChildren(processes []Proc, armed map[int32]string) []triggerCandidate {
+	if len(armed) == 0 {
+		return nil
+	}
+
+	var children []triggerCandidate
+	for _, p := range processes {
+		procInfo, known := pm.knownProcs[p.PID()]
+		if !known {
+			continue
+		}
+		if _, isLauncher := armed[p.PID()]; isLauncher {
+			continue
+		}
+		if _, isExhausted := pm.pending.exhausted[p.PID()]; isExhausted {
+			continue
+		}
+
+		if procInfo.ppid == 0 {
+			parent, err := p.Parent()
+			if err != nil {
+				continue
+			}
+			procInfo.ppid = parent.PID()
+		}
+
+		if name, isChild := armed[procInfo.ppid]; isChild {
+			trigger := pm.Triggers[name]
+			if !pm.checkTriggerAge(p, procInfo, name, &trigger) {
+				continue
+			}
+			children = append(children, triggerCandidate{p: p, pill: trigger.Pill, launcher: procInfo.ppid, onExit: trigger.OnExit, group: trigger.Group, trigger: name, priority: trigger.Priority})
+		}
+	}
+	return children
+}
+
+// Trigger process chosen by a previous instance of the daemon
+type lastTrigger struct {
+	Pid        int32 `json:"pid"`
+	CreateTime int64 `json:"create_time"`
+}
+
+// Picks the trigger process among the candidates of a scan. The triggers with the highest
+// priority win. Among their processes, the trigger chosen before a restart is adopted again if it
+// still runs, otherwise the oldest process wins, then the lowest PID.
+func (pm *PillManager) pickTrigger(candidates []triggerCandidate) *triggerCandidate {
+	best := bestCandidate(candidates)
+	if best == nil {
+		return nil
+	}
+
+	if pm.adopt != nil {
+		for i := range candidates {
+			c := &candidates[i]
+			if c.priority == best.priority && c.p.PID() == pm.adopt.Pid && c.createTime == pm.adopt.CreateTime {
+				Logger.Infof("Adopting trigger process %d chosen before the restart", c.p.PID())
+				return c
+			}
+		}
+	}
+
+	if len(candidates) > 1 {
+		if best.p.PID() != pm.lastPick {
+			Logger.Infof("Trigger %s (PID %d, priority %d) picked among %d matching processes", best.trigger, best.p.PID(), best.priority, len(candidates))
+		}
+		pm.lastPick = best.p.PID()
+	}
+	return best
+}
+
+// Candidate with the highest priority, then the oldest, then the lowest PID
+func bestCandidate(candidates []triggerCandidate) *triggerCandidate {
+	var best *triggerCandidate
+	for i := range candidates {
+		c := &candidates[i]
+		c.createTime, _ = c.p.CreateTime()
+		if best == nil || c.priority > best.priority || (c.priority == best.priority && earlier(c, best)) {
+			best = c
+		}
+	}
+	return best
+}
+
+// Orders the triggers by decreasing priority, then by name, the order processes are matched in
+func orderTriggers(triggers map[string]Trigger) []string {
+	names := sortedKeys(triggers)
+	slices.SortStableFunc(names, func(a, b string) int { return cmp.Compare(triggers[b].Priority, triggers[a].Priority) })
+	return names
+}
+
+// Whether a candidate was started before another one, unknown start times coming last
+func earlier(a *triggerCandidate, b *triggerCandidate) bool {
+	if a.createTime != b.createTime {
+		if a.createTime == 0 || b.createTime == 0 {
+			return b.createTime == 0
+		}
+		return a.createTime < b.createTime
+	}
+	return a.p.PID() < b.p.PID()
+}
+
+func lastTriggerFilePath() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trigger.json"), nil
+}
+
+// Remembers the trigger process, so that a restarted daemon picks the same one.
+// Unlike the state file it is kept on exit, the runtime directory goes away with the session.
+func (pm *PillManager) saveLastTrigger(p Proc) {
+	if pm.dryRun {
+		return
+	}
+
+	path, err := lastTriggerFilePath()
+	if err != nil {
+		return
+	}
+
+	createTime, _ := p.CreateTime()
+	data, err := json.Marshal(lastTrigger{Pid: p.PID(), CreateTime: createTime})
+	if err != nil {
+		return
+	}
+
+	if err := writeStateFile(path, data); err != nil {
+		Logger.Debugf("Couldn't write the trigger file %s : %v", path, err)
+	}
+}
+
+// Reads the trigger process chosen by the previous instance of the daemon
+func loadLastTrigger() *lastTrigger {
+	path, err := lastTriggerFilePath()
+	if err != nil {
+		return nil
+	}
+
+	data, err := readStateFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logger.Debugf("Couldn't read the trigger file %s : %v", path, err)
+		}
+		return nil
+	}
+
+	var last lastTrigger
+	if err := json.Unmarshal(data, &last); err != nil || last.Pid <= 0 {
+		return nil
+	}
+	return &last
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+const priorityConfig = `
+scan_interval: 1
+triggers:
+  zzbench: {pill: bench, priority: 10}
+  zzgame: {pill: game, exclude: ["--server"]}
+  zzstream: stream
+pills:
+  default: {scx: rusty}
+  bench: {scx: lavd}
+  game: {nice: 5}
+  stream: {scx: bpfland}
+`
+
+// Every process of a scan is matched before the pill is picked, whatever order the scan finds
+// them in: the PIDs decide the order, and the outcome must not depend on them
+func TestPickOrder(t *testing.T) {
+	type started struct {
+		name, cmdline string
+		age           int64 // Milliseconds it was started before the scan
+		trigger       bool  // Whether it must be picked
+	}
+	tests := []struct {
+		name  string
+		procs []started
+		pill  string
+	}{
+		{
+			name:  "higher priority",
+			procs: []started{{"zzgame", "zzgame", 0, false}, {"zzbench", "zzbench --run", 0, true}},
+			pill:  "bench",
+		},
+		{
+			name:  "oldest among the same priority",
+			procs: []started{{"zzgame", "zzgame", 1000, true}, {"zzstream", "zzstream", 0, false}},
+			pill:  "game",
+		},
+		{
+			name:  "excluded process first",
+			procs: []started{{"zzgame", "zzgame --server", 5000, false}, {"zzgame", "zzgame", 0, true}},
+			pill:  "game",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, reversed := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s reversed %v", tt.name, reversed), func(t *testing.T) {
+				pm, source := newFakeManager(t, priorityConfig)
+				want := int32(0)
+				for i, proc := range tt.procs {
+					pid := int32(100 + 100*i)
+					if reversed {
+						pid = int32(100 + 100*(len(tt.procs)-1-i))
+					}
+					p := source.spawn(pid, 50, proc.name, proc.cmdline)
+					p.created -= proc.age
+					if proc.trigger {
+						want = pid
+					}
+				}
+				expectPill(t, pm, tt.pill, want)
+			})
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Time given to the pills to be reverted when the daemon stops
+const shutdownTimeout = 10 * time.Second
+
+// Reverts everything the pills applied, then closes the connections the revert needs.
+// The focus boost and the process-scoped pills go first, then the default pill reverts the
+// global actions in the reverse order they were applied, then the per-process settings, and
+// clears the journal. Past the deadline, the daemon exits without waiting for the revert still
+// running, and the journal is left for the next start to recover from.
+func (pm *PillManager) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	pm.shutdownDone = done
+	go func() {
+		defer close(done)
+		pm.clearFocusBoost()
+		pm.restoreScoped()
+		pm.eatPill(nil, pm.defaultPill, reasonShutdown)
+		pm.flushTree()
+		pm.saveProcCache()
+	}()
+
+	select {
+	case <-done:
+		pm.Close()
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("reverting the pills took too long, the journal is kept for the next start: %w", ctx.Err())
+	}
+}
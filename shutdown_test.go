@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The connections stay open for a revert outlasting the deadline
+func TestShutdownTimeout(t *testing.T) {
+	pm, _ := newFakeManager(t, fakeConfig)
+	path, err := runStateFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A syscall of the tree hanging, like on a process the kernel is slow to change
+	release := make(chan struct{})
+	pm.queueTreeJob(func() func() {
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pm.Shutdown(ctx); err == nil {
+		t.Fatal("shutdown succeeded while the revert hung")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("closed while the revert was running: %v", err)
+	}
+
+	// The daemon exits with the revert running, the next start recovers from the journal
+	close(release)
+	<-pm.shutdownDone
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("closed after the deadline: %v", err)
+	}
+}
+
+const shutdownConfig = `
+scan_interval: 1
+triggers:
+  zzgame: game
+  zzhelper: helper
+pills:
+  default: {scx: rusty, tuned: balanced}
+  game: {scx: lavd, ppd: performance, irq_affinity: "nvme*=8-15", rlimits: "nofile=524288", gamescope: "fps_limit=60", nice: "=-5", timer_slack: 50us}
+  helper: {scope: process, nice: 10}
+`
+
+// A shutdown with everything applied reverts all of it: the focus boost and the process-scoped
+// pills, the global actions from the last applied, then the processes of the tree and the state files
+func TestShutdownRestoresEverything(t *testing.T) {
+	pm, source := newFakeManager(t, shutdownConfig)
+	calls := new(callLog)
+	sys := newFakeSystem(t, calls)
+	pm.backend = fakeBackend{calls}
+	pm.dryRun = false
+	pm.eatPill(nil, pm.defaultPill, reasonStartup)
+
+	// The game, a child in its group and one that left it
+	sys.proc(source.spawn(100, 50, "zzgame", "zzgame"), 100, 0, 50000)
+	sys.proc(source.spawn(101, 100, "zzgame-render", "zzgame --render"), 100, 0, 50000)
+	sys.proc(source.spawn(102, 100, "crashpad", "crashpad_handler"), 102, 3, 60000)
+	expectPill(t, pm, "game", 100)
+	pm.flushTree()
+
+	// A process-scoped pill on a helper, a focus boost
+	sys.proc(source.spawn(200, 1, "zzhelper", "zzhelper"), 200, 0, 50000)
+	sys.proc(source.spawn(300, 1, "editor", "editor"), 300, -5, 50000)
+	pm.focusBoosted[300] = 2
+	expectPill(t, pm, "game", 100)
+	pm.flushTree()
+
+	// The cgroup of the game bound to a NUMA node, the MangoHud overlay of its renderer toggled
+	mems := filepath.Join(t.TempDir(), "game.scope", "cpuset.mems")
+	if err := os.MkdirAll(filepath.Dir(mems), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mems, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pm.numaCgroup, pm.numaMems = filepath.Dir(mems), "0-1"
+	hud, err := net.Listen("unix", hudSocket(101))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hud.Close()
+	toggled := make(chan string, 1)
+	go func() {
+		conn, err := hud.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		toggled <- string(data)
+	}()
+	pm.knownProcs[101].HudToggled = true
+
+	for pid, nice := range map[int32]int{100: -5, 101: -5, 102: -5, 200: 10, 300: -5} {
+		if sys.nice[pid] != nice {
+			t.Fatalf("PID %d at nice %d before the shutdown, want %d (calls %q)", pid, sys.nice[pid], nice, calls.list())
+		}
+	}
+	if pm.reniceGroup != 100 || sys.slack(100) != "50000" || sys.slack(102) != "50000" {
+		t.Fatalf("group %d, slacks %s and %s, want the group of the game and a slack of 50us", pm.reniceGroup, sys.slack(100), sys.slack(102))
+	}
+	journal, err := journalFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(journal); err != nil {
+		t.Fatalf("no journal for the game: %v", err)
+	}
+
+	calls.reset()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pm.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"nice 300 2",
+		"nice 200 0",
+		"revert gamescope",
+		"revert rlimits",
+		"revert irq_affinity",
+		"revert ppd",
+		"revert scx",
+		"apply scx rusty",
+		"revert tuned",
+		"nice group 100 0",
+		"nice 102 3",
+	}
+	if got := calls.list(); !slices.Equal(got, want) {
+		t.Errorf("shutdown calls\n%q\nwant\n%q", got, want)
+	}
+	for pid, nice := range map[int32]int{100: 0, 101: 0, 102: 3, 200: 0, 300: 2} {
+		if sys.nice[pid] != nice {
+			t.Errorf("PID %d left at nice %d, want %d", pid, sys.nice[pid], nice)
+		}
+	}
+	for pid, slack := range map[int32]string{100: "50000", 101: "50000", 102: "60000"} {
+		if sys.slack(pid) != slack {
+			t.Errorf("PID %d left with a timer slack of %s, want %s", pid, sys.slack(pid), slack)
+		}
+	}
+	if data, _ := os.ReadFile(mems); string(data) != "0-1\n" {
+		t.Errorf("cpuset.mems left at %q, want 0-1", data)
+	}
+	select {
+	case command := <-toggled:
+		if command != ":hud;" {
+			t.Errorf("MangoHud got %q, want :hud;", command)
+		}
+	case <-time.After(time.Second):
+		t.Error("the MangoHud overlay wasn't toggled back")
+	}
+	if !maps.Equal(pm.applied, map[string]string{"scx": "rusty", "tuned": "balanced"}) {
+		t.Errorf("applied %v, want the default pill", pm.applied)
+	}
+	if len(pm.focusBoosted) != 0 || len(pm.scoped) != 0 {
+		t.Errorf("boosts %v and scoped pills %v left", pm.focusBoosted, pm.scoped)
+	}
+
+	runState, err := runStateFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{journal, runState} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left after the shutdown: %v", path, err)
+		}
+	}
+}
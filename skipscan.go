@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+	"time"
+)
+
+// Interval between the full scans while nothing changes, when not configured
+const defaultFullScanInterval = 30 * time.Second
+
+// Sources listing the PIDs on their own, cheaper than reading every process
+type pidLister interface {
+	Pids() ([]int32, error)
+}
+
+// Hashes a listing of PIDs, which changes as soon as a process starts or exits
+func pidSignature(pids []int32) uint64 {
+	slices.Sort(pids)
+	h := fnv.New64a()
+	buf := make([]byte, 4)
+	for _, pid := range pids {
+		binary.LittleEndian.PutUint32(buf, uint32(pid))
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
+
+// Whether the scan is skipped, as no process started or exited since the last full scan, which
+// left nothing to check again. On an idle desktop, most scans would only find the same processes.
+// The full scan still runs every full_scan_interval, in case something changed unnoticed.
+func (pm *PillManager) scanUnchanged() bool {
+	lister, ok := pm.source.(pidLister)
+	if !ok {
+		return false
+	}
+	pids, err := lister.Pids()
+	if err != nil {
+		pm.scanSettled = false
+		return false
+	}
+
+	signature := pidSignature(pids)
+	if signature != pm.scanSignature || !pm.scanSettled || pm.recheckDue() || time.Since(pm.fullScanAt) >= pm.fullScan {
+		pm.scanSignature = signature
+		pm.scanSettled = false
+		pm.fullScanAt = time.Now()
+		return false
+	}
+
+	// The listing misses a trigger replaced by a process with its PID, or left a zombie
+	if state, _ := pm.triggerState(); pm.currentProc > 0 && !state.triggerRunning() {
+		pm.scanSettled = false
+		return false
+	}
+
+	if !pm.scanSkipping {
+		Logger.Debugf("No process started or exited, skipping the scans until one does")
+		pm.scanSkipping = true
+	}
+	return true
+}
+
+// Whether something waits on time rather than on processes, and has to be checked by every scan
+func (pm *PillManager) recheckDue() bool {
+	// Pending switches, deferred processes and trees only renicing part of their processes
+	if pm.pending.winner != "" || pm.guardedPill != "" || pm.treeQueued > 0 || len(pm.treeBacklog) > 0 || len(pm.gpuWaiting) > 0 || len(pm.hudWaiting) > 0 {
+		return true
+	}
+	for _, threshold := range pm.pending.exhausted {
+		if threshold > 0 {
+			return true
+		}
+	}
+
+	// The limits of the pill, and the nice values enforced against other tools
+	if pm.pendingValid() && (pm.pending.maxDuration > 0 || pm.pending.idleRevert > 0) {
+		return true
+	}
+	return pm.getTreeSettings(pm.CurrentPill).enforceNice
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Every file written by the daemon goes through these helpers, so that they all get
+// private permissions, and can't be redirected through symlinks or files of other users.
+
+// Directory for the files that survive reboots
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "process_pillz"), nil
+}
+
+// Directory for the files that only make sense while the session runs
+func runtimeDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return filepath.Join(dir, "process_pillz"), nil
+}
+
+// Checks that a path is not a symlink and is owned by the current user
+func checkOwned(info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symlink", info.Name())
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if stat.Uid != uint32(os.Getuid()) {
+			return fmt.Errorf("%s is not owned by the current user", info.Name())
+		}
+	}
+
+	return nil
+}
+
+// Creates a directory readable only by the current user, or checks an existing one
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := checkOwned(info); err != nil {
+		return fmt.Errorf("refusing to use directory %s: %v", dir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("refusing to use %s: not a directory", dir)
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return os.Chmod(dir, 0700)
+	}
+
+	return nil
+}
+
+// Atomically replaces a file with the given content, readable only by the current user
+func writeStateFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := ensurePrivateDir(dir); err != nil {
+		return err
+	}
+
+	// Never write through a file that isn't ours
+	if info, err := os.Lstat(path); err == nil {
+		if err := checkOwned(info); err != nil {
+			return fmt.Errorf("refusing to write %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// Reads a file written by writeStateFile
+func readStateFile(path string) ([]byte, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOwned(info); err != nil {
+		return nil, fmt.Errorf("refusing to read %s: %v", path, err)
+	}
+
+	return io.ReadAll(file)
+}
+
+// Removes a file written by writeStateFile, if it exists
+func removeStateFile(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A file of another user, as Lstat would describe it
+type foreignFile struct{ uid uint32 }
+
+func (f foreignFile) Name() string       { return "state.json" }
+func (f foreignFile) Size() int64        { return 0 }
+func (f foreignFile) Mode() os.FileMode  { return 0600 }
+func (f foreignFile) ModTime() time.Time { return time.Time{} }
+func (f foreignFile) IsDir() bool        { return false }
+func (f foreignFile) Sys() any           { return &syscall.Stat_t{Uid: f.uid} }
+
+func TestWriteStateFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "process_pillz")
+	path := filepath.Join(dir, "state.json")
+	if err := writeStateFile(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, perm := range map[string]os.FileMode{dir: 0700, path: 0600} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("%s has mode %o, want %o", file, info.Mode().Perm(), perm)
+		}
+	}
+	if data, err := readStateFile(path); err != nil || string(data) != "{}" {
+		t.Errorf("read %q, %v, want {}", data, err)
+	}
+
+	// A directory opened to the others is made private again
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		t.Errorf("directory left with mode %o", info.Mode().Perm())
+	}
+}
+
+func TestStateFileSymlink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "process_pillz")
+	if err := ensurePrivateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another user's file, which a symlink in the state directory would have the daemon overwrite
+	target := filepath.Join(t.TempDir(), "bashrc")
+	if err := os.WriteFile(target, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "state.json")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeStateFile(path, []byte("{}")); err == nil || !strings.Contains(err.Error(), "is a symlink") {
+		t.Errorf("wrote through a symlink: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Errorf("the symlink target was changed to %q", data)
+	}
+	if _, err := readStateFile(path); err == nil {
+		t.Error("read through a symlink")
+	}
+
+	// Nor is the directory itself followed
+	linked := filepath.Join(t.TempDir(), "process_pillz")
+	if err := os.Symlink(dir, linked); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(filepath.Join(linked, "stats.json"), []byte("{}")); err == nil || !strings.Contains(err.Error(), "refusing to use directory") {
+		t.Errorf("wrote in a symlinked directory: %v", err)
+	}
+}
+
+func TestStateFileOwner(t *testing.T) {
+	if err := checkOwned(foreignFile{uid: uint32(os.Getuid())}); err != nil {
+		t.Errorf("own file refused: %v", err)
+	}
+	if err := checkOwned(foreignFile{uid: uint32(os.Getuid()) + 1}); err == nil || !strings.Contains(err.Error(), "not owned by the current user") {
+		t.Errorf("file of another user accepted: %v", err)
+	}
+
+	// With a file really owned by someone else, when the tests may chown it
+	if os.Getuid() != 0 {
+		return
+	}
+	dir := filepath.Join(t.TempDir(), "process_pillz")
+	path := filepath.Join(dir, "state.json")
+	if err := writeStateFile(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(path, 65534, 65534); err != nil {
+		t.Skipf("can't chown: %v", err)
+	}
+	if err := writeStateFile(path, []byte("[]")); err == nil {
+		t.Error("replaced a file of another user")
+	}
+	if _, err := readStateFile(path); err == nil {
+		t.Error("read a file of another user")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Number of pill transitions within a minute above which the pills are considered flapping
+const defaultFlapThreshold = 6
+
+// Counters of a pill
+type PillStats struct {
+	Activations int           `json:"activations"`
+	ActiveTime  time.Duration `json:"active_time"`
+}
+
+// Path of the file where the pill counters are persisted
+func statsFilePath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stats.json"), nil
+}
+
+// Loads the persisted pill counters, if enabled
+func (pm *PillManager) loadStats() {
+	if !pm.persistStats {
+		return
+	}
+
+	path, err := statsFilePath()
+	if err != nil {
+		Logger.Warnf("Couldn't find the stats file : %v", err)
+		return
+	}
+
+	data, err := readStateFile(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		Logger.Warnf("Couldn't read the stats file %s : %v", path, err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &pm.stats); err != nil {
+		Logger.Warnf("Ignoring invalid stats file %s : %v", path, err)
+		pm.stats = make(map[string]*PillStats)
+	}
+}
+
+// Persists the pill counters, if enabled
+func (pm *PillManager) saveStats() {
+	if !pm.persistStats {
+		return
+	}
+
+	path, err := statsFilePath()
+	if err != nil {
+		Logger.Warnf("Couldn't find the stats file : %v", err)
+		return
+	}
+
+	data, err := json.Marshal(pm.pillStats())
+	if err != nil {
+		Logger.Warnf("Couldn't encode the stats : %v", err)
+		return
+	}
+
+	if err := writeStateFile(path, data); err != nil {
+		Logger.Warnf("Couldn't write the stats file %s : %v", path, err)
+	}
+}
+
+// Returns the pill counters, including the time spent in the current pill so far
+func (pm *PillManager) pillStats() map[string]PillStats {
+	stats := make(map[string]PillStats, len(pm.stats))
+	for name, s := range pm.stats {
+		stats[name] = *s
+	}
+
+	if pm.CurrentPill != "" {
+		s := stats[pm.CurrentPill]
+		s.ActiveTime += time.Since(pm.pillSince)
+		stats[pm.CurrentPill] = s
+	}
+
+	return stats
+}
+
+// Updates the counters when leaving the current pill for a new one, and warns about flapping
+func (pm *PillManager) recordTransition(pillName string) {
+	now := time.Now()
+
+	if pm.CurrentPill != "" {
+		pm.getStats(pm.CurrentPill).ActiveTime += now.Sub(pm.pillSince)
+	}
+	pm.getStats(pillName).Activations++
+
+	// Keeping the transitions of the last minute only
+	pm.transitions = slices.DeleteFunc(pm.transitions, func(t time.Time) bool {
+		return now.Sub(t) > time.Minute
+	})
+	pm.transitions = append(pm.transitions, now)
+
+	if pm.flapThreshold > 0 && len(pm.transitions) > pm.flapThreshold {
+		if now.Sub(pm.flapWarnedAt) > time.Minute {
+			Logger.Warnf("Pills are flapping (%d transitions in the last minute), consider making triggers more specific or using max_duration/revert_if_idle", len(pm.transitions))
+			pm.flapWarnedAt = now
+		}
+	}
+}
+
+func (pm *PillManager) getStats(pillName string) *PillStats {
+	s, exists := pm.stats[pillName]
+	if !exists {
+		s = &PillStats{}
+		pm.stats[pillName] = s
+	}
+	return s
+}
+
+// Logs the current state and the pill counters
+func (pm *PillManager) logStatus() {
+	Logger.Infof("Current pill %s (trigger %d, parent %d) for %s", pm.CurrentPill, pm.currentProc, pm.currentParent, time.Since(pm.pillSince).Round(time.Second))
+
+	if pm.procHidden {
+		Logger.Warn(hiddenProcsMessage())
+	}
+
+	if len(pm.groups) > 0 {
+		if pm.currentProc != 0 {
+			Logger.Infof("Global pill owned by group %s", groupName(pm.triggerGroup))
+		}
+		for _, root := range sortedKeys(pm.scoped) {
+			Logger.Infof("Pill %s on process %d, per-process options only", pm.scoped[root].pill, root)
+		}
+	}
+
+	if pending := pm.pendingSwitch(); pending != "" {
+		Logger.Infof("Pending switch to %s", pending)
+	}
+
+	if len(pm.treeBacklog) > 0 {
+		Logger.Infof("%d processes of the tree wait for the next scan, over renice_budget", len(pm.treeBacklog))
+	}
+
+	if pm.lastEvent != nil {
+		Logger.Infof("Last transition at %s: %s", pm.lastEvent.Time.Format(time.TimeOnly), pm.lastEvent)
+	}
+
+	for _, name := range pm.sortedDisabledActions() {
+		Logger.Infof("Action %s disabled (%s)", name, pm.disabledActions[name])
+	}
+
+	// What flapping triggers cost, the buffers stay the same size
+	if pm.rateDeferred > 0 {
+		Logger.Infof("%d transitions deferred by the rate limit", pm.rateDeferred)
+	}
+	if logBuffer != nil {
+		if dropped := logBuffer.droppedLines(); dropped > 0 {
+			Logger.Infof("%d log lines dropped from the buffer of the logs command", dropped)
+		}
+	}
+
+	stats := pm.pillStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		Logger.Infof("  %s: %d activations, active for %s", name, stats[name].Activations, stats[name].ActiveTime.Round(time.Second))
+	}
+}
+
+// Counters of the daemon, the reply of the status command
+type StatusReport struct {
+	Pill            string               `json:"pill"`
+	Trigger         int32                `json:"trigger,omitempty"`
+	ActiveFor       time.Duration        `json:"active_for"`
+	Pills           map[string]PillStats `json:"pills"`
+	Transitions     int                  `json:"transitions_last_minute"`
+	RateDeferred    int                  `json:"rate_deferred"`
+	LogLinesDropped uint64               `json:"log_lines_dropped"`
+	DisabledActions map[string]string    `json:"disabled_actions,omitempty"`
+}
+
+func (pm *PillManager) statusReport() StatusReport {
+	report := StatusReport{
+		Pill:            pm.CurrentPill,
+		Trigger:         pm.currentProc,
+		ActiveFor:       time.Since(pm.pillSince),
+		Pills:           pm.pillStats(),
+		RateDeferred:    pm.rateDeferred,
+		DisabledActions: pm.disabledActions,
+	}
+	for _, t := range pm.transitions {
+		if time.Since(t) <= time.Minute {
+			report.Transitions++
+		}
+	}
+	if logBuffer != nil {
+		report.LogLinesDropped = logBuffer.droppedLines()
+	}
+	return report
+}
+
+// Answers the status command with the current pill and the counters
+func (pm *PillManager) status(asJSON bool) string {
+	report := pm.statusReport()
+	if asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return controlError + err.Error() + "\n"
+		}
+		return string(data) + "\n"
+	}
+
+	var b strings.Builder
+	if report.Trigger == 0 {
+		fmt.Fprintf(&b, "Current pill: %s, for %s\n", report.Pill, report.ActiveFor.Round(time.Second))
+	} else {
+		fmt.Fprintf(&b, "Current pill: %s, for %s (trigger %d)\n", report.Pill, report.ActiveFor.Round(time.Second), report.Trigger)
+	}
+
+	fmt.Fprintf(&b, "Pills:\n")
+	for _, name := range sortedKeys(report.Pills) {
+		fmt.Fprintf(&b, "  %s: %d activations, active for %s\n", name, report.Pills[name].Activations, report.Pills[name].ActiveTime.Round(time.Second))
+	}
+
+	fmt.Fprintf(&b, "Transitions in the last minute: %d\n", report.Transitions)
+	fmt.Fprintf(&b, "Transitions deferred by the rate limit: %d\n", report.RateDeferred)
+	fmt.Fprintf(&b, "Log lines dropped from the buffer of the logs command: %d\n", report.LogLinesDropped)
+	for _, name := range pm.sortedDisabledActions() {
+		fmt.Fprintf(&b, "Action %s disabled (%s)\n", name, report.DisabledActions[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	pm, source := newFakeManager(t, fakeConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+	pm.rateDeferred = 3
+	pm.disabledActions["scx"] = "denied"
+
+	var report StatusReport
+	if err := json.Unmarshal([]byte(pm.handleControl([]string{"status", "--json"})), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Pill != "default" || report.Pills["game"].Activations != 1 || report.Pills["default"].Activations != 2 {
+		t.Errorf("status %+v, want the default pill after one game", report)
+	}
+	if report.Transitions != 3 || report.RateDeferred != 3 || report.DisabledActions["scx"] != "denied" {
+		t.Errorf("status %+v, want 3 transitions, 3 deferred and scx disabled", report)
+	}
+
+	text := pm.handleControl([]string{"status"})
+	for _, want := range []string{"Current pill: default, for 0s\n", "  game: 1 activations", "Transitions deferred by the rate limit: 3\n", "Action scx disabled (denied)\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("status %q, want it to hold %q", text, want)
+		}
+	}
+}
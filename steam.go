@@ -0,0 +1,77 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Ancestors where the walk up from a trigger stops, they are shared by everything they launch
+var launcherRoots = []string{"systemd", "steam"}
+
+// Maximum number of ancestors checked when looking for the Steam processes
+const maxAncestors = 16
+
+// Whether a process is the Steam reaper launching a game
+func isSteamReaper(name string, cmdline string) bool {
+	return name == "reaper" && strings.Contains(cmdline, "SteamLaunch")
+}
+
+// Whether a process is one of the pressure-vessel wrappers of the Steam Linux Runtime.
+// The kernel truncates process names to 15 characters.
+func isPressureVessel(name string) bool {
+	return strings.HasPrefix(name, "pressure-vessel") || name == "pv-adverb"
+}
+
+// Finds the root of a Steam game's tree. Steam runs games as
+// steam -> reaper SteamLaunch AppId=... -> pressure-vessel-wrap -> ... -> game.
+// The reaper is preferred while it exists, and the outermost pressure-vessel wrapper
+// adopts the game when the reaper exited early.
+func (pm *PillManager) steamTreeRoot(p Proc) (int32, bool) {
+	var wrapper int32
+	current := p
+	for range maxAncestors {
+		parent, err := current.Parent()
+		if err != nil {
+			break
+		}
+
+		name, err := parent.Name()
+		if err != nil || slices.Contains(launcherRoots, name) {
+			break
+		}
+
+		cmdline, _ := parent.Cmdline()
+		if isSteamReaper(name, cmdline) {
+			return parent.PID(), true
+		}
+		if isPressureVessel(name) {
+			wrapper = parent.PID()
+		}
+		current = parent
+	}
+
+	return wrapper, wrapper != 0
+}
+
+// Finds the tree root again when the current one exited, instead of losing the tree
+func (pm *PillManager) checkTreeRoot(p Proc) {
+	if pm.currentParent <= 0 || pm.currentScan[pm.currentParent] {
+		return
+	}
+
+	previous := pm.currentParent
+	pm.currentParent = pm.getValidParent(p)
+	Logger.Infof("Tree root %d exited, handing off to %d", previous, pm.currentParent)
+}
+
+// Returns the Steam AppID of the current trigger, from its command line or the reaper's
+func (pm *PillManager) currentSteamAppID() string {
+	for _, pid := range []int32{pm.currentProc, pm.currentParent} {
+		if procInfo, exists := pm.knownProcs[pid]; exists {
+			if appID := steamAppID(procInfo.Cmdline); appID != "" {
+				return appID
+			}
+		}
+	}
+	return ""
+}
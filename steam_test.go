@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+// Command lines of a Proton game launched by Steam through the Steam Linux Runtime, as read
+// from /proc. The kernel truncates pressure-vessel-wrap to pressure-vessel in the names.
+const (
+	steamCmdline   = "/home/user/.local/share/Steam/ubuntu12_32/steam -srt-logger-opened"
+	reaperCmdline  = "/home/user/.local/share/Steam/ubuntu12_32/reaper SteamLaunch AppId=1245620 -- /home/user/.local/share/Steam/ubuntu12_32/steam-launch-wrapper -- /home/user/.local/share/Steam/steamapps/common/SteamLinuxRuntime_sniper/_v2-entry-point --verb=waitforexitandrun -- /home/user/.local/share/Steam/steamapps/common/Proton 9.0 (Beta)/proton waitforexitandrun /home/user/.local/share/Steam/steamapps/common/ELDEN RING/Game/start_protected_game.exe"
+	wrapperCmdline = "/home/user/.local/share/Steam/ubuntu12_32/steam-launch-wrapper -- /home/user/.local/share/Steam/steamapps/common/SteamLinuxRuntime_sniper/_v2-entry-point --verb=waitforexitandrun -- /home/user/.local/share/Steam/steamapps/common/Proton 9.0 (Beta)/proton waitforexitandrun /home/user/.local/share/Steam/steamapps/common/ELDEN RING/Game/start_protected_game.exe"
+	pvWrapCmdline  = "/home/user/.local/share/Steam/steamapps/common/SteamLinuxRuntime_sniper/pressure-vessel/bin/pressure-vessel-wrap --batch --filesystem=/home/user/.local/share/Steam --variable-dir=/home/user/.local/share/Steam/steamapps/common/SteamLinuxRuntime_sniper/var --runtime=/home/user/.local/share/Steam/steamapps/common/SteamLinuxRuntime_sniper/sniper_platform_3.0.20240916.101795 -- /home/user/.local/share/Steam/steamapps/common/Proton 9.0 (Beta)/proton waitforexitandrun"
+	pvAdverbCmd    = "/usr/lib/pressure-vessel/from-host/libexec/steam-runtime-tools-0/pv-adverb --exit-with-parent --subreaper --assign-fd=1000=1 -- /home/user/.local/share/Steam/steamapps/common/Proton 9.0 (Beta)/proton waitforexitandrun"
+	gameCmdline    = "Z:\\home\\user\\.local\\share\\Steam\\steamapps\\common\\ELDEN RING\\Game\\eldenring.exe"
+
+	// A native game, whose launch script the reaper runs without the runtime
+	nativeReaper = "/home/user/.local/share/Steam/ubuntu12_32/reaper SteamLaunch AppId=570 -- /home/user/.local/share/Steam/ubuntu12_32/steam-launch-wrapper -- /home/user/.local/share/Steam/steamapps/common/dota 2 beta/game/dota.sh +engine_experimental_drop_frame_ticks 1"
+)
+
+const steamConfig = `
+scan_interval: 1
+triggers:
+  eldenring.exe: game
+  dota2: game
+pills:
+  default: {scx: rusty}
+  game: {nice: -5}
+`
+
+// Starts the chain Steam runs an Elden Ring with, and returns the PID of the game
+func spawnSteamGame(source *fakeSource) int32 {
+	source.spawn(10, 1, "steam", steamCmdline)
+	source.spawn(20, 10, "reaper", reaperCmdline)
+	source.spawn(21, 20, "steam-launch-wr", wrapperCmdline)
+	source.spawn(30, 21, "pressure-vessel", pvWrapCmdline)
+	source.spawn(31, 30, "pv-adverb", pvAdverbCmd)
+	source.spawn(40, 31, "eldenring.exe", gameCmdline)
+	return 40
+}
+
+func TestSteamReaperRoot(t *testing.T) {
+	pm, source := newFakeManager(t, steamConfig)
+	game := spawnSteamGame(source)
+	expectPill(t, pm, "game", game)
+	if pm.currentParent != 20 {
+		t.Errorf("tree root %d (%s), want the reaper", pm.currentParent, pm.rootReason)
+	}
+	if pm.lastEvent.SteamAppID != "1245620" || pm.lastEvent.ParentPid != 20 {
+		t.Errorf("transition %+v, want AppID 1245620 from the reaper", pm.lastEvent)
+	}
+
+	// The reaper exiting early hands the tree to the outermost pressure-vessel wrapper
+	source.exit(20)
+	source.procs[21].ppid = 10
+	expectPill(t, pm, "game", game)
+	if pm.currentParent != 30 {
+		t.Errorf("tree root %d after the reaper exited, want pressure-vessel-wrap", pm.currentParent)
+	}
+}
+
+func TestSteamNativeGame(t *testing.T) {
+	pm, source := newFakeManager(t, steamConfig)
+	source.spawn(10, 1, "steam", steamCmdline)
+	source.spawn(20, 10, "reaper", nativeReaper)
+	source.spawn(21, 20, "steam-launch-wr", "/home/user/.local/share/Steam/ubuntu12_32/steam-launch-wrapper -- dota.sh")
+	source.spawn(22, 21, "dota.sh", "/bin/bash /home/user/.local/share/Steam/steamapps/common/dota 2 beta/game/dota.sh")
+	source.spawn(23, 22, "dota2", "/home/user/.local/share/Steam/steamapps/common/dota 2 beta/game/bin/linuxsteamrt64/dota2 +engine_experimental_drop_frame_ticks 1")
+	expectPill(t, pm, "game", 23)
+	if pm.currentParent != 20 || pm.lastEvent.SteamAppID != "570" {
+		t.Errorf("tree root %d with AppID %q, want the reaper of AppID 570", pm.currentParent, pm.lastEvent.SteamAppID)
+	}
+}
+
+func TestSteamProcessNames(t *testing.T) {
+	reapers := map[string]bool{
+		reaperCmdline: true,
+		nativeReaper:  true,
+		"/usr/bin/reaper --config /etc/reaper.conf": false, // Not Steam's
+	}
+	for cmdline, want := range reapers {
+		if got := isSteamReaper("reaper", cmdline); got != want {
+			t.Errorf("isSteamReaper(%q) = %v, want %v", cmdline, got, want)
+		}
+	}
+
+	for name, want := range map[string]bool{"pressure-vessel": true, "pressure-vessel-wrap": true, "pv-adverb": true, "steam": false, "pv": false} {
+		if got := isPressureVessel(name); got != want {
+			t.Errorf("isPressureVessel(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	if appID := steamAppID(pvWrapCmdline); appID != "" {
+		t.Errorf("AppID %q found outside of the reaper", appID)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Gap between the wall clock and the monotonic clock over a scan interval above which the
+// machine was suspended, or the clock stepped, like by NTP. The durations of the pills run on
+// the monotonic clock, which stops while the machine sleeps.
+const clockJumpThreshold = 2 * time.Second
+
+// Reads the wall clock alone, the tests step it to simulate a suspend or a clock step
+var wallClock = func() time.Time { return time.Now().Round(0) }
+
+// Subscribes to the sleep notifications of logind, and sends on the channel after each resume.
+// Without logind, the scans still notice the suspend from the clocks, only later.
+func watchSleep(conn *dbus.Conn, resumeChan chan<- struct{}) {
+	if conn == nil {
+		return
+	}
+	err := conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+		dbus.WithMatchMember("PrepareForSleep"),
+	)
+	if err != nil {
+		Logger.Debugf("Couldn't watch the sleep notifications of logind : %v", err)
+		return
+	}
+
+	// The connection sends every signal to every channel, the owner changes come here too
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+	go func() {
+		for signal := range signals {
+			if signal.Name != "org.freedesktop.login1.Manager.PrepareForSleep" || len(signal.Body) == 0 {
+				continue
+			}
+			if sleeping, _ := signal.Body[0].(bool); !sleeping {
+				select {
+				case resumeChan <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// Checks the clocks against those of the last scan, and drops the state measured before a jump
+func (pm *PillManager) checkClock() {
+	now, wall := time.Now(), wallClock()
+	last, lastWall := pm.scanClock, pm.scanWall
+	pm.scanClock, pm.scanWall = now, wall
+	if last.IsZero() {
+		return
+	}
+
+	jump := wall.Sub(lastWall) - now.Sub(last)
+	if jump > clockJumpThreshold || jump < -clockJumpThreshold {
+		pm.clockJumped("The clock jumped by " + jump.Round(time.Second).String() + " since the last scan, after a suspend or a clock step")
+	}
+}
+
+// Starts the timers measured before a suspend or a clock step again, so that the next scan
+// decides from what runs now: the idle time of the trigger, the hysteresis of the transitions
+// and the reapply_window start over.
+func (pm *PillManager) clockJumped(why string) {
+	Logger.Infof("%s, checking the triggers again", why)
+	pm.scanClock, pm.scanWall = time.Now(), wallClock()
+
+	ps := pm.pending
+	ps.idleSince = time.Time{}
+	ps.winner, ps.winnerSince = "", time.Time{}
+	pm.reverted = nil
+
+	// The scan after the jump is a full one, its matches are what counts
+	pm.scanSettled = false
+	pm.ticker.Reset(pm.scanInterval)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Moves the wall clock of the scans away from the monotonic one, like a suspend or a clock step
+func stepClock(t *testing.T, step time.Duration) {
+	previous := wallClock
+	wallClock = func() time.Time { return time.Now().Round(0).Add(step) }
+	t.Cleanup(func() { wallClock = previous })
+}
+
+// The min_stable waited before a suspend or a clock step is waited again from the first scan after it
+func TestClockJumpRestartsTimers(t *testing.T) {
+	tests := []struct {
+		step    time.Duration
+		restart bool
+	}{
+		{step: time.Hour, restart: true},
+		{step: -time.Hour, restart: true},
+		{step: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step.String(), func(t *testing.T) {
+			pm, source := newFakeManager(t, fmt.Sprintf(graceConfig, "game"))
+			logs := observeLogs(t, zapcore.InfoLevel)
+			source.spawn(100, 50, "zzgame", "zzgame")
+			expectPill(t, pm, "default", 0)
+			pm.pending.winnerSince = time.Now().Add(-29 * time.Second)
+
+			stepClock(t, tt.step)
+			expectPill(t, pm, "default", 0)
+			jumps := logs.FilterMessageSnippet("The clock jumped by").Len()
+			if waited := time.Since(pm.pending.winnerSince); tt.restart && (jumps != 1 || waited > time.Second) {
+				t.Fatalf("game has waited %s after %d clock jumps, want min_stable to start over", waited, jumps)
+			} else if !tt.restart && (jumps != 0 || waited < 29*time.Second) {
+				t.Fatalf("game has waited %s after %d clock jumps, want a drift of %s ignored", waited, jumps, tt.step)
+			}
+
+			// The scans after it keep the same clocks
+			expectPill(t, pm, "default", 0)
+			pm.pending.winnerSince = time.Now().Add(-30 * time.Second)
+			expectPill(t, pm, "game", 100)
+			if jumps := logs.FilterMessageSnippet("The clock jumped by").Len(); tt.restart && jumps != 1 {
+				t.Errorf("%d clock jumps logged, want one", jumps)
+			}
+		})
+	}
+}
+
+// A resume drops the reapply_window and makes the next scan a full one
+func TestResumeRestartsTimers(t *testing.T) {
+	pm, source := newFakeManager(t, reapplyConfig)
+	source.spawn(100, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 100)
+	source.exit(100)
+	expectPill(t, pm, "default", 0)
+	expectPill(t, pm, "default", 0)
+	if !pm.scanSettled {
+		t.Fatal("the scans don't settle without a trigger")
+	}
+
+	pm.clockJumped("Resumed from suspend")
+	pm.ticker.Stop()
+	if pm.scanSettled || pm.reverted != nil {
+		t.Fatalf("settled %t with the reverted pill %v, want a full scan and no reapply_window", pm.scanSettled, pm.reverted)
+	}
+
+	// The trigger back after the resume eats the whole pill
+	source.spawn(101, 50, "zzgame", "zzgame")
+	expectPill(t, pm, "game", 101)
+	if pm.lastEvent.Resume != nil {
+		t.Errorf("transition %v, want no resume across a suspend", pm.lastEvent)
+	}
+}
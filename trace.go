@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Inputs and outcome of the decision of a scan, logged with --debug-decisions
+type decisionTrace struct {
+	Time         time.Time    `json:"time"`
+	Pill         string       `json:"pill"`
+	TriggerPid   int32        `json:"trigger_pid"`
+	TriggerAlive bool         `json:"trigger_alive"`
+	Matches      []traceMatch `json:"matches"`
+	Exhausted    []int32      `json:"exhausted,omitempty"`
+	Suppressor   string       `json:"suppressor,omitempty"`
+	PillAge      string       `json:"pill_age"`
+	MaxDuration  string       `json:"max_duration,omitempty"`
+	IdleRevert   string       `json:"revert_if_idle,omitempty"`
+	IdleFor      string       `json:"idle_for,omitempty"`
+	Pending      string       `json:"pending_switch,omitempty"`
+	TreeBacklog  int          `json:"tree_backlog,omitempty"` // Processes of the tree left to the next scan by renice_budget
+	Decision     string       `json:"decision"`
+}
+
+// A process matching a trigger during the scan
+type traceMatch struct {
+	Pid     int32  `json:"pid"`
+	Trigger string `json:"trigger"`
+	Pill    string `json:"pill"`
+}
+
+// Starts the trace of a scan, or returns nil if decisions are not traced
+func (pm *PillManager) newTrace(triggerAlive bool) *decisionTrace {
+	if !pm.traceDecisions {
+		return nil
+	}
+
+	return &decisionTrace{
+		Time:         time.Now(),
+		Pill:         pm.CurrentPill,
+		TriggerPid:   pm.currentProc,
+		TriggerAlive: triggerAlive,
+		Matches:      []traceMatch{},
+	}
+}
+
+func (t *decisionTrace) match(pid int32, trigger string, pill string) {
+	if t == nil {
+		return
+	}
+	t.Matches = append(t.Matches, traceMatch{Pid: pid, Trigger: trigger, Pill: pill})
+}
+
+// Completes the trace with the timers in effect and the decision, and logs it
+func (pm *PillManager) logTrace(t *decisionTrace, decision string) {
+	if t == nil {
+		return
+	}
+
+	for pid := range pm.pending.exhausted {
+		t.Exhausted = append(t.Exhausted, pid)
+	}
+	slices.Sort(t.Exhausted)
+	t.Suppressor = pm.suppressedBy
+	t.PillAge = time.Since(pm.pillSince).Round(time.Second).String()
+	if ps := pm.pending; pm.pendingValid() {
+		if ps.maxDuration > 0 {
+			t.MaxDuration = ps.maxDuration.String()
+		}
+		if ps.idleRevert > 0 {
+			t.IdleRevert = ps.idleRevert.String()
+			if !ps.idleSince.IsZero() {
+				t.IdleFor = time.Since(ps.idleSince).Round(time.Second).String()
+			}
+		}
+	}
+	t.Pending = pm.pendingSwitch()
+	t.TreeBacklog = len(pm.treeBacklog)
+	t.Decision = decision
+
+	if data, err := json.Marshal(t); err == nil {
+		Logger.Debugf("Decision %s", data)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Reasons of a pill transition
+const (
+	reasonTrigger     = "trigger"
+	reasonTriggerGone = "trigger_gone"
+	reasonSuppressed  = "suppressed"
+	reasonMaxDuration = "max_duration"
+	reasonIdle        = "idle"
+	reasonStartup     = "startup"
+	reasonShutdown    = "shutdown"
+	reasonReload      = "reload"
+	reasonRecovery    = "recovery"
+	reasonOnExit      = "on_exit"
+	reasonAudit       = "audit"
+	reasonManual      = "manual"
+)
+
+// Outcome of a pill action
+type ActionResult struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// Outcome of the restore of what the previous pill changed, counted per item: IRQs, limits,
+// gamescope options or processes
+type RestoreResult struct {
+	Name     string `json:"name"`
+	Restored int    `json:"restored"`
+	Failed   int    `json:"failed,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// A pill transition, shared by everything reporting transitions so that they all agree
+type TransitionEvent struct {
+	Time           time.Time       `json:"time"`
+	Reason         string          `json:"reason"`
+	FromPill       string          `json:"from_pill"`
+	ToPill         string          `json:"to_pill"`
+	TriggerPid     int32           `json:"trigger_pid,omitempty"`
+	TriggerName    string          `json:"trigger_name,omitempty"`
+	TriggerCmdline string          `json:"trigger_cmdline,omitempty"`
+	SteamAppID     string          `json:"steam_app_id,omitempty"`
+	ParentPid      int32           `json:"parent_pid,omitempty"`
+	Actions        []ActionResult  `json:"actions"`
+	Restores       []RestoreResult `json:"restores,omitempty"`
+	Resume         *ResumeInfo     `json:"resume,omitempty"`  // Set when the pill is back within reapply_window
+	Skipped        []string        `json:"skipped,omitempty"` // Deferred pills replaced by this one before they were eaten
+}
+
+var steamAppIDPattern = regexp.MustCompile(`(?:^|\s)AppId=(\d+)`)
+
+// Returns the Steam AppID found in a command line, if any
+func steamAppID(cmdline string) string {
+	if match := steamAppIDPattern.FindStringSubmatch(cmdline); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+func (ev *TransitionEvent) addAction(name string, value string, err error) {
+	result := ActionResult{Name: name, Value: value}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	ev.Actions = append(ev.Actions, result)
+}
+
+// Records a restore, err being the last failure. Nothing to restore records nothing.
+func (ev *TransitionEvent) addRestore(name string, restored int, failed int, err error) {
+	if restored == 0 && failed == 0 {
+		return
+	}
+	result := RestoreResult{Name: name, Restored: restored, Failed: failed}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	ev.Restores = append(ev.Restores, result)
+}
+
+// Whether every action of the transition succeeded
+func (ev *TransitionEvent) Succeeded() bool {
+	for _, action := range ev.Actions {
+		if action.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether everything the previous pill changed was restored
+func (ev *TransitionEvent) FullyRestored() bool {
+	for _, restore := range ev.Restores {
+		if restore.Failed > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// JSON form of the transition. The actions are a list even when there are none.
+func (ev *TransitionEvent) JSON() ([]byte, error) {
+	event := *ev
+	if event.Actions == nil {
+		event.Actions = []ActionResult{}
+	}
+	return json.Marshal(&event)
+}
+
+// Short human readable form of the transition
+func (ev *TransitionEvent) String() string {
+	s := fmt.Sprintf("%s -> %s (%s)", ev.FromPill, ev.ToPill, ev.Reason)
+	if ev.TriggerPid != 0 {
+		s += fmt.Sprintf(" trigger %s (PID %d)", ev.TriggerName, ev.TriggerPid)
+	}
+	if ev.Resume != nil {
+		s += fmt.Sprintf(" resumed after %s", ev.Resume.After)
+	}
+	if len(ev.Skipped) > 0 {
+		s += fmt.Sprintf(" skipping the deferred %s", strings.Join(ev.Skipped, ", "))
+	}
+	if !ev.Succeeded() {
+		s += " with failed actions"
+	}
+	if !ev.FullyRestored() {
+		s += " with failed restores"
+	}
+	return s
+}
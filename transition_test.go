@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// A trigger crash-looping every scan, without a rate limit to hold it back, leaves the memory
+// of the daemon where it was
+func TestFlappingStaysBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("10k transitions")
+	}
+
+	pm, source := newFakeManager(t, `
+scan_interval: 1
+max_transitions: 0
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {scx: lavd, nice: 5}
+`)
+
+	const ringSize = 100
+	ring := newLogRing(ringSize)
+	previous := Logger
+	Logger = zap.New(zapcore.NewNopCore(), ring.tee()).Sugar()
+	t.Cleanup(func() { Logger = previous })
+
+	flap := func(transitions int) {
+		for i := range transitions {
+			pid := int32(1000 + i%30000)
+			if pm.CurrentPill == pm.defaultPill {
+				source.spawn(pid, 50, "zzgame", "zzgame --crash-loop")
+			} else {
+				source.exit(pm.currentProc)
+			}
+			pm.scanProcesses()
+		}
+	}
+
+	// The first transitions size the maps and the ring, the next ones must not grow them
+	flap(1000)
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+
+	start := time.Now()
+	const transitions = 10000
+	flap(transitions)
+	if pm.stats["game"] == nil || pm.stats["game"].Activations < transitions/2 {
+		t.Fatalf("stats %+v, the pill didn't flap %d times", pm.stats["game"], transitions/2)
+	}
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if growth := int64(after.HeapAlloc) - int64(before.HeapAlloc); growth > 2<<20 {
+		t.Errorf("heap grew by %d bytes over %d transitions", growth, transitions)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines after the transitions, %d before", n, goroutines)
+	}
+	if lines := ring.last(0); len(lines) != ringSize || ring.droppedLines() == 0 {
+		t.Errorf("%d lines in the log ring after dropping %d, want %d", len(lines), ring.droppedLines(), ringSize)
+	}
+	if len(pm.knownProcs) > len(source.procs) || len(pm.rateTimes) > 0 {
+		t.Errorf("%d known processes for %d running, %d rate limit times", len(pm.knownProcs), len(source.procs), len(pm.rateTimes))
+	}
+	t.Logf("%d transitions in %s", transitions, time.Since(start))
+}
+
+// Tools parsing the transitions logged as JSON break when a field is renamed or changes type
+func TestTransitionEventShape(t *testing.T) {
+	event := &TransitionEvent{
+		Time:           time.Date(2026, 3, 1, 20, 15, 0, 0, time.UTC),
+		Reason:         reasonTrigger,
+		FromPill:       "default",
+		ToPill:         "game",
+		TriggerPid:     4242,
+		TriggerName:    "GameThread",
+		TriggerCmdline: "reaper SteamLaunch AppId=570 -- game.exe",
+		SteamAppID:     "570",
+		ParentPid:      4200,
+		Resume:         &ResumeInfo{Window: "30s", After: "5s", Skipped: []string{"scx"}},
+	}
+	event.addAction("scx", "lavd", nil)
+	event.addAction("tuned", "throughput-performance", errors.New("denied"))
+	event.addRestore("nice", 3, 1, errors.New("no such process"))
+	event.addRestore("timer_slack", 0, 0, nil)
+
+	data, err := event.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"2026-03-01T20:15:00Z","reason":"trigger","from_pill":"default","to_pill":"game",` +
+		`"trigger_pid":4242,"trigger_name":"GameThread","trigger_cmdline":"reaper SteamLaunch AppId=570 -- game.exe",` +
+		`"steam_app_id":"570","parent_pid":4200,` +
+		`"actions":[{"name":"scx","value":"lavd"},{"name":"tuned","value":"throughput-performance","error":"denied"}],` +
+		`"restores":[{"name":"nice","restored":3,"failed":1,"error":"no such process"}],` +
+		`"resume":{"reapply_window":"30s","after":"5s","skipped":["scx"]}}`
+	if string(data) != want {
+		t.Errorf("JSON of the transition changed:\n got %s\nwant %s", data, want)
+	}
+
+	// Without a trigger, the optional fields are left out and the actions stay a list
+	data, err = (&TransitionEvent{Time: event.Time, Reason: reasonStartup, ToPill: "default"}).JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"time":"2026-03-01T20:15:00Z","reason":"startup","from_pill":"","to_pill":"default","actions":[]}`; string(data) != want {
+		t.Errorf("JSON of the startup transition changed:\n got %s\nwant %s", data, want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A process of the trigger's tree, as seen by the daemon
+type treeNode struct {
+	Pid      int32       `json:"pid"`
+	Name     string      `json:"name"`
+	Nice     *int        `json:"nice,omitempty"`
+	InTree   bool        `json:"in_tree"`
+	Reniced  bool        `json:"reniced"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+// The ancestry and descendants of the current trigger process
+type treeView struct {
+	Pill       string      `json:"pill"`
+	Trigger    int32       `json:"trigger_pid"`
+	Root       int32       `json:"root_pid"`
+	RootReason string      `json:"root_reason"`
+	Ancestors  []*treeNode `json:"ancestors"` // From the trigger's parent up
+	Tree       *treeNode   `json:"tree"`
+}
+
+func (pm *PillManager) newTreeNode(pid int32, name string) *treeNode {
+	node := &treeNode{Pid: pid, Name: name}
+	if procInfo, known := pm.knownProcs[pid]; known {
+		node.Name = procInfo.Name
+		node.InTree = procInfo.InTree
+		node.Reniced = procInfo.Reniced
+	}
+	if nice, err := getNice(pid); err == nil {
+		node.Nice = &nice
+	}
+	return node
+}
+
+// Builds the tree of the current trigger process from the process cache
+func (pm *PillManager) currentTree() (*treeView, error) {
+	if pm.currentProc == 0 {
+		return nil, fmt.Errorf("No trigger process, the current pill is %s", pm.CurrentPill)
+	}
+
+	view := &treeView{Pill: pm.CurrentPill, Trigger: pm.currentProc, Root: pm.currentParent, RootReason: pm.rootReason, Ancestors: []*treeNode{}}
+
+	p, err := pm.source.Process(pm.currentProc)
+	if err != nil {
+		return nil, fmt.Errorf("The trigger process %d is gone", pm.currentProc)
+	}
+	for range maxAncestors {
+		parent, err := p.Parent()
+		if err != nil || parent.PID() <= 0 {
+			break
+		}
+		name, _ := parent.Name()
+		view.Ancestors = append(view.Ancestors, pm.newTreeNode(parent.PID(), name))
+		p = parent
+	}
+
+	// Parents of the known processes, to find the descendants of the root
+	children := make(map[int32][]int32)
+	for known := range pm.knownProcs {
+		if stat, err := readProcStat(known); err == nil {
+			children[stat.Ppid] = append(children[stat.Ppid], known)
+		}
+	}
+
+	root := pm.currentParent
+	if root <= 0 {
+		root = pm.currentProc
+	}
+	view.Tree = pm.newTreeNode(root, "")
+
+	nodes := []*treeNode{view.Tree}
+	for i := 0; i < len(nodes); i++ {
+		pids := children[nodes[i].Pid]
+		slices.Sort(pids)
+		for _, child := range pids {
+			node := pm.newTreeNode(child, "")
+			nodes[i].Children = append(nodes[i].Children, node)
+			nodes = append(nodes, node)
+		}
+	}
+
+	return view, nil
+}
+
+// Renders the tree of the current trigger, indented or as JSON
+func (pm *PillManager) explainTree(asJSON bool) string {
+	view, err := pm.currentTree()
+	if err != nil {
+		return err.Error() + "\n"
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(view, "", "  ")
+		if err != nil {
+			return err.Error() + "\n"
+		}
+		return string(data) + "\n"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Pill %s, trigger %d, tree root %d: %s\n", view.Pill, view.Trigger, view.Root, view.RootReason)
+
+	fmt.Fprintf(&b, "Ancestors:\n")
+	for _, node := range view.Ancestors {
+		fmt.Fprintf(&b, "  %s\n", pm.describeNode(node))
+	}
+
+	fmt.Fprintf(&b, "Tree:\n")
+	var render func(node *treeNode, depth int)
+	render = func(node *treeNode, depth int) {
+		fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", depth+1), pm.describeNode(node))
+		for _, child := range node.Children {
+			render(child, depth+1)
+		}
+	}
+	render(view.Tree, 0)
+
+	return b.String()
+}
+
+func (pm *PillManager) describeNode(node *treeNode) string {
+	line := fmt.Sprintf("%d %s", node.Pid, node.Name)
+	if node.Nice != nil {
+		line += fmt.Sprintf(" nice %d", *node.Nice)
+	}
+	if node.Pid == pm.currentProc {
+		line += " [trigger]"
+	}
+	if node.InTree {
+		line += " [in tree]"
+	}
+	if node.Reniced {
+		line += " [reniced]"
+	}
+	return line
+}
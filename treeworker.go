@@ -0,0 +1,104 @@
+package main
+
+import "slices"
+
+// Per-process settings waiting for the tree worker, bounding how far it can lag behind the scans
+const treeQueueSize = 256
+
+// Syscalls changing the processes of the tree in one scan, unless the pill sets renice_budget.
+// A launcher starting dozens of processes at once gets them changed over a few scans.
+const defaultReniceBudget = 50
+
+// Syscalls changing a process of the trigger's tree. They run on the tree worker, and return
+// what to record in the process cache, which is applied by the scan's goroutine.
+type treeJob func() func()
+
+// Runs the tree jobs in order, so that parents are changed before the children queued after them
+func runTreeWorker(jobs <-chan treeJob, results chan<- func()) {
+	for job := range jobs {
+		results <- job()
+	}
+}
+
+// Queues a tree job, applying the finished ones while the queue is full
+func (pm *PillManager) queueTreeJob(job treeJob) {
+	for {
+		select {
+		case pm.treeJobs <- job:
+			pm.treeQueued++
+			return
+		case result := <-pm.treeResults:
+			pm.applyTreeResult(result)
+		}
+	}
+}
+
+func (pm *PillManager) applyTreeResult(result func()) {
+	pm.treeQueued--
+	if result != nil {
+		result()
+	}
+}
+
+// Waits for the queued tree jobs and records their results, before the process cache is used
+func (pm *PillManager) flushTree() {
+	for pm.treeQueued > 0 {
+		pm.applyTreeResult(<-pm.treeResults)
+	}
+}
+
+// Orders the processes that joined the tree so that parents come before their children,
+// whose original nice value depends on their parent's
+func orderTreeMembers(members []*treeMember) []*treeMember {
+	inBatch := make(map[*ProcessInfo]bool, len(members))
+	for _, member := range members {
+		inBatch[member.procInfo] = true
+	}
+
+	ordered := make([]*treeMember, 0, len(members))
+	queued := make(map[*ProcessInfo]bool, len(members))
+	for len(ordered) < len(members) {
+		for _, member := range members {
+			if queued[member.procInfo] {
+				continue
+			}
+			if member.parentInfo != nil && inBatch[member.parentInfo] && !queued[member.parentInfo] {
+				continue
+			}
+			ordered = append(ordered, member)
+			queued[member.procInfo] = true
+		}
+	}
+	return ordered
+}
+
+// Puts the trigger process first, then its direct children, the processes the game needs the
+// soonest when the renice budget leaves some to the next scan. Parents still come first.
+func (pm *PillManager) prioritizeMembers(members []*treeMember) []*treeMember {
+	ordered := orderTreeMembers(members)
+	trigger := pm.knownProcs[pm.currentProc]
+	rank := func(member *treeMember) int {
+		switch {
+		case member.p.PID() == pm.currentProc:
+			return 0
+		case trigger != nil && member.parentInfo == trigger:
+			return 1
+		}
+		return 2
+	}
+	slices.SortStableFunc(ordered, func(a, b *treeMember) int { return rank(a) - rank(b) })
+	return ordered
+}
+
+// Takes the processes of the tree left by the renice budget of the last scan, dropping
+// those that exited since
+func (pm *PillManager) takeTreeBacklog() []*treeMember {
+	var backlog []*treeMember
+	for _, member := range pm.treeBacklog {
+		if pm.currentScan[member.p.PID()] {
+			backlog = append(backlog, member)
+		}
+	}
+	pm.treeBacklog = nil
+	return backlog
+}
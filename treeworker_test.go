@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const treeConfig = `
+scan_interval: 1
+max_tree_size: 0
+triggers:
+  zzgame: game
+pills:
+  default: {scx: rusty}
+  game: {nice: "+5"}
+`
+
+// A manager applying the pills to fake processes, with a tree worker taking delay per syscall
+func newTreeManager(t testing.TB, delay time.Duration) (*PillManager, *fakeSource, *fakeSystem, *callLog) {
+	pm, source := newFakeManager(t, treeConfig)
+	calls := new(callLog)
+	sys := newFakeSystem(t, calls)
+	sys.delay = delay
+	pm.backend = fakeBackend{calls}
+	pm.dryRun = false
+	return pm, source, sys, calls
+}
+
+// The renices in the order of the calls
+func reniced(calls []string) []string {
+	var renices []string
+	for _, call := range calls {
+		if strings.HasPrefix(call, "nice ") {
+			renices = append(renices, call)
+		}
+	}
+	return renices
+}
+
+// The children inherit the nice value of their parent, the parents are changed first even when
+// the PIDs wrapped around and the children come first in the scans. The trigger is alone in its
+// group, reniced as a group.
+func TestTreeParentsFirst(t *testing.T) {
+	pm, source, sys, calls := newTreeManager(t, 0)
+	trigger := source.spawn(300, 50, "zzgame", "zzgame")
+	launcher := source.spawn(200, 300, "launcher", "launcher")
+	worker := source.spawn(120, 200, "worker", "worker")
+	render := source.spawn(110, 120, "render", "render")
+	for i, p := range []*fakeProc{trigger, launcher, worker, render} {
+		sys.proc(p, p.pid, i, 0)
+	}
+
+	for range 5 {
+		expectPill(t, pm, "game", 300)
+	}
+	pm.flushTree()
+	want := []string{"nice group 300 5", "nice 200 6", "nice 120 7", "nice 110 8"}
+	if renices := reniced(calls.list()); !slices.Equal(renices, want) {
+		t.Fatalf("renices %q, want %q", renices, want)
+	}
+}
+
+// The renices still queued when the daemon stops are applied, then restored with the rest
+func TestShutdownDrainsTree(t *testing.T) {
+	pm, source, sys, calls := newTreeManager(t, time.Millisecond)
+	sys.proc(source.spawn(100, 50, "zzgame", "zzgame"), 100, 0, 0)
+	sys.proc(source.spawn(60, 1, "sh", "sh"), 100, 0, 0) // The group goes past the tree, the trigger is reniced alone
+	for pid := int32(101); pid <= 140; pid++ {
+		sys.proc(source.spawn(pid, 100, "worker", "worker"), pid, 0, 0)
+	}
+	expectPill(t, pm, "game", 100)
+	expectPill(t, pm, "game", 100)
+	if pm.treeQueued == 0 {
+		t.Fatal("nothing queued on the tree worker")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pm.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pm.treeQueued != 0 {
+		t.Errorf("%d tree jobs left after the shutdown", pm.treeQueued)
+	}
+	for pid := int32(100); pid <= 140; pid++ {
+		applied := slices.Index(calls.list(), fmt.Sprintf("nice %d 5", pid))
+		restored := slices.Index(calls.list(), fmt.Sprintf("nice %d 0", pid))
+		if applied < 0 || restored < applied || sys.nice[pid] != 0 {
+			t.Errorf("PID %d at nice %d, reniced at call %d and restored at %d", pid, sys.nice[pid], applied, restored)
+		}
+	}
+}
+
+// Scans finding 1000 new processes, size of them joining the tree, with renices taking 50µs
+// each like on a loaded machine. The renices run on the tree worker within renice_budget, the
+// scan doesn't wait on them: its latency barely grows with the size of the tree.
+func BenchmarkScanTree(b *testing.B) {
+	const fresh = 1000
+	for _, size := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			pm, source, sys, calls := newTreeManager(b, 50*time.Microsecond)
+			sys.proc(source.spawn(100, 50, "zzgame", "zzgame"), 100, 0, 0)
+			expectPill(b, pm, "game", 100)
+
+			next := int32(1000)
+			for range b.N {
+				b.StopTimer()
+				pm.flushTree()
+				pm.treeBacklog = nil
+				calls.reset()
+				for _, pid := range source.sortedPids() {
+					if pid > 100 {
+						source.exit(pid)
+					}
+				}
+				for i := range fresh {
+					parent := int32(1)
+					if i < size {
+						parent = 100
+					}
+					source.spawn(next, parent, "worker", "worker")
+					sys.nice[next] = 0
+					next++
+				}
+				b.StartTimer()
+
+				pm.scanProcesses()
+			}
+			b.StopTimer()
+			pm.flushTree()
+		})
+	}
+}